@@ -0,0 +1,315 @@
+// Package alerts evaluates rules against readings and sends
+// events to notifiers when alerts start and stop firing.
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// State is the state of an alert.
+type State string
+
+// The states of an alert.
+const (
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Event is sent to notifiers when an alert starts or stops firing.
+type Event struct {
+	Rule     string    `json:"rule"`
+	SensorID string    `json:"sensor_id"`
+	State    State     `json:"state"`
+	Value    float64   `json:"value"`
+	Message  string    `json:"message"`
+	Time     time.Time `json:"time"`
+	// Since is when the alert started firing.
+	Since   time.Time             `json:"since"`
+	Reading *scrapejestad.Reading `json:"reading,omitempty"`
+}
+
+// Notifier delivers events.
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(ctx context.Context, e Event) error
+
+// Notify calls f.
+func (f NotifierFunc) Notify(ctx context.Context, e Event) error {
+	return f(ctx, e)
+}
+
+// WriterNotifier writes every event as a line of JSON.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterNotifier returns a notifier writing to w.
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// Notify writes the event.
+func (n *WriterNotifier) Notify(_ context.Context, e Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return json.NewEncoder(n.w).Encode(e)
+}
+
+// Binding limits a rule to a set of sensors.
+// An empty set of sensors applies the rule to every sensor.
+type Binding struct {
+	Rule    Rule
+	Sensors []string
+}
+
+func (b Binding) applies(sensor string) bool {
+	if len(b.Sensors) == 0 {
+		return true
+	}
+	for _, s := range b.Sensors {
+		if s == sensor {
+			return true
+		}
+	}
+	return false
+}
+
+// Engine keeps track of active alerts per rule and sensor.
+// Only changes are sent to notifiers: an alert fires once and
+// resolves once, no matter how many readings breach the rule.
+type Engine struct {
+	rules     []Binding
+	notifiers []Notifier
+	clock     scrapejestad.Clock
+	tick      time.Duration
+
+	mu     sync.Mutex
+	last   map[string]scrapejestad.Reading
+	active map[alertKey]*Event
+}
+
+type alertKey struct {
+	rule   string
+	sensor string
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithNotifier adds a notifier that receives all events.
+func WithNotifier(n Notifier) Option {
+	return func(e *Engine) {
+		e.notifiers = append(e.notifiers, n)
+	}
+}
+
+// WithClock sets the clock used for time based rules.
+func WithClock(c scrapejestad.Clock) Option {
+	return func(e *Engine) {
+		e.clock = c
+	}
+}
+
+// WithTickInterval sets how often Run checks time based rules.
+func WithTickInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.tick = d
+	}
+}
+
+// NewEngine returns an engine evaluating the given rules.
+func NewEngine(rules []Binding, opts ...Option) *Engine {
+	e := &Engine{
+		rules:  rules,
+		clock:  scrapejestad.SystemClock,
+		tick:   time.Minute,
+		last:   make(map[string]scrapejestad.Reading),
+		active: make(map[alertKey]*Event),
+	}
+	for _, o := range opts {
+		o(e)
+	}
+	return e
+}
+
+// Active returns the alerts that are currently firing.
+func (e *Engine) Active() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	res := make([]Event, 0, len(e.active))
+	for _, ev := range e.active {
+		res = append(res, *ev)
+	}
+	return res
+}
+
+// Process evaluates the rules for a new reading and notifies about
+// alerts that started or stopped firing. It returns those events.
+// Time based rules are checked against the latest reading of the
+// sensor and only resolve here; they start firing from Tick, so
+// processing old readings doesn't fire them.
+func (e *Engine) Process(ctx context.Context, r scrapejestad.Reading) ([]Event, error) {
+	e.mu.Lock()
+	var events []Event
+	var prev *scrapejestad.Reading
+	if p, ok := e.last[r.SensorID]; ok {
+		prev = &p
+	}
+	if prev == nil || !r.Date.Before(prev.Date) {
+		e.last[r.SensorID] = r
+	}
+	latest := e.last[r.SensorID]
+	now := e.clock.Now()
+	for _, b := range e.rules {
+		if !b.applies(r.SensorID) {
+			continue
+		}
+		firing := e.firing(b.Rule, r.SensorID)
+		var v float64
+		var breach, checked bool
+		reading, at := r, r.Date
+		if rule, ok := b.Rule.(ReadingRule); ok {
+			v, breach, checked = rule.CheckReading(r, prev, firing)
+		}
+		if rule, ok := b.Rule.(TimeRule); ok && firing && !breach {
+			v, breach = rule.CheckTime(latest, now, true)
+			checked, reading, at = true, latest, now
+		}
+		if !checked {
+			continue
+		}
+		if ev := e.transition(b.Rule, reading, v, breach, at); ev != nil {
+			events = append(events, *ev)
+		}
+	}
+	e.mu.Unlock()
+
+	return events, e.notify(ctx, events)
+}
+
+// Tick evaluates time based rules, such as sensors going offline,
+// for every sensor that has sent a reading. Alerts of rules that
+// also check readings are left to resolve in Process.
+func (e *Engine) Tick(ctx context.Context) ([]Event, error) {
+	e.mu.Lock()
+	var events []Event
+	now := e.clock.Now()
+	for _, b := range e.rules {
+		rule, ok := b.Rule.(TimeRule)
+		if !ok {
+			continue
+		}
+		_, both := b.Rule.(ReadingRule)
+		for sensor, r := range e.last {
+			if !b.applies(sensor) {
+				continue
+			}
+			firing := e.firing(rule, sensor)
+			v, breach := rule.CheckTime(r, now, firing)
+			if both && firing && !breach {
+				continue
+			}
+			if ev := e.transition(rule, r, v, breach, now); ev != nil {
+				events = append(events, *ev)
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	return events, e.notify(ctx, events)
+}
+
+// Run processes readings from ch and checks time based rules on every
+// tick until ctx is cancelled or ch is closed. Errors from notifiers
+// are passed to onError, which may be nil.
+func (e *Engine) Run(ctx context.Context, ch <-chan scrapejestad.Reading, onError func(error)) error {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	tick := e.clock.After(e.tick)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if _, err := e.Process(ctx, r); err != nil {
+				onError(err)
+			}
+		case <-tick:
+			if _, err := e.Tick(ctx); err != nil {
+				onError(err)
+			}
+			tick = e.clock.After(e.tick)
+		}
+	}
+}
+
+func (e *Engine) firing(r Rule, sensor string) bool {
+	_, ok := e.active[alertKey{rule: r.Name(), sensor: sensor}]
+	return ok
+}
+
+// transition records a change of state and returns the event to send,
+// or nil if the state of the alert did not change.
+func (e *Engine) transition(r Rule, reading scrapejestad.Reading, value float64, breach bool, at time.Time) *Event {
+	key := alertKey{rule: r.Name(), sensor: reading.SensorID}
+	active, firing := e.active[key]
+	switch {
+	case breach && !firing:
+		ev := &Event{
+			Rule:     r.Name(),
+			SensorID: reading.SensorID,
+			State:    Firing,
+			Value:    value,
+			Message:  describe(r, reading.SensorID, value, Firing),
+			Time:     at,
+			Since:    at,
+			Reading:  &reading,
+		}
+		e.active[key] = ev
+		return ev
+	case !breach && firing:
+		delete(e.active, key)
+		return &Event{
+			Rule:     r.Name(),
+			SensorID: reading.SensorID,
+			State:    Resolved,
+			Value:    value,
+			Message:  describe(r, reading.SensorID, value, Resolved),
+			Time:     at,
+			Since:    active.Since,
+			Reading:  &reading,
+		}
+	}
+	return nil
+}
+
+func (e *Engine) notify(ctx context.Context, events []Event) error {
+	var errs []string
+	for _, ev := range events {
+		for _, n := range e.notifiers {
+			if err := n.Notify(ctx, ev); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error notifying: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return make(chan time.Time)
+}
+
+var start = time.Date(2019, 12, 5, 21, 0, 0, 0, time.UTC)
+
+func reading(sensor string, minutes int, temp, humidity, voltage float32) scrapejestad.Reading {
+	d := start.Add(time.Duration(minutes) * time.Minute)
+	return scrapejestad.Reading{SensorID: sensor, Date: d, Time: d.Unix(), Temp: temp, Humidity: humidity, Voltage: voltage}
+}
+
+func loadEngine(t *testing.T, clock *fakeClock, n Notifier) *Engine {
+	c, err := LoadConfig("testdata/rules.json")
+	if err != nil {
+		t.Fatalf("error loading rules: %v", err)
+	}
+	b, err := c.Bindings()
+	if err != nil {
+		t.Fatalf("error building rules: %v", err)
+	}
+	return NewEngine(b, WithClock(clock), WithNotifier(n))
+}
+
+func summary(events []Event) []string {
+	res := make([]string, len(events))
+	for i, e := range events {
+		res[i] = e.Rule + ":" + string(e.State)
+	}
+	return res
+}
+
+func Test_engine(t *testing.T) {
+	clock := &fakeClock{now: start}
+	var notified []Event
+	e := loadEngine(t, clock, NotifierFunc(func(_ context.Context, ev Event) error {
+		notified = append(notified, ev)
+		return nil
+	}))
+	ctx := context.Background()
+
+	steps := []struct {
+		reading scrapejestad.Reading
+		want    []string
+	}{
+		{reading("242", 0, 0.5, 90, 3.3), nil},
+		{reading("242", 15, -0.5, 90, 3.3), []string{"frost:firing"}},
+		{reading("242", 30, -1, 90, 3.3), nil},
+		// Still within the hysteresis of 0.5 degrees.
+		{reading("242", 45, 0.2, 90, 3.3), nil},
+		{reading("242", 60, 0.6, 107.25, 3.3), []string{"frost:resolved", "saturated:firing"}},
+		{reading("242", 75, 4, 100, 3.0), []string{"saturated:resolved", "temperature-jump:firing", "battery:firing"}},
+		// Battery alerts only apply to sensor 242.
+		{reading("243", 75, 4, 50, 3.0), nil},
+		{reading("242", 90, 4, 100, 3.12), []string{"temperature-jump:resolved"}},
+		{reading("242", 105, 4, 100, 3.2), []string{"battery:resolved"}},
+	}
+
+	for i, s := range steps {
+		clock.now = s.reading.Date
+		events, err := e.Process(ctx, s.reading)
+		if err != nil {
+			t.Fatalf("%d: error processing: %v", i, err)
+		}
+		got := summary(events)
+		if len(got) != len(s.want) {
+			t.Errorf("%d: expected %v, got %v", i, s.want, got)
+			continue
+		}
+		for j := range got {
+			if got[j] != s.want[j] {
+				t.Errorf("%d: expected %v, got %v", i, s.want, got)
+				break
+			}
+		}
+	}
+	if len(notified) != 8 {
+		t.Errorf("expected 8 notifications, got %d", len(notified))
+	}
+
+	clock.now = start.Add(130 * time.Minute)
+	events, err := e.Tick(ctx)
+	if err != nil {
+		t.Fatalf("error ticking: %v", err)
+	}
+	if got := summary(events); len(got) != 1 || got[0] != "offline:firing" || events[0].SensorID != "243" {
+		t.Errorf("expected sensor 243 to go offline, got %v", events)
+	}
+
+	events, _ = e.Tick(ctx)
+	if len(events) != 0 {
+		t.Errorf("expected offline alert not to repeat, got %v", summary(events))
+	}
+
+	clock.now = start.Add(140 * time.Minute)
+	events, _ = e.Process(ctx, reading("243", 140, 4, 50, 3.3))
+	if got := summary(events); len(got) != 1 || got[0] != "offline:resolved" {
+		t.Errorf("expected offline alert to resolve, got %v", got)
+	}
+	if !events[0].Since.Equal(start.Add(130 * time.Minute)) {
+		t.Errorf("expected alert to have fired at 130m, got %v", events[0].Since)
+	}
+}
+
+func Test_engineOldReadings(t *testing.T) {
+	clock := &fakeClock{now: start.Add(24 * time.Hour)}
+	e := NewEngine([]Binding{{Rule: Offline{RuleName: "offline", After: time.Hour}}}, WithClock(clock))
+	ctx := context.Background()
+
+	// History processed oldest first doesn't fire.
+	for i := 0; i < 3; i++ {
+		if events, _ := e.Process(ctx, reading("242", i*15, 4, 50, 3.3)); len(events) != 0 {
+			t.Errorf("%d: expected no events for old readings, got %v", i, summary(events))
+		}
+	}
+	events, _ := e.Tick(ctx)
+	if got := summary(events); len(got) != 1 || got[0] != "offline:firing" {
+		t.Fatalf("expected offline alert to fire on tick, got %v", got)
+	}
+
+	events, _ = e.Process(ctx, reading("242", 24*60, 4, 50, 3.3))
+	if got := summary(events); len(got) != 1 || got[0] != "offline:resolved" {
+		t.Errorf("expected offline alert to resolve, got %v", got)
+	}
+	// An older reading arriving late leaves the sensor online.
+	if events, _ := e.Process(ctx, reading("242", 60, 4, 50, 3.3)); len(events) != 0 {
+		t.Errorf("expected no events for a late old reading, got %v", summary(events))
+	}
+	if events, _ := e.Tick(ctx); len(events) != 0 {
+		t.Errorf("expected no events on tick, got %v", summary(events))
+	}
+}
+
+// coldOrOffline checks both readings and time.
+type coldOrOffline struct {
+	Threshold
+	offline Offline
+}
+
+func (r coldOrOffline) CheckTime(last scrapejestad.Reading, now time.Time, firing bool) (float64, bool) {
+	return r.offline.CheckTime(last, now, firing)
+}
+
+func Test_engineReadingAndTimeRule(t *testing.T) {
+	clock := &fakeClock{now: start}
+	rule := coldOrOffline{
+		Threshold: Threshold{RuleName: "cold", Field: scrapejestad.FieldTemperature, Op: Below, Limit: 0},
+		offline:   Offline{RuleName: "cold", After: time.Hour},
+	}
+	e := NewEngine([]Binding{{Rule: rule}}, WithClock(clock))
+	ctx := context.Background()
+
+	// A minute of -1 ticks instead of processing a reading.
+	steps := []struct {
+		now    time.Duration
+		minute int
+		temp   float32
+		want   string
+	}{
+		{0, 0, -5, "cold:firing"},
+		{10 * time.Minute, -1, 0, ""},
+		{15 * time.Minute, 15, 5, "cold:resolved"},
+		{3 * time.Hour, -1, 0, "cold:firing"},
+		// A late reading that isn't cold leaves the sensor offline.
+		{3 * time.Hour, 30, 5, ""},
+		{3 * time.Hour, 180, 5, "cold:resolved"},
+	}
+	for i, step := range steps {
+		clock.now = start.Add(step.now)
+		var events []Event
+		if step.minute < 0 {
+			events, _ = e.Tick(ctx)
+		} else {
+			events, _ = e.Process(ctx, reading("242", step.minute, step.temp, 50, 3.3))
+		}
+		if got := strings.Join(summary(events), ","); got != step.want {
+			t.Errorf("%d: expected '%s', got '%s'", i, step.want, got)
+		}
+	}
+}
+
+func Test_engineNotifierErrors(t *testing.T) {
+	e := NewEngine([]Binding{{Rule: LowBattery("battery", 3.1, 0)}},
+		WithClock(&fakeClock{now: start}),
+		WithNotifier(NotifierFunc(func(context.Context, Event) error {
+			return errors.New("unreachable")
+		})))
+
+	events, err := e.Process(context.Background(), reading("242", 0, 4, 50, 3.0))
+	if err == nil {
+		t.Errorf("expected error from notifier")
+	}
+	if len(events) != 1 || len(e.Active()) != 1 {
+		t.Errorf("expected alert to fire despite failing notifier")
+	}
+}
+
+func Test_configErrors(t *testing.T) {
+	below := 0.0
+	configs := []Config{
+		{Rules: []RuleConfig{{Name: "a", Type: "threshold", Field: "temperature"}}},
+		{Rules: []RuleConfig{{Name: "a", Type: "threshold", Field: "pressure", Below: &below}}},
+		{Rules: []RuleConfig{{Name: "a", Type: "rate", Field: "temperature"}}},
+		{Rules: []RuleConfig{{Name: "a", Type: "offline"}}},
+		{Rules: []RuleConfig{{Name: "a", Type: "unknown"}}},
+		{Rules: []RuleConfig{{Name: "a", Type: "offline", After: Duration(time.Hour)}, {Name: "a", Type: "offline", After: Duration(time.Hour)}}},
+	}
+	for i, c := range configs {
+		if _, err := c.Bindings(); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
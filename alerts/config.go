@@ -0,0 +1,133 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Duration is a time.Duration written as a string like "30m" in config files.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30m\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config is the content of a rules file.
+type Config struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig describes one rule in a rules file.
+//
+// Type is one of "threshold", "rate", "offline" and "battery".
+// Threshold rules use Field with either Above or Below,
+// rate rules use Field, MaxChange and Per,
+// offline rules use After and battery rules use Below.
+type RuleConfig struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	Field      string   `json:"field,omitempty"`
+	Above      *float64 `json:"above,omitempty"`
+	Below      *float64 `json:"below,omitempty"`
+	Hysteresis float64  `json:"hysteresis,omitempty"`
+	MaxChange  float64  `json:"max_change,omitempty"`
+	Per        Duration `json:"per,omitempty"`
+	After      Duration `json:"after,omitempty"`
+	Sensors    []string `json:"sensors,omitempty"`
+}
+
+// LoadConfig reads a rules file.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var c Config
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("error reading rules from '%s': %v", path, err)
+	}
+	return &c, nil
+}
+
+// Bindings returns the rules of the config.
+func (c *Config) Bindings() ([]Binding, error) {
+	res := make([]Binding, 0, len(c.Rules))
+	names := make(map[string]bool, len(c.Rules))
+	for i, rc := range c.Rules {
+		if rc.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[rc.Name] {
+			return nil, fmt.Errorf("rule '%s' is defined more than once", rc.Name)
+		}
+		names[rc.Name] = true
+
+		r, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s': %v", rc.Name, err)
+		}
+		res = append(res, Binding{Rule: r, Sensors: rc.Sensors})
+	}
+	return res, nil
+}
+
+func (rc RuleConfig) rule() (Rule, error) {
+	switch strings.ToLower(rc.Type) {
+	case "threshold":
+		f, err := scrapejestad.ParseField(rc.Field)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case rc.Above != nil && rc.Below == nil:
+			return Threshold{RuleName: rc.Name, Field: f, Op: Above, Limit: *rc.Above, Hysteresis: rc.Hysteresis}, nil
+		case rc.Below != nil && rc.Above == nil:
+			return Threshold{RuleName: rc.Name, Field: f, Op: Below, Limit: *rc.Below, Hysteresis: rc.Hysteresis}, nil
+		}
+		return nil, fmt.Errorf("threshold needs exactly one of above and below")
+	case "rate":
+		f, err := scrapejestad.ParseField(rc.Field)
+		if err != nil {
+			return nil, err
+		}
+		if rc.MaxChange <= 0 || rc.Per <= 0 {
+			return nil, fmt.Errorf("rate needs a positive max_change and per")
+		}
+		return RateOfChange{RuleName: rc.Name, Field: f, MaxChange: rc.MaxChange, Per: time.Duration(rc.Per), Hysteresis: rc.Hysteresis}, nil
+	case "offline":
+		if rc.After <= 0 {
+			return nil, fmt.Errorf("offline needs a positive after")
+		}
+		return Offline{RuleName: rc.Name, After: time.Duration(rc.After)}, nil
+	case "battery":
+		if rc.Below == nil {
+			return nil, fmt.Errorf("battery needs below")
+		}
+		return LowBattery(rc.Name, *rc.Below, rc.Hysteresis), nil
+	}
+	return nil, fmt.Errorf("unknown rule type '%s'", rc.Type)
+}
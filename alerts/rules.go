@@ -0,0 +1,158 @@
+package alerts
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Rule is a named condition that can be in breach for a sensor.
+// A rule implements ReadingRule, TimeRule or both. A rule that
+// implements both is in breach while either check is.
+type Rule interface {
+	Name() string
+}
+
+// ReadingRule is checked every time a sensor sends a reading.
+type ReadingRule interface {
+	Rule
+	// CheckReading returns the value the rule looked at and whether it is
+	// in breach. firing is set while an alert is active, so rules can apply
+	// hysteresis. ok is false when the rule cannot be checked.
+	CheckReading(cur scrapejestad.Reading, prev *scrapejestad.Reading, firing bool) (value float64, breach bool, ok bool)
+}
+
+// TimeRule is checked periodically, even when no readings arrive.
+type TimeRule interface {
+	Rule
+	// CheckTime returns the value the rule looked at and whether it is in
+	// breach, given the last reading of a sensor and the current time.
+	CheckTime(last scrapejestad.Reading, now time.Time, firing bool) (value float64, breach bool)
+}
+
+// Op is the direction in which a threshold is breached.
+type Op string
+
+// The directions of a threshold.
+const (
+	Above Op = "above"
+	Below Op = "below"
+)
+
+// Threshold is breached when a measurement goes above or below a limit.
+// Once firing, it resolves when the value is back by more than Hysteresis.
+type Threshold struct {
+	RuleName   string
+	Field      scrapejestad.Field
+	Op         Op
+	Limit      float64
+	Hysteresis float64
+}
+
+// Name returns the name of the rule.
+func (t Threshold) Name() string {
+	return t.RuleName
+}
+
+// CheckReading compares the measurement with the limit.
+func (t Threshold) CheckReading(cur scrapejestad.Reading, _ *scrapejestad.Reading, firing bool) (float64, bool, bool) {
+	v, ok := cur.Value(t.Field)
+	if !ok {
+		return 0, false, false
+	}
+	return v, exceeds(t.Op, v, t.Limit, t.Hysteresis, firing), true
+}
+
+// LowBattery returns a rule that is breached when the supply voltage drops below volts.
+func LowBattery(name string, volts, hysteresis float64) Threshold {
+	return Threshold{RuleName: name, Field: scrapejestad.FieldVoltage, Op: Below, Limit: volts, Hysteresis: hysteresis}
+}
+
+// RateOfChange is breached when a measurement changes by more than
+// MaxChange per Per between two consecutive readings of a sensor.
+type RateOfChange struct {
+	RuleName   string
+	Field      scrapejestad.Field
+	MaxChange  float64
+	Per        time.Duration
+	Hysteresis float64
+}
+
+// Name returns the name of the rule.
+func (r RateOfChange) Name() string {
+	return r.RuleName
+}
+
+// CheckReading compares the change since the previous reading with the maximum.
+func (r RateOfChange) CheckReading(cur scrapejestad.Reading, prev *scrapejestad.Reading, firing bool) (float64, bool, bool) {
+	if prev == nil || r.Per <= 0 {
+		return 0, false, false
+	}
+	dt := cur.Date.Sub(prev.Date)
+	if dt <= 0 {
+		return 0, false, false
+	}
+	v, ok := cur.Value(r.Field)
+	if !ok {
+		return 0, false, false
+	}
+	pv, _ := prev.Value(r.Field)
+	rate := (v - pv) / float64(dt) * float64(r.Per)
+	if rate < 0 {
+		rate = -rate
+	}
+	return rate, exceeds(Above, rate, r.MaxChange, r.Hysteresis, firing), true
+}
+
+// Offline is breached when a sensor has not sent a reading for After.
+type Offline struct {
+	RuleName string
+	After    time.Duration
+}
+
+// Name returns the name of the rule.
+func (o Offline) Name() string {
+	return o.RuleName
+}
+
+// CheckTime returns the number of minutes since the last reading.
+func (o Offline) CheckTime(last scrapejestad.Reading, now time.Time, _ bool) (float64, bool) {
+	gap := now.Sub(last.Date)
+	return gap.Minutes(), gap > o.After
+}
+
+func exceeds(op Op, v, limit, hysteresis float64, firing bool) bool {
+	switch op {
+	case Above:
+		if firing {
+			return v > limit-hysteresis
+		}
+		return v > limit
+	case Below:
+		if firing {
+			return v < limit+hysteresis
+		}
+		return v < limit
+	}
+	return false
+}
+
+// describe returns a human readable message for an event.
+func describe(r Rule, sensor string, value float64, state State) string {
+	if state == Resolved {
+		return fmt.Sprintf("%s resolved for sensor %s (%g)", r.Name(), sensor, value)
+	}
+	var cond string
+	switch rule := r.(type) {
+	case Threshold:
+		cond = fmt.Sprintf("%s is %s %g (%g)", rule.Field, rule.Op, rule.Limit, value)
+	case RateOfChange:
+		cond = fmt.Sprintf("%s changes more than %g per %s (%g)", rule.Field, rule.MaxChange, rule.Per, value)
+	case Offline:
+		cond = fmt.Sprintf("no reading for more than %s (%.0fm)", rule.After, value)
+	default:
+		cond = fmt.Sprintf("value %g", value)
+	}
+	return fmt.Sprintf("%s firing for sensor %s: %s", r.Name(), sensor, cond)
+}
@@ -0,0 +1,267 @@
+// Package battery forecasts when the batteries of sensors run down.
+//
+// The supply voltage is the only sign of the battery. It also follows
+// the temperature, so the voltage of each sensor is fitted to a line in
+// time with a term for the temperature, and the line is extended to
+// the cutoff voltage. A sudden rise of the voltage is taken to be a new
+// battery, and only the readings since are fitted.
+package battery
+
+import (
+	"sort"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// DefaultCutoff is the voltage below which sensors stop working reliably.
+const DefaultCutoff = 3.0
+
+// swapWindow is the number of readings on both sides of a rise whose
+// medians are compared to find battery swaps, so a single high reading
+// isn't taken for one.
+const swapWindow = 5
+
+type config struct {
+	cutoff    float64
+	swapJump  float64
+	reference float64
+	minSpan   time.Duration
+	minCount  int
+}
+
+// Option configures Analyze.
+type Option func(*config)
+
+// WithCutoff sets the voltage at which a battery is depleted.
+func WithCutoff(volts float64) Option {
+	return func(c *config) {
+		c.cutoff = volts
+	}
+}
+
+// WithSwapJump sets the rise in voltage taken to be a new battery.
+func WithSwapJump(volts float64) Option {
+	return func(c *config) {
+		c.swapJump = volts
+	}
+}
+
+// WithReferenceTemperature sets the temperature in °C the voltage is
+// compensated to. Forecasts are for this temperature.
+func WithReferenceTemperature(temp float64) Option {
+	return func(c *config) {
+		c.reference = temp
+	}
+}
+
+// WithMinData sets how long and over how many readings a battery must
+// have been seen before its trend is fitted.
+func WithMinData(span time.Duration, readings int) Option {
+	return func(c *config) {
+		c.minSpan = span
+		c.minCount = readings
+	}
+}
+
+// Forecast is the state of the battery of a sensor.
+type Forecast struct {
+	SensorID string `json:"sensor_id"`
+	// Since is the first reading of the current battery and Last the latest.
+	Since    time.Time `json:"since"`
+	Last     time.Time `json:"last"`
+	Readings int       `json:"readings"`
+	// Voltage is the fitted voltage at the last reading, at the
+	// reference temperature. It is the last voltage without a trend.
+	Voltage float64 `json:"voltage"`
+	// Trend reports whether there was enough data to fit a trend.
+	Trend bool `json:"trend"`
+	// Rate is the change of the voltage in volts per day.
+	Rate float64 `json:"rate"`
+	// TemperatureCoefficient is the change of the voltage in volts per °C.
+	TemperatureCoefficient float64 `json:"temperature_coefficient"`
+	// Depleted is when the voltage reaches the cutoff, which may be in
+	// the past. It is zero when it isn't expected to.
+	Depleted time.Time `json:"depleted"`
+	// Swaps are the times batteries were replaced.
+	Swaps []time.Time `json:"swaps,omitempty"`
+}
+
+// Analyze returns a forecast for every sensor, sorted by sensor ID.
+// Readings without a supply voltage are left out.
+func Analyze(readings []scrapejestad.Reading, opts ...Option) []Forecast {
+	c := config{
+		cutoff:    DefaultCutoff,
+		swapJump:  0.3,
+		reference: 15,
+		minSpan:   48 * time.Hour,
+		minCount:  24,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+
+	bySensor := make(map[string][]scrapejestad.Reading)
+	var ids []string
+	for _, r := range readings {
+		if r.Voltage <= 0 {
+			continue
+		}
+		if _, ok := bySensor[r.SensorID]; !ok {
+			ids = append(ids, r.SensorID)
+		}
+		bySensor[r.SensorID] = append(bySensor[r.SensorID], r)
+	}
+	scrapejestad.SortSensorIDs(ids)
+
+	res := make([]Forecast, len(ids))
+	for i, id := range ids {
+		rs := bySensor[id]
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].Date.Before(rs[j].Date)
+		})
+		res[i] = c.forecast(id, rs)
+	}
+	return res
+}
+
+func (c config) forecast(id string, rs []scrapejestad.Reading) Forecast {
+	f := Forecast{SensorID: id}
+	start := 0
+	for _, i := range c.swaps(rs) {
+		f.Swaps = append(f.Swaps, rs[i].Date)
+		start = i
+	}
+	rs = rs[start:]
+	last := rs[len(rs)-1]
+	f.Since, f.Last, f.Readings = rs[0].Date, last.Date, len(rs)
+	f.Voltage = float64(last.Voltage)
+
+	if len(rs) >= c.minCount && f.Last.Sub(f.Since) >= c.minSpan {
+		fit := fitReadings(rs, f.Since)
+		f.Trend = true
+		f.Rate = fit.rate
+		f.TemperatureCoefficient = fit.tempCoef
+		f.Voltage = fit.at(days(f.Last.Sub(f.Since)), c.reference)
+		switch {
+		case fit.rate < 0:
+			d := days(f.Last.Sub(f.Since)) + (c.cutoff-f.Voltage)/fit.rate
+			f.Depleted = f.Since.Add(time.Duration(d * 24 * float64(time.Hour))).Truncate(time.Second)
+		case f.Voltage < c.cutoff:
+			f.Depleted = f.Last
+		}
+	} else if f.Voltage < c.cutoff {
+		f.Depleted = f.Last
+	}
+	return f
+}
+
+// swaps returns the indexes of the readings with a new battery.
+func (c config) swaps(rs []scrapejestad.Reading) []int {
+	var res []int
+	start := 0
+	for i := 1; i < len(rs); i++ {
+		if float64(rs[i].Voltage-rs[i-1].Voltage) <= c.swapJump/2 {
+			continue
+		}
+		before := medianVoltage(rs[maxInt(start, i-swapWindow):i])
+		after := medianVoltage(rs[i:minInt(len(rs), i+swapWindow)])
+		if after-before > c.swapJump {
+			res = append(res, i)
+			start = i
+		}
+	}
+	return res
+}
+
+// fit is a line fitted to the voltage in time and temperature.
+type fit struct {
+	rate, tempCoef float64
+	// The means of the time in days, the temperature and the voltage.
+	t, temp, volts float64
+}
+
+// at returns the fitted voltage after days at temp.
+func (f fit) at(days, temp float64) float64 {
+	return f.volts + f.rate*(days-f.t) + f.tempCoef*(temp-f.temp)
+}
+
+// fitReadings fits the voltage by least squares. The temperature is
+// left out when it hardly varies.
+func fitReadings(rs []scrapejestad.Reading, start time.Time) fit {
+	var f fit
+	n := float64(len(rs))
+	for _, r := range rs {
+		f.t += days(r.Date.Sub(start)) / n
+		f.temp += float64(r.Temp) / n
+		f.volts += float64(r.Voltage) / n
+	}
+	var stt, stT, sTT, stv, sTv float64
+	for _, r := range rs {
+		t := days(r.Date.Sub(start)) - f.t
+		temp := float64(r.Temp) - f.temp
+		v := float64(r.Voltage) - f.volts
+		stt += t * t
+		stT += t * temp
+		sTT += temp * temp
+		stv += t * v
+		sTv += temp * v
+	}
+	if stt == 0 {
+		return f
+	}
+	det := stt*sTT - stT*stT
+	if sTT/n < 0.25 || det <= 1e-9*stt*sTT {
+		f.rate = stv / stt
+		return f
+	}
+	f.rate = (stv*sTT - sTv*stT) / det
+	f.tempCoef = (sTv*stt - stv*stT) / det
+	return f
+}
+
+// Due returns the forecasts of batteries depleted before t, the
+// soonest first.
+func Due(forecasts []Forecast, t time.Time) []Forecast {
+	var res []Forecast
+	for _, f := range forecasts {
+		if !f.Depleted.IsZero() && f.Depleted.Before(t) {
+			res = append(res, f)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Depleted.Before(res[j].Depleted)
+	})
+	return res
+}
+
+func days(d time.Duration) float64 {
+	return d.Hours() / 24
+}
+
+func medianVoltage(rs []scrapejestad.Reading) float64 {
+	vs := make([]float64, len(rs))
+	for i, r := range rs {
+		vs[i] = float64(r.Voltage)
+	}
+	sort.Float64s(vs)
+	n := len(vs)
+	if n%2 == 1 {
+		return vs[n/2]
+	}
+	return (vs[n/2-1] + vs[n/2]) / 2
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
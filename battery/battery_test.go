@@ -0,0 +1,110 @@
+package battery
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/simulator"
+)
+
+var start = time.Date(2019, 12, 5, 0, 0, 0, 0, time.UTC)
+
+// discharge returns hourly readings of a battery losing rate volts per
+// day from volts, and 0.005 V per °C of a daily temperature cycle.
+func discharge(sensor string, from time.Time, hours int, volts, rate float64) []scrapejestad.Reading {
+	var res []scrapejestad.Reading
+	for h := 0; h < hours; h++ {
+		d := from.Add(time.Duration(h) * time.Hour)
+		day := float64(h) / 24
+		temp := 15 + 8*math.Sin(2*math.Pi*day)
+		v := volts + rate*day + 0.005*(temp-15)
+		res = append(res, scrapejestad.Reading{SensorID: sensor, Date: d, Time: d.Unix(), Temp: float32(temp), Voltage: float32(v)})
+	}
+	return res
+}
+
+func Test_Analyze(t *testing.T) {
+	fs := Analyze(discharge("242", start, 10*24, 4, -0.01))
+	if len(fs) != 1 {
+		t.Fatalf("expected 1 forecast, got %d", len(fs))
+	}
+	f := fs[0]
+	if !f.Trend {
+		t.Fatalf("expected a trend")
+	}
+	if math.Abs(f.Rate+0.01) > 0.0005 {
+		t.Errorf("expected a rate of -0.01 V per day, got %v", f.Rate)
+	}
+	if math.Abs(f.TemperatureCoefficient-0.005) > 0.0005 {
+		t.Errorf("expected a temperature coefficient of 0.005 V per °C, got %v", f.TemperatureCoefficient)
+	}
+	// 1 V at 0.01 V per day.
+	expected := start.AddDate(0, 0, 100)
+	if d := f.Depleted.Sub(expected); d < -12*time.Hour || d > 12*time.Hour {
+		t.Errorf("expected depletion around %v, got %v", expected, f.Depleted)
+	}
+	if len(f.Swaps) != 0 {
+		t.Errorf("expected no swaps, got %v", f.Swaps)
+	}
+}
+
+func Test_AnalyzeSwap(t *testing.T) {
+	swap := start.AddDate(0, 0, 5)
+	readings := append(discharge("1", start, 5*24, 3.3, -0.02), discharge("1", swap, 3*24, 4.1, -0.01)...)
+	// A single high reading isn't a new battery.
+	readings[30].Voltage += 0.5
+
+	f := Analyze(readings)[0]
+	if len(f.Swaps) != 1 || !f.Swaps[0].Equal(swap) {
+		t.Fatalf("expected a swap at %v, got %v", swap, f.Swaps)
+	}
+	if !f.Since.Equal(swap) || f.Readings != 3*24 {
+		t.Errorf("expected %d readings since %v, got %d since %v", 3*24, swap, f.Readings, f.Since)
+	}
+	if math.Abs(f.Rate+0.01) > 0.0005 {
+		t.Errorf("expected the rate of the new battery, got %v", f.Rate)
+	}
+}
+
+func Test_AnalyzeTooLittleData(t *testing.T) {
+	fs := Analyze(append(discharge("1", start, 12, 3.8, -0.01), discharge("2", start, 12, 2.9, -0.01)...))
+	if len(fs) != 2 {
+		t.Fatalf("expected 2 forecasts, got %d", len(fs))
+	}
+	if fs[0].Trend || !fs[0].Depleted.IsZero() {
+		t.Errorf("expected no trend or depletion, got %v and %v", fs[0].Trend, fs[0].Depleted)
+	}
+	if !fs[1].Depleted.Equal(fs[1].Last) {
+		t.Errorf("expected a battery below the cutoff to be depleted at %v, got %v", fs[1].Last, fs[1].Depleted)
+	}
+}
+
+func Test_Due(t *testing.T) {
+	readings := discharge("1", start, 5*24, 3.5, -0.02)
+	readings = append(readings, discharge("2", start, 5*24, 3.2, -0.02)...)
+	readings = append(readings, discharge("3", start, 5*24, 4, 0)...)
+	fs := Analyze(readings, WithCutoff(3))
+
+	due := Due(fs, start.AddDate(0, 0, 30))
+	if len(due) != 2 || due[0].SensorID != "2" || due[1].SensorID != "1" {
+		t.Errorf("expected sensors 2 and 1 to be due, got %v", due)
+	}
+	if due := Due(fs, start.AddDate(0, 0, 14)); len(due) != 1 {
+		t.Errorf("expected 1 sensor due within two weeks, got %d", len(due))
+	}
+}
+
+func Test_AnalyzeSimulator(t *testing.T) {
+	sim := simulator.New(3, simulator.WithStart(start), simulator.WithDrain(0.0005))
+	for _, f := range Analyze(sim.Advance(7 * 24 * time.Hour)) {
+		// 0.0005 V per message, 96 messages a day.
+		if f.Rate > -0.04 || f.Rate < -0.06 {
+			t.Errorf("sensor %s: expected a rate around -0.048 V per day, got %v", f.SensorID, f.Rate)
+		}
+		if !f.Depleted.After(sim.Now()) {
+			t.Errorf("sensor %s: expected depletion after %v, got %v", f.SensorID, sim.Now(), f.Depleted)
+		}
+	}
+}
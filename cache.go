@@ -0,0 +1,103 @@
+package scrapejestad
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Cache stores downloaded pages on disk, keyed by the address of the
+// normalized query, so clients of different sites can share it.
+//
+// An entry is fresh for the configured TTL. When the page asks browsers
+// to refresh sooner than that, the refresh interval is used instead.
+// A TTL of zero means only the refresh interval is used.
+type Cache struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewCache creates a cache in dir, creating the directory if needed.
+func NewCache(dir string, ttl time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating cache directory '%s': %v", dir, err)
+	}
+	return &Cache{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+// cacheEntry is a cached response as stored on disk.
+type cacheEntry struct {
+	Key          string        `json:"key"`
+	ETag         string        `json:"etag,omitempty"`
+	LastModified string        `json:"last_modified,omitempty"`
+	FetchedAt    time.Time     `json:"fetched_at"`
+	Refresh      time.Duration `json:"refresh,omitempty"`
+	Body         []byte        `json:"body"`
+}
+
+// lifetime returns how long an entry stays fresh.
+func (c *Cache) lifetime(e *cacheEntry) time.Duration {
+	d := c.ttl
+	if e.Refresh > 0 && (d == 0 || e.Refresh < d) {
+		d = e.Refresh
+	}
+	return d
+}
+
+func (c *Cache) fresh(e *cacheEntry) bool {
+	return c.now().Sub(e.FetchedAt) < c.lifetime(e)
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// get returns the entry stored for key, or nil if there is none.
+// An entry that can't be decoded is treated as missing, so the next
+// response replaces it.
+func (c *Cache) get(key string) (*cacheEntry, error) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, nil
+	}
+	if e.Key != key {
+		return nil, nil
+	}
+	return &e, nil
+}
+
+// put stores an entry, replacing any previous entry atomically.
+func (c *Cache) put(e *cacheEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), c.path(e.Key))
+}
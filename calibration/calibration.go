@@ -0,0 +1,236 @@
+// Package calibration corrects the measurements of sensors that are
+// known to be off, like a sensor reading half a degree too warm against
+// a reference.
+//
+// Corrections are kept per sensor in a Registry, which is usually loaded
+// from a JSON file:
+//
+//	{
+//	  "sensors": {
+//	    "242": [
+//	      {"field": "temperature", "model": "offset", "offset": -0.5, "from": "2019-06-01T00:00:00Z"},
+//	      {"field": "humidity", "model": "humidity", "from": "2019-06-01T00:00:00Z"}
+//	    ]
+//	  }
+//	}
+//
+// Corrected readings keep their raw values in Reading.Extra.
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/meteo"
+)
+
+// Model is a kind of correction.
+type Model string
+
+// The correction models.
+const (
+	// Offset adds Offset to the value.
+	Offset Model = "offset"
+	// Linear multiplies the value by Gain and adds Offset.
+	Linear Model = "linear"
+	// Humidity recomputes the relative humidity for the corrected
+	// temperature, keeping the dew point of the raw values. A sensor
+	// reading too warm also reads too dry.
+	Humidity Model = "humidity"
+)
+
+// Correction corrects a measurement of a sensor during a period.
+type Correction struct {
+	Field  scrapejestad.Field `json:"field"`
+	Model  Model              `json:"model"`
+	Gain   float64            `json:"gain,omitempty"`
+	Offset float64            `json:"offset,omitempty"`
+	// From and To limit the correction to readings taken at From <= time < To.
+	// A zero time leaves that end of the period open.
+	From time.Time `json:"from,omitempty"`
+	To   time.Time `json:"to,omitempty"`
+}
+
+// applies reports whether the correction is in effect at t.
+func (c Correction) applies(t time.Time) bool {
+	return (c.From.IsZero() || !t.Before(c.From)) && (c.To.IsZero() || t.Before(c.To))
+}
+
+// overlaps reports whether the periods of two corrections overlap.
+func (c Correction) overlaps(o Correction) bool {
+	return (c.To.IsZero() || o.From.IsZero() || o.From.Before(c.To)) &&
+		(o.To.IsZero() || c.From.IsZero() || c.From.Before(o.To))
+}
+
+func (c Correction) validate() error {
+	if _, err := scrapejestad.ParseField(string(c.Field)); err != nil {
+		return err
+	}
+	switch c.Model {
+	case Offset:
+	case Linear:
+		if c.Gain == 0 {
+			return fmt.Errorf("linear needs a gain")
+		}
+	case Humidity:
+		if c.Field != scrapejestad.FieldHumidity {
+			return fmt.Errorf("humidity can only correct humidity")
+		}
+	default:
+		return fmt.Errorf("unknown model '%s'", c.Model)
+	}
+	if !c.From.IsZero() && !c.To.IsZero() && !c.From.Before(c.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// Registry holds the corrections by sensor ID.
+type Registry struct {
+	Sensors map[string][]Correction `json:"sensors"`
+}
+
+// Load reads a registry from a JSON file.
+func Load(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r Registry
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&r); err != nil {
+		return nil, fmt.Errorf("error reading calibration from '%s': %v", path, err)
+	}
+	if err := r.Validate(); err != nil {
+		return nil, fmt.Errorf("error reading calibration from '%s': %v", path, err)
+	}
+	return &r, nil
+}
+
+// Validate checks the corrections. A measurement of a sensor may only
+// have one correction at a time.
+func (r *Registry) Validate() error {
+	ids := make([]string, 0, len(r.Sensors))
+	for id := range r.Sensors {
+		ids = append(ids, id)
+	}
+	scrapejestad.SortSensorIDs(ids)
+	for _, id := range ids {
+		cs := r.Sensors[id]
+		for i, c := range cs {
+			if err := c.validate(); err != nil {
+				return fmt.Errorf("sensor %s, correction %d: %v", id, i, err)
+			}
+			for j, o := range cs[:i] {
+				if o.Field == c.Field && o.overlaps(c) {
+					return fmt.Errorf("sensor %s, correction %d: %s overlaps correction %d", id, i, c.Field, j)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// RawName returns the name of the raw value of a measurement in Reading.Extra.
+func RawName(f scrapejestad.Field) string {
+	return "raw_" + string(f)
+}
+
+// Raw returns the value of a measurement before it was corrected.
+func Raw(r scrapejestad.Reading, f scrapejestad.Field) (float64, bool) {
+	if v, ok := r.Extra[RawName(f)]; ok {
+		return v, true
+	}
+	return r.Value(f)
+}
+
+// Correct returns the reading with the corrections in effect when it
+// was taken, and the raw values of the corrected measurements in Extra.
+// Corrections start from the raw values, so correcting a reading twice
+// gives the same result. A nil registry returns the reading unchanged.
+func (r *Registry) Correct(rd scrapejestad.Reading) scrapejestad.Reading {
+	if r == nil {
+		return rd
+	}
+	var active []Correction
+	for _, c := range r.Sensors[rd.SensorID] {
+		if c.applies(rd.Date) {
+			active = append(active, c)
+		}
+	}
+	if len(active) == 0 {
+		return rd
+	}
+	// Humidity corrections need the corrected temperature.
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].Model != Humidity && active[j].Model == Humidity
+	})
+
+	extra := make(map[string]float64, len(rd.Extra)+len(active))
+	for k, v := range rd.Extra {
+		extra[k] = v
+	}
+	raw := rd
+	raw.Extra = extra
+	for _, c := range active {
+		v, _ := Raw(raw, c.Field)
+		if scrapejestad.OptionalField(c.Field) && v == 0 {
+			continue
+		}
+		var corrected float64
+		switch c.Model {
+		case Offset:
+			corrected = v + c.Offset
+		case Linear:
+			corrected = v*c.Gain + c.Offset
+		case Humidity:
+			temp, _ := Raw(raw, scrapejestad.FieldTemperature)
+			dp, ok := meteo.DewPoint(temp, v)
+			if !ok {
+				continue
+			}
+			if corrected, ok = meteo.RelativeHumidity(float64(rd.Temp), dp); !ok {
+				continue
+			}
+			corrected = math.Round(corrected*100) / 100
+		}
+		extra[RawName(c.Field)] = v
+		set(&rd, c.Field, corrected)
+	}
+	rd.Extra = extra
+	return rd
+}
+
+// Apply returns corrected copies of readings.
+func (r *Registry) Apply(readings []scrapejestad.Reading) []scrapejestad.Reading {
+	res := make([]scrapejestad.Reading, len(readings))
+	for i, rd := range readings {
+		res[i] = r.Correct(rd)
+	}
+	return res
+}
+
+func set(r *scrapejestad.Reading, f scrapejestad.Field, v float64) {
+	switch f {
+	case scrapejestad.FieldTemperature:
+		r.Temp = float32(v)
+	case scrapejestad.FieldHumidity:
+		r.Humidity = float32(v)
+	case scrapejestad.FieldLight:
+		r.Light = float32(v)
+	case scrapejestad.FieldPM25:
+		r.PM25 = float32(v)
+	case scrapejestad.FieldPM10:
+		r.PM10 = float32(v)
+	case scrapejestad.FieldVoltage:
+		r.Voltage = float32(v)
+	}
+}
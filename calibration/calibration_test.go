@@ -0,0 +1,145 @@
+package calibration
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+func load(t *testing.T) *Registry {
+	r, err := Load("testdata/calibration.json")
+	if err != nil {
+		t.Fatalf("error loading calibration: %v", err)
+	}
+	return r
+}
+
+func Test_Correct(t *testing.T) {
+	r := load(t)
+	tests := []struct {
+		name     string
+		reading  scrapejestad.Reading
+		temp     float32
+		humidity float32
+		extra    map[string]float64
+	}{
+		{
+			name:     "offset",
+			reading:  scrapejestad.Reading{SensorID: "242", Date: time.Date(2019, 11, 30, 12, 0, 0, 0, time.UTC), Temp: 20.5, Humidity: 50},
+			temp:     20,
+			humidity: 51.57,
+			extra:    map[string]float64{"raw_temperature": 20.5, "raw_humidity": 50},
+		},
+		{
+			name:     "linear",
+			reading:  scrapejestad.Reading{SensorID: "242", Date: time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC), Temp: 10, Humidity: 80},
+			temp:     9.6,
+			humidity: 82.17,
+			extra:    map[string]float64{"raw_temperature": 10, "raw_humidity": 80},
+		},
+		{
+			name:     "other sensor",
+			reading:  scrapejestad.Reading{SensorID: "243", Temp: 10, Humidity: 80},
+			temp:     10,
+			humidity: 80,
+		},
+		{
+			name:     "missing pm25",
+			reading:  scrapejestad.Reading{SensorID: "350", Temp: 10, Humidity: 80},
+			temp:     10,
+			humidity: 80,
+			extra:    map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Correct(tt.reading)
+			if math.Abs(float64(got.Temp-tt.temp)) > 0.001 {
+				t.Errorf("expected temperature %v, got %v", tt.temp, got.Temp)
+			}
+			if math.Abs(float64(got.Humidity-tt.humidity)) > 0.001 {
+				t.Errorf("expected humidity %v, got %v", tt.humidity, got.Humidity)
+			}
+			if diff := cmp.Diff(tt.extra, got.Extra); diff != "" {
+				t.Errorf("unexpected extra values (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(got, r.Correct(got)); diff != "" {
+				t.Errorf("expected correcting twice to change nothing (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_CorrectPM25(t *testing.T) {
+	got := load(t).Correct(scrapejestad.Reading{SensorID: "350", PM25: 10, Extra: map[string]float64{"dew_point": 5}})
+	if got.PM25 != 8 {
+		t.Errorf("expected pm25 of 8, got %v", got.PM25)
+	}
+	if diff := cmp.Diff(map[string]float64{"dew_point": 5, "raw_pm25": 10}, got.Extra); diff != "" {
+		t.Errorf("unexpected extra values (-want +got):\n%s", diff)
+	}
+	if v, _ := Raw(got, scrapejestad.FieldPM25); v != 10 {
+		t.Errorf("expected raw pm25 of 10, got %v", v)
+	}
+}
+
+func Test_Apply(t *testing.T) {
+	readings := []scrapejestad.Reading{{SensorID: "242", Temp: 20.5, Humidity: 50}}
+	res := load(t).Apply(readings)
+	if readings[0].Temp != 20.5 || readings[0].Extra != nil {
+		t.Errorf("expected the readings to be left unchanged, got %v", readings[0])
+	}
+	if res[0].Temp != 20 {
+		t.Errorf("expected temperature 20, got %v", res[0].Temp)
+	}
+
+	var nilRegistry *Registry
+	if got := nilRegistry.Apply(readings); got[0].Temp != 20.5 {
+		t.Errorf("expected a nil registry to change nothing, got %v", got[0].Temp)
+	}
+}
+
+func Test_Validate(t *testing.T) {
+	day := time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		corrections []Correction
+		err         string
+	}{
+		{"unknown field", []Correction{{Field: "pressure", Model: Offset}}, "unknown field"},
+		{"unknown model", []Correction{{Field: scrapejestad.FieldTemperature, Model: "cubic"}}, "unknown model"},
+		{"no gain", []Correction{{Field: scrapejestad.FieldTemperature, Model: Linear, Offset: 1}}, "needs a gain"},
+		{"humidity model on temperature", []Correction{{Field: scrapejestad.FieldTemperature, Model: Humidity}}, "only correct humidity"},
+		{"empty period", []Correction{{Field: scrapejestad.FieldTemperature, Model: Offset, From: day, To: day}}, "from must be before to"},
+		{
+			"overlap",
+			[]Correction{
+				{Field: scrapejestad.FieldTemperature, Model: Offset, To: day.Add(time.Hour)},
+				{Field: scrapejestad.FieldTemperature, Model: Offset, From: day},
+			},
+			"overlaps correction 0",
+		},
+		{
+			"consecutive",
+			[]Correction{
+				{Field: scrapejestad.FieldTemperature, Model: Offset, To: day},
+				{Field: scrapejestad.FieldTemperature, Model: Offset, From: day},
+				{Field: scrapejestad.FieldHumidity, Model: Humidity},
+			},
+			"",
+		},
+	}
+	for _, tt := range tests {
+		err := (&Registry{Sensors: map[string][]Correction{"1": tt.corrections}}).Validate()
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: expected no error, got %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: expected error containing '%s', got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package scrapejestad
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// gatewayMoveDistance is how far in km a gateway may seem to move
+// before it's reported, as OSM links round the coordinates.
+const gatewayMoveDistance = 0.05
+
+// GatewayCatalog collects the gateways that received readings, once per
+// gateway name. Readings can be added as they arrive, in any order, but
+// every reading should only be added once. It is safe for concurrent use.
+type GatewayCatalog struct {
+	mu       sync.Mutex
+	gateways map[string]*catalogEntry
+}
+
+// GatewayEntry describes a gateway in a GatewayCatalog.
+type GatewayEntry struct {
+	Name string `json:"name"`
+	// Position is the latest known position of the gateway.
+	Position Position `json:"coordinates"`
+	// Warnings describe changes of the position.
+	Warnings   []string  `json:"warnings,omitempty"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Receptions int       `json:"receptions"`
+	// Sensors are the IDs of the sensors heard, sorted.
+	Sensors []string `json:"sensors"`
+	// RSSI are taken from RSSICounts, so they are in whole dBm and
+	// don't have the precision of the RSSI the site reports.
+	RSSI RSSIPercentiles `json:"rssi"`
+	// RSSICounts counts the receptions by RSSI, rounded to whole dBm.
+	RSSICounts map[int]int `json:"rssi_counts"`
+}
+
+// RSSIPercentiles are percentiles of the RSSI of receptions in dBm.
+type RSSIPercentiles struct {
+	Min float64 `json:"min"`
+	P10 float64 `json:"p10"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	Max float64 `json:"max"`
+}
+
+type catalogEntry struct {
+	GatewayEntry
+	// positionAt is the time of the reading the position is from.
+	positionAt time.Time
+	// positions are the distinct positions seen, so every move is
+	// reported once.
+	positions []Position
+	sensors   map[string]bool
+}
+
+// NewGatewayCatalog returns an empty catalog. The zero value is an
+// empty catalog too.
+func NewGatewayCatalog() *GatewayCatalog {
+	return &GatewayCatalog{gateways: make(map[string]*catalogEntry)}
+}
+
+// Add adds the receptions of readings to the catalog.
+func (c *GatewayCatalog) Add(readings ...Reading) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gateways == nil {
+		c.gateways = make(map[string]*catalogEntry)
+	}
+	for _, r := range readings {
+		for _, g := range r.Gateways {
+			if g.Name == "" {
+				continue
+			}
+			e, ok := c.gateways[g.Name]
+			if !ok {
+				e = &catalogEntry{
+					GatewayEntry: GatewayEntry{Name: g.Name, FirstSeen: r.Date, LastSeen: r.Date, RSSICounts: make(map[int]int)},
+					sensors:      make(map[string]bool),
+				}
+				c.gateways[g.Name] = e
+			}
+			e.add(r, g)
+		}
+	}
+}
+
+func (e *catalogEntry) add(r Reading, g Gateway) {
+	if r.Date.Before(e.FirstSeen) {
+		e.FirstSeen = r.Date
+	}
+	if r.Date.After(e.LastSeen) {
+		e.LastSeen = r.Date
+	}
+	e.Receptions++
+	e.RSSICounts[int(math.Round(float64(g.RSSI)))]++
+	if r.SensorID != "" && !e.sensors[r.SensorID] {
+		e.sensors[r.SensorID] = true
+		e.Sensors = append(e.Sensors, r.SensorID)
+	}
+
+	if g.Position == (Position{}) {
+		return
+	}
+	if e.Position == (Position{}) {
+		e.Position, e.positionAt = g.Position, r.Date
+		e.positions = append(e.positions, g.Position)
+		return
+	}
+	if d := distance(e.Position, g.Position); d > gatewayMoveDistance && e.newPosition(g.Position) {
+		e.positions = append(e.positions, g.Position)
+		from, to := e.Position, g.Position
+		if r.Date.Before(e.positionAt) {
+			from, to = to, from
+		}
+		e.Warnings = append(e.Warnings, fmt.Sprintf("position changed by %.2f km from %s to %s around %s", d, from, to, r.Date.Format(time.RFC3339)))
+	}
+	if !r.Date.Before(e.positionAt) {
+		e.Position, e.positionAt = g.Position, r.Date
+	}
+}
+
+// newPosition reports whether p is far from all positions seen.
+func (e *catalogEntry) newPosition(p Position) bool {
+	for _, q := range e.positions {
+		if distance(p, q) <= gatewayMoveDistance {
+			return false
+		}
+	}
+	return true
+}
+
+// Gateways returns the gateways in the catalog sorted by name.
+func (c *GatewayCatalog) Gateways() []GatewayEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.gateways))
+	for n := range c.gateways {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	res := make([]GatewayEntry, len(names))
+	for i, n := range names {
+		res[i] = c.gateways[n].entry()
+	}
+	return res
+}
+
+// Gateway returns the gateway with the given name.
+func (c *GatewayCatalog) Gateway(name string) (GatewayEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.gateways[name]
+	if !ok {
+		return GatewayEntry{}, false
+	}
+	return e.entry(), true
+}
+
+// entry returns a copy of the entry with its percentiles.
+func (e *catalogEntry) entry() GatewayEntry {
+	res := e.GatewayEntry
+	res.Warnings = append([]string(nil), e.Warnings...)
+	res.Sensors = SortSensorIDs(append([]string{}, e.Sensors...))
+	res.RSSICounts = make(map[int]int, len(e.RSSICounts))
+	for k, v := range e.RSSICounts {
+		res.RSSICounts[k] = v
+	}
+	res.RSSI = percentiles(e.RSSICounts)
+	return res
+}
+
+// percentiles returns the percentiles of a histogram, using the nearest rank.
+func percentiles(counts map[int]int) RSSIPercentiles {
+	values := make([]int, 0, len(counts))
+	n := 0
+	for v, c := range counts {
+		values = append(values, v)
+		n += c
+	}
+	if n == 0 {
+		return RSSIPercentiles{}
+	}
+	sort.Ints(values)
+	rank := func(p float64) float64 {
+		r := int(math.Ceil(p / 100 * float64(n)))
+		if r < 1 {
+			r = 1
+		}
+		seen := 0
+		for _, v := range values {
+			seen += counts[v]
+			if seen >= r {
+				return float64(v)
+			}
+		}
+		return float64(values[len(values)-1])
+	}
+	return RSSIPercentiles{Min: float64(values[0]), P10: rank(10), P50: rank(50), P90: rank(90), Max: float64(values[len(values)-1])}
+}
+
+// MarshalJSON writes the gateways sorted by name.
+func (c *GatewayCatalog) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Gateways())
+}
+
+// UnmarshalJSON replaces the gateways with the ones written by
+// MarshalJSON, so a saved catalog can be added to.
+func (c *GatewayCatalog) UnmarshalJSON(b []byte) error {
+	var entries []GatewayEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	gateways := make(map[string]*catalogEntry, len(entries))
+	for _, g := range entries {
+		if g.Name == "" {
+			return fmt.Errorf("gateway without a name")
+		}
+		e := &catalogEntry{GatewayEntry: g, positionAt: g.LastSeen, sensors: make(map[string]bool, len(g.Sensors))}
+		if g.Position != (Position{}) {
+			e.positions = []Position{g.Position}
+		}
+		if e.RSSICounts == nil {
+			e.RSSICounts = make(map[int]int)
+		}
+		for _, id := range g.Sensors {
+			e.sensors[id] = true
+		}
+		gateways[g.Name] = e
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gateways = gateways
+	return nil
+}
+
+// distance returns the great-circle distance between two positions in km.
+func distance(a, b Position) float64 {
+	const earthRadius = 6371.0
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLng := lat2-lat1, radians(b.Lng)-radians(a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+func radians(deg float32) float64 {
+	return float64(deg) * math.Pi / 180
+}
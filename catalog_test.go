@@ -0,0 +1,122 @@
+package scrapejestad
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func catalogReading(sensor string, minutes int, gateways ...Gateway) Reading {
+	d := time.Date(2019, 12, 5, 21, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)
+	return Reading{SensorID: sensor, Date: d, Time: d.Unix(), Gateways: gateways}
+}
+
+func Test_GatewayCatalogExample(t *testing.T) {
+	f, err := os.Open("testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	defer f.Close()
+	readings, err := Parse(f)
+	if err != nil {
+		t.Fatalf("error parsing testdata: %v", err)
+	}
+
+	c := NewGatewayCatalog()
+	c.Add(readings...)
+	receptions := 0
+	for _, r := range readings {
+		receptions += len(r.Gateways)
+	}
+	total := 0
+	for _, g := range c.Gateways() {
+		total += g.Receptions
+		if len(g.Warnings) > 0 {
+			t.Errorf("%s: expected no warnings, got %v", g.Name, g.Warnings)
+		}
+	}
+	if total != receptions {
+		t.Errorf("expected %d receptions, got %d", receptions, total)
+	}
+	g, ok := c.Gateway("florvaag-1")
+	if !ok {
+		t.Fatalf("expected florvaag-1 in the catalog")
+	}
+	if g.Position != (Position{Lat: 60.431778, Lng: 5.231865}) {
+		t.Errorf("unexpected position %v", g.Position)
+	}
+}
+
+func Test_GatewayCatalog(t *testing.T) {
+	home := Position{Lat: 60.431778, Lng: 5.231865}
+	moved := Position{Lat: 60.441778, Lng: 5.231865}
+	c := NewGatewayCatalog()
+	c.Add(
+		catalogReading("10", 30, Gateway{Name: "a", Position: moved, RSSI: -100}),
+		catalogReading("10", 0, Gateway{Name: "a", Position: home, RSSI: -50.4}, Gateway{Name: "b", RSSI: -80}),
+		catalogReading("2", 10, Gateway{Name: "a", RSSI: -70}),
+		catalogReading("2", 20, Gateway{Name: "a", Position: Position{Lat: 60.4318, Lng: 5.23187}, RSSI: -90}),
+	)
+	// Added later, as from a watcher.
+	c.Add(catalogReading("3", 40, Gateway{Name: "a", Position: moved, RSSI: -60}))
+
+	got := c.Gateways()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 gateways, got %d", len(got))
+	}
+	a := got[0]
+	if len(a.Warnings) != 1 || !strings.Contains(a.Warnings[0], "1.11 km") {
+		t.Errorf("expected a warning about the move, got %v", a.Warnings)
+	}
+	a.Warnings = nil
+	expected := GatewayEntry{
+		Name:       "a",
+		Position:   moved,
+		FirstSeen:  catalogReading("", 0).Date,
+		LastSeen:   catalogReading("", 40).Date,
+		Receptions: 5,
+		Sensors:    []string{"2", "3", "10"},
+		RSSI:       RSSIPercentiles{Min: -100, P10: -100, P50: -70, P90: -50, Max: -50},
+		RSSICounts: map[int]int{-100: 1, -90: 1, -70: 1, -60: 1, -50: 1},
+	}
+	if diff := cmp.Diff(expected, a); diff != "" {
+		t.Errorf("unexpected gateway (-want +got):\n%s", diff)
+	}
+	if got[1].Position != (Position{}) || got[1].Receptions != 1 {
+		t.Errorf("expected b without a position and with 1 reception, got %v", got[1])
+	}
+	if _, ok := c.Gateway("c"); ok {
+		t.Errorf("expected no gateway c")
+	}
+}
+
+func Test_GatewayCatalogJSON(t *testing.T) {
+	c := NewGatewayCatalog()
+	c.Add(catalogReading("1", 0, Gateway{Name: "a", Position: Position{Lat: 60, Lng: 5}, RSSI: -50}))
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatalf("error encoding catalog: %v", err)
+	}
+
+	var loaded GatewayCatalog
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	if diff := cmp.Diff(c.Gateways(), loaded.Gateways()); diff != "" {
+		t.Errorf("unexpected gateways after decoding (-want +got):\n%s", diff)
+	}
+
+	loaded.Add(catalogReading("2", 10, Gateway{Name: "a", Position: Position{Lat: 60, Lng: 5}, RSSI: -60}))
+	a, _ := loaded.Gateway("a")
+	if a.Receptions != 2 || len(a.Sensors) != 2 || a.RSSI.Min != -60 || len(a.Warnings) != 0 {
+		t.Errorf("expected the loaded catalog to be added to, got %v", a)
+	}
+
+	if err := json.Unmarshal([]byte(`[{"receptions": 1}]`), &loaded); err == nil {
+		t.Errorf("expected an error for a gateway without a name")
+	}
+}
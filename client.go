@@ -0,0 +1,209 @@
+package scrapejestad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Client downloads and parses pages from meetjestad.net.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	cache   *Cache
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the address of the sensors_recent page.
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		c.baseURL = u
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for requests.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) {
+		c.http = h
+	}
+}
+
+// WithTimeout sets the timeout of each request.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		h := *c.http
+		h.Timeout = d
+		c.http = &h
+	}
+}
+
+// WithCache stores responses in the given cache.
+func WithCache(cache *Cache) Option {
+	return func(c *Client) {
+		c.cache = cache
+	}
+}
+
+// NewClient returns a client for meetjestad.net.
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL: DefaultBaseURL,
+		http:    &http.Client{Timeout: time.Second * 2},
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Result is a parsed page together with information about where it came from.
+type Result struct {
+	Page
+	FetchedAt time.Time
+	// Cached is set when the page was served from the cache.
+	Cached bool
+	// Stale is set when the cached page was served because upstream failed.
+	Stale bool
+}
+
+// StatusError is returned when upstream responds with an unexpected status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("error reading '%s': unexpected status %d", e.URL, e.StatusCode)
+}
+
+// Readings fetches the readings matching the query.
+func (c *Client) Readings(ctx context.Context, q Query) ([]Reading, error) {
+	res, err := c.Fetch(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	return res.Readings, nil
+}
+
+// Fetch downloads and parses the page matching the query.
+//
+// With a cache configured, fresh entries are served without contacting
+// upstream, expired entries are revalidated with a conditional request,
+// and an expired entry is served flagged as stale when upstream fails.
+func (c *Client) Fetch(ctx context.Context, q Query) (*Result, error) {
+	q = q.Normalize()
+	u, err := q.URL(c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	// Clients with different base URLs may share a cache.
+	key := u.String()
+
+	var entry *cacheEntry
+	if c.cache != nil {
+		e, err := c.cache.get(key)
+		if err != nil {
+			return nil, err
+		}
+		entry = e
+		if entry != nil && c.cache.fresh(entry) {
+			return c.fromEntry(q, entry, false)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		// A cancelled caller gets the error, not stale data.
+		if entry != nil && ctx.Err() == nil {
+			return c.fromEntry(q, entry, true)
+		}
+		return nil, fmt.Errorf("error reading '%s': %w", u.String(), err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified && entry != nil:
+		entry.FetchedAt = c.cache.now()
+		if err := c.cache.put(entry); err != nil {
+			return nil, err
+		}
+		return c.fromEntry(q, entry, false)
+	case res.StatusCode >= 500 && entry != nil:
+		return c.fromEntry(q, entry, true)
+	case res.StatusCode != http.StatusOK:
+		return nil, &StatusError{URL: u.String(), StatusCode: res.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		if entry != nil && ctx.Err() == nil {
+			return c.fromEntry(q, entry, true)
+		}
+		return nil, fmt.Errorf("error reading '%s': %w", u.String(), err)
+	}
+	page, err := parseBody(q.Format, body)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if c.cache != nil {
+		now = c.cache.now()
+		err := c.cache.put(&cacheEntry{
+			Key:          key,
+			ETag:         res.Header.Get("ETag"),
+			LastModified: res.Header.Get("Last-Modified"),
+			FetchedAt:    now,
+			Refresh:      page.Refresh,
+			Body:         body,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Result{Page: *page, FetchedAt: now}, nil
+}
+
+func (c *Client) fromEntry(q Query, e *cacheEntry, stale bool) (*Result, error) {
+	page, err := parseBody(q.Format, e.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Result{Page: *page, FetchedAt: e.FetchedAt, Cached: true, Stale: stale}, nil
+}
+
+func parseBody(f Format, body []byte) (*Page, error) {
+	if f == FormatJSON {
+		var doc []JsonReading
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return nil, fmt.Errorf("error unmarshaling data: '%v'", err)
+		}
+		readings, err := mapJsonReadingsToReadings(doc)
+		if err != nil {
+			return nil, err
+		}
+		return &Page{Readings: readings}, nil
+	}
+	return ParsePage(bytes.NewReader(body))
+}
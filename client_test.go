@@ -0,0 +1,166 @@
+package scrapejestad
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type upstream struct {
+	page     []byte
+	requests int
+	notMod   int
+	down     bool
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.requests++
+	if u.down {
+		http.Error(w, "down", http.StatusBadGateway)
+		return
+	}
+	if r.Header.Get("If-None-Match") == `"v1"` {
+		u.notMod++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", `"v1"`)
+	w.Write(u.page)
+}
+
+func newCachedClient(t *testing.T, u *upstream, ttl time.Duration) (*Client, *time.Time) {
+	srv := httptest.NewServer(u)
+	t.Cleanup(srv.Close)
+
+	cache, err := NewCache(t.TempDir(), ttl)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	now := time.Date(2019, 12, 5, 21, 20, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return NewClient(WithBaseURL(srv.URL), WithCache(cache)), &now
+}
+
+func Test_clientCache(t *testing.T) {
+	page, err := os.ReadFile("testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	u := &upstream{page: page}
+	c, now := newCachedClient(t, u, 5*time.Minute)
+	q := Query{Sensors: []int{242}, Limit: 50}
+	ctx := context.Background()
+
+	res, err := c.Fetch(ctx, q)
+	if err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+	if res.Cached || len(res.Readings) != 2 {
+		t.Errorf("expected 2 fresh readings, got %d (cached=%v)", len(res.Readings), res.Cached)
+	}
+	if res.Refresh != time.Minute {
+		t.Errorf("expected refresh of 1m, got %v", res.Refresh)
+	}
+
+	// The page asks for a refresh after 60 seconds, which is shorter than the TTL.
+	*now = now.Add(30 * time.Second)
+	res, err = c.Fetch(ctx, Query{Sensors: []int{242, 242}, Limit: 50})
+	if err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+	if !res.Cached || u.requests != 1 {
+		t.Errorf("expected cached response without request, got cached=%v requests=%d", res.Cached, u.requests)
+	}
+
+	*now = now.Add(time.Minute)
+	res, err = c.Fetch(ctx, q)
+	if err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+	if u.notMod != 1 || !res.Cached || res.Stale {
+		t.Errorf("expected revalidated response, got notModified=%d cached=%v stale=%v", u.notMod, res.Cached, res.Stale)
+	}
+	if len(res.Readings) != 2 {
+		t.Errorf("expected 2 readings, got %d", len(res.Readings))
+	}
+
+	*now = now.Add(2 * time.Minute)
+	u.down = true
+	res, err = c.Fetch(ctx, q)
+	if err != nil {
+		t.Fatalf("expected stale copy, got error: %v", err)
+	}
+	if !res.Stale || len(res.Readings) != 2 {
+		t.Errorf("expected 2 stale readings, got %d (stale=%v)", len(res.Readings), res.Stale)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Fetch(cancelled, q); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled instead of stale copy, got %v", err)
+	}
+}
+
+func Test_clientUpstreamDownWithoutCache(t *testing.T) {
+	u := &upstream{down: true}
+	srv := httptest.NewServer(u)
+	defer srv.Close()
+
+	_, err := NewClient(WithBaseURL(srv.URL)).Fetch(context.Background(), Query{})
+	se, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("expected status error, got %v", err)
+	}
+	if se.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", se.StatusCode)
+	}
+}
+
+func Test_clientCacheSharedAndCorrupt(t *testing.T) {
+	page, err := os.ReadFile("testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	dir := t.TempDir()
+	cache, err := NewCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating cache: %v", err)
+	}
+	a, b := &upstream{page: page}, &upstream{page: page}
+	srvA, srvB := httptest.NewServer(a), httptest.NewServer(b)
+	defer srvA.Close()
+	defer srvB.Close()
+	ctx := context.Background()
+
+	// Clients of different sites don't serve each other's pages.
+	for _, srv := range []*httptest.Server{srvA, srvB} {
+		if _, err := NewClient(WithBaseURL(srv.URL), WithCache(cache)).Fetch(ctx, Query{}); err != nil {
+			t.Fatalf("error fetching: %v", err)
+		}
+	}
+	if a.requests != 1 || b.requests != 1 {
+		t.Errorf("expected a request to each site, got %d and %d", a.requests, b.requests)
+	}
+
+	// A corrupt entry is fetched again and replaced.
+	u, err := Query{}.Normalize().URL(srvA.URL)
+	if err != nil {
+		t.Fatalf("error building URL: %v", err)
+	}
+	if err := os.WriteFile(cache.path(u.String()), []byte("{"), 0644); err != nil {
+		t.Fatalf("error corrupting entry: %v", err)
+	}
+	c := NewClient(WithBaseURL(srvA.URL), WithCache(cache))
+	for i := 0; i < 2; i++ {
+		if res, err := c.Fetch(ctx, Query{}); err != nil || len(res.Readings) != 2 {
+			t.Fatalf("%d: expected 2 readings, got %v", i, err)
+		}
+	}
+	if a.requests != 2 {
+		t.Errorf("expected the corrupt entry to be replaced with one request, got %d", a.requests-1)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func runExport(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cf clientFlags
+	cf.register(fs)
+	out := fs.String("o", "", "file to write, required")
+	format := fs.String("format", "", "output format, guessed from the file extension if empty: "+formatNames())
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *out == "" {
+		fmt.Fprintln(stderr, "missing output file, use -o")
+		return exitUsage
+	}
+
+	w, err := lookupWriter(*format, *out)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	readings, code := fetch(ctx, &cf, stderr)
+	if code != exitOK && code != exitStale {
+		return code
+	}
+
+	// Write to a temporary file first so a failed export never leaves a partial file.
+	f, err := ioutil.TempFile(filepath.Dir(*out), ".export-*")
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	defer os.Remove(f.Name())
+	// Temporary files are only readable by their owner.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if err := w(f, readings); err != nil {
+		f.Close()
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	if err := os.Rename(f.Name(), *out); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	fmt.Fprintf(stdout, "wrote %d readings to %s\n", len(readings), *out)
+	return code
+}
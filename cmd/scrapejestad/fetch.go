@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+func runFetch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cf clientFlags
+	cf.register(fs)
+	format := fs.String("format", "text", "output format: "+formatNames())
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	w, err := lookupWriter(*format, "")
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	readings, code := fetch(ctx, &cf, stderr)
+	if code != exitOK && code != exitStale {
+		return code
+	}
+	if err := w(stdout, readings); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	return code
+}
@@ -0,0 +1,225 @@
+// Command scrapejestad fetches, watches and exports data from meetjestad.net.
+//
+// Usage:
+//
+//	scrapejestad <command> [flags]
+//
+// The commands are:
+//
+//	fetch   print the readings matching a query
+//	watch   print new readings as they arrive
+//	export  write the readings matching a query to a file
+//	serve   serve a JSON HTTP API
+//
+// Exit codes:
+//
+//	0  success
+//	1  unexpected error
+//	2  invalid usage
+//	3  meetjestad.net could not be reached or returned an error
+//	4  data was served from a stale cache because meetjestad.net failed
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/calibration"
+)
+
+// Exit codes scripts can rely on.
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitUpstream = 3
+	exitStale    = 4
+)
+
+const usage = `Usage: scrapejestad <command> [flags]
+
+Commands:
+  fetch   print the readings matching a query
+  watch   print new readings as they arrive
+  export  write the readings matching a query to a file
+  serve   serve a JSON HTTP API
+
+Run 'scrapejestad <command> -h' for the flags of a command.
+`
+
+type command func(ctx context.Context, args []string, stdout, stderr io.Writer) int
+
+var commands = map[string]command{
+	"fetch":  runFetch,
+	"watch":  runWatch,
+	"export": runExport,
+	"serve":  runServe,
+}
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command '%s'\n\n%s", args[0], usage)
+		return exitUsage
+	}
+	return cmd(ctx, args[1:], stdout, stderr)
+}
+
+// clientFlags are the flags shared by all commands.
+type clientFlags struct {
+	baseURL  string
+	timeout  time.Duration
+	cacheDir string
+	cacheTTL time.Duration
+	sensors  string
+	limit    int
+	gateways string
+	jsonAPI  bool
+	calFile  string
+}
+
+func (f *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.baseURL, "base-url", scrapejestad.DefaultBaseURL, "address of the sensors_recent page")
+	fs.DurationVar(&f.timeout, "timeout", 10*time.Second, "timeout of each request")
+	fs.StringVar(&f.cacheDir, "cache-dir", "", "directory to cache responses in")
+	fs.DurationVar(&f.cacheTTL, "cache-ttl", time.Minute, "how long cached responses are fresh")
+	fs.StringVar(&f.sensors, "sensors", "", "sensor IDs and ranges, like 242,350-360")
+	fs.IntVar(&f.limit, "limit", 0, "maximum number of readings")
+	fs.StringVar(&f.gateways, "gateways", "", "comma separated gateway names to filter by")
+	fs.BoolVar(&f.jsonAPI, "json-api", false, "use the JSON API, which has no gateway data")
+	fs.StringVar(&f.calFile, "calibration", "", "JSON file of corrections to apply to the readings")
+}
+
+func (f *clientFlags) client() (*scrapejestad.Client, error) {
+	opts := []scrapejestad.Option{
+		scrapejestad.WithBaseURL(f.baseURL),
+		scrapejestad.WithTimeout(f.timeout),
+	}
+	if f.cacheDir != "" {
+		cache, err := scrapejestad.NewCache(f.cacheDir, f.cacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, scrapejestad.WithCache(cache))
+	}
+	return scrapejestad.NewClient(opts...), nil
+}
+
+// calibration loads the corrections, or returns nil without a file.
+func (f *clientFlags) calibration() (*calibration.Registry, error) {
+	if f.calFile == "" {
+		return nil, nil
+	}
+	return calibration.Load(f.calFile)
+}
+
+func (f *clientFlags) query() (scrapejestad.Query, error) {
+	var q scrapejestad.Query
+	sensors, err := scrapejestad.ParseSensors(f.sensors)
+	if err != nil {
+		return q, err
+	}
+	q.Sensors = sensors
+	q.Limit = f.limit
+	if f.gateways != "" {
+		q.Gateways = strings.Split(f.gateways, ",")
+	}
+	if f.jsonAPI {
+		q.Format = scrapejestad.FormatJSON
+	}
+	return q, nil
+}
+
+// parseFlags parses args and reports usage errors.
+// It returns false with the exit code if the command should stop.
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// fetch runs the query and returns the readings and the exit code to use when successful.
+func fetch(ctx context.Context, f *clientFlags, stderr io.Writer) ([]scrapejestad.Reading, int) {
+	q, err := f.query()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return nil, exitUsage
+	}
+	cal, err := f.calibration()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return nil, exitUsage
+	}
+	c, err := f.client()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return nil, exitError
+	}
+	res, err := c.Fetch(ctx, q)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return nil, exitCode(err)
+	}
+	for _, w := range res.Warnings {
+		fmt.Fprintf(stderr, "warning: %s\n", w)
+	}
+	if res.Stale {
+		fmt.Fprintf(stderr, "warning: serving stale data fetched at %s\n", res.FetchedAt.Format(time.RFC3339))
+		return cal.Apply(res.Readings), exitStale
+	}
+	return cal.Apply(res.Readings), exitOK
+}
+
+// exitCode returns the exit code for an error.
+func exitCode(err error) int {
+	var se *scrapejestad.StatusError
+	var ue *url.Error
+	var ne net.Error
+	switch {
+	case errors.As(err, &se), errors.As(err, &ue), errors.As(err, &ne):
+		return exitUpstream
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return exitUpstream
+	}
+	return exitError
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+func newUpstream(t *testing.T, status *int) *httptest.Server {
+	page, err := ioutil.ReadFile("../../testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	// Without the refresh hint a cache TTL of zero makes every entry expire immediately.
+	page = bytes.Replace(page, []byte(`<meta http-equiv="refresh" content="60">`), nil, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *status != http.StatusOK {
+			w.WriteHeader(*status)
+			return
+		}
+		w.Write(page)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func Test_exitCodes(t *testing.T) {
+	status := http.StatusOK
+	srv := newUpstream(t, &status)
+	cacheDir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		args   []string
+		status int
+		code   int
+	}{
+		{"no command", nil, http.StatusOK, exitUsage},
+		{"unknown command", []string{"nope"}, http.StatusOK, exitUsage},
+		{"help", []string{"fetch", "-h"}, http.StatusOK, exitOK},
+		{"bad flag", []string{"fetch", "-nope"}, http.StatusOK, exitUsage},
+		{"bad sensors", []string{"fetch", "-base-url", srv.URL, "-sensors", "a-b"}, http.StatusOK, exitUsage},
+		{"bad calibration", []string{"fetch", "-base-url", srv.URL, "-calibration", filepath.Join(cacheDir, "nope.json")}, http.StatusOK, exitUsage},
+		{"bad format", []string{"fetch", "-base-url", srv.URL, "-format", "xml"}, http.StatusOK, exitUsage},
+		{"ok", []string{"fetch", "-base-url", srv.URL, "-cache-dir", cacheDir, "-cache-ttl", "0s"}, http.StatusOK, exitOK},
+		{"upstream down", []string{"fetch", "-base-url", srv.URL}, http.StatusBadGateway, exitUpstream},
+		{"stale", []string{"fetch", "-base-url", srv.URL, "-cache-dir", cacheDir, "-cache-ttl", "0s"}, http.StatusBadGateway, exitStale},
+		{"unreachable", []string{"fetch", "-base-url", "http://127.0.0.1:1/"}, http.StatusOK, exitUpstream},
+		{"bad interval", []string{"watch", "-base-url", srv.URL, "-interval", "0s"}, http.StatusOK, exitUsage},
+		{"bad serve interval", []string{"serve", "-interval", "-1m"}, http.StatusOK, exitUsage},
+	}
+	for _, tt := range tests {
+		status = tt.status
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), tt.args, &stdout, &stderr); code != tt.code {
+			t.Errorf("%s: expected exit code %d, got %d: %s", tt.name, tt.code, code, stderr.String())
+		}
+	}
+}
+
+func Test_fetchJSON(t *testing.T) {
+	status := http.StatusOK
+	srv := newUpstream(t, &status)
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"fetch", "-base-url", srv.URL, "-sensors", "242", "-format", "json"}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	var readings []scrapejestad.Reading
+	if err := json.Unmarshal(stdout.Bytes(), &readings); err != nil {
+		t.Fatalf("error decoding output: %v", err)
+	}
+	if len(readings) != 2 {
+		t.Errorf("expected 2 readings, got %d", len(readings))
+	}
+}
+
+func Test_export(t *testing.T) {
+	status := http.StatusOK
+	srv := newUpstream(t, &status)
+	out := filepath.Join(t.TempDir(), "readings.jsonl")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"export", "-base-url", srv.URL, "-o", out}, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("error reading export: %v", err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Errorf("expected 2 lines, got %d", n)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatalf("error reading export: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("expected export to be readable by all, got %v", info.Mode())
+	}
+
+	code = run(context.Background(), []string{"export", "-base-url", srv.URL}, &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("expected exit code 2 without output file, got %d", code)
+	}
+}
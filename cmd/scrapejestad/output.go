@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/codec"
+	"github.com/fiskeben/scrapejestad/geojson"
+	"github.com/fiskeben/scrapejestad/influx"
+	"github.com/fiskeben/scrapejestad/readingcsv"
+)
+
+// writer writes readings in one output format. It may be called
+// several times, like once per reading by the watch command.
+type writer func(w io.Writer, readings []scrapejestad.Reading) error
+
+// writers return a new writer for each output format by name.
+var writers = map[string]func() writer{
+	"archive": newArchiveWriter,
+	"csv":     newCSVWriter,
+	"geojson": stateless(writeGeoJSON),
+	"influx":  stateless(writeInflux),
+	"json":    stateless(writeJSON),
+	"jsonl":   stateless(writeJSONLines),
+	"text":    stateless(writeText),
+}
+
+// extensions maps file extensions to output formats.
+var extensions = map[string]string{
+	".csv":     "csv",
+	".geojson": "geojson",
+	".json":    "json",
+	".jsonl":   "jsonl",
+	".lp":      "influx",
+	".mjsc":    "archive",
+	".txt":     "text",
+}
+
+func stateless(w writer) func() writer {
+	return func() writer {
+		return w
+	}
+}
+
+func formatNames() string {
+	names := make([]string, 0, len(writers))
+	for n := range writers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func lookupWriter(format, path string) (writer, error) {
+	if format == "" {
+		format = extensions[strings.ToLower(filepath.Ext(path))]
+	}
+	w, ok := writers[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown format '%s', use one of %s", format, formatNames())
+	}
+	return w(), nil
+}
+
+// newCSVWriter returns a writer that writes the header only once.
+func newCSVWriter() writer {
+	var cw *readingcsv.Writer
+	return func(w io.Writer, readings []scrapejestad.Reading) error {
+		if cw == nil {
+			cw = readingcsv.NewWriter(w)
+		}
+		if err := cw.Write(readings); err != nil {
+			return err
+		}
+		return cw.Flush()
+	}
+}
+
+// newArchiveWriter returns a writer that writes the header only once
+// and a block per call.
+func newArchiveWriter() writer {
+	var e *codec.Encoder
+	return func(w io.Writer, readings []scrapejestad.Reading) error {
+		if e == nil {
+			e = codec.NewEncoder(w)
+		}
+		for _, r := range readings {
+			if err := e.Encode(r); err != nil {
+				return err
+			}
+		}
+		return e.Flush()
+	}
+}
+
+func writeJSON(w io.Writer, readings []scrapejestad.Reading) error {
+	if readings == nil {
+		readings = []scrapejestad.Reading{}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(readings)
+}
+
+func writeGeoJSON(w io.Writer, readings []scrapejestad.Reading) error {
+	return geojson.Write(w, readings, geojson.WithLinks())
+}
+
+func writeInflux(w io.Writer, readings []scrapejestad.Reading) error {
+	e, err := influx.NewEncoder(w, influx.WithGateways("meetjestad_gateway"))
+	if err != nil {
+		return err
+	}
+	return e.Encode(readings)
+}
+
+func writeJSONLines(w io.Writer, readings []scrapejestad.Reading) error {
+	e := json.NewEncoder(w)
+	for _, r := range readings {
+		if err := e.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeText(w io.Writer, readings []scrapejestad.Reading) error {
+	for _, r := range readings {
+		if _, err := fmt.Fprintf(w, "%s\n\n", r.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
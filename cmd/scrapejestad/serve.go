@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/promexport"
+	"github.com/fiskeben/scrapejestad/server"
+)
+
+func runServe(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cf clientFlags
+	cf.register(fs)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	interval := fs.Duration("interval", time.Minute, "how often to poll for the /stream and /metrics endpoints")
+	staleness := fs.Duration("staleness", time.Hour, "how long sensors stay in /metrics after their last reading")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(stderr, "invalid interval '%v': must be positive\n", *interval)
+		return exitUsage
+	}
+	if cf.cacheDir == "" {
+		cf.cacheDir = filepath.Join(os.TempDir(), "scrapejestad-cache")
+	}
+
+	c, err := cf.client()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	q, err := cf.query()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+
+	api := server.New(c)
+	stream := server.NewStream(server.StreamDatasets(c))
+	api.Handle("/stream", stream)
+	watcher := scrapejestad.NewWatcher(c, q,
+		scrapejestad.WatchInterval(*interval),
+		scrapejestad.WatchSkipExisting(),
+		scrapejestad.WatchErrorHandler(func(err error) {
+			fmt.Fprintf(stderr, "%v\n", err)
+		}))
+	go stream.Run(ctx, watcher)
+
+	metrics := promexport.New(c,
+		promexport.WithQuery(q),
+		promexport.WithInterval(*interval),
+		promexport.WithStaleness(*staleness))
+	api.Handle("/metrics", metrics)
+	go metrics.Run(ctx)
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           api,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	fmt.Fprintf(stdout, "listening on %s\n", *addr)
+
+	select {
+	case err := <-errs:
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+	return exitOK
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+func runWatch(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var cf clientFlags
+	cf.register(fs)
+	format := fs.String("format", "jsonl", "output format: "+formatNames())
+	interval := fs.Duration("interval", time.Minute, "how often to poll")
+	skip := fs.Bool("skip-existing", false, "only print readings arriving after the first poll")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(stderr, "invalid interval '%v': must be positive\n", *interval)
+		return exitUsage
+	}
+
+	w, err := lookupWriter(*format, "")
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+	q, err := cf.query()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+	cal, err := cf.calibration()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitUsage
+	}
+	c, err := cf.client()
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return exitError
+	}
+
+	opts := []scrapejestad.WatcherOption{
+		scrapejestad.WatchInterval(*interval),
+		scrapejestad.WatchErrorHandler(func(err error) {
+			fmt.Fprintf(stderr, "%v\n", err)
+		}),
+	}
+	if *skip {
+		opts = append(opts, scrapejestad.WatchSkipExisting())
+	}
+	watcher := scrapejestad.NewWatcher(c, q, opts...)
+
+	var writeErr error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watcher.Run(ctx, func(r scrapejestad.Reading) {
+		if err := w(stdout, []scrapejestad.Reading{cal.Correct(r)}); err != nil {
+			writeErr = err
+			cancel()
+		}
+	})
+	if writeErr != nil {
+		fmt.Fprintf(stderr, "%v\n", writeErr)
+		return exitError
+	}
+	return exitOK
+}
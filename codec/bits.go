@@ -0,0 +1,229 @@
+package codec
+
+import "math/bits"
+
+// The range coder is the one of LZMA: binary decisions are coded with
+// 11 bit probabilities that adapt to the decisions seen.
+const (
+	probBits  = 11
+	probOne   = 1 << probBits
+	probShift = 5
+	rangeTop  = 1 << 24
+)
+
+// prob is the probability of a zero bit, in 1/probOne.
+type prob uint16
+
+func newProbs(n int) []prob {
+	p := make([]prob, n)
+	for i := range p {
+		p[i] = probOne / 2
+	}
+	return p
+}
+
+// coder is implemented by the encoder and the decoder of the range
+// coder, so that a block is encoded and decoded by the same code.
+type coder interface {
+	// bit codes b with the probability p, which is then adapted, and
+	// returns the bit. The decoder ignores b and returns the bit read.
+	bit(p *prob, b uint) uint
+	// direct codes the n lowest bits of v with equal probabilities.
+	direct(v uint64, n int) uint64
+}
+
+type rangeEncoder struct {
+	buf       []byte
+	low       uint64
+	rng       uint32
+	cache     byte
+	cacheSize int
+}
+
+func newRangeEncoder(buf []byte) *rangeEncoder {
+	return &rangeEncoder{buf: buf, rng: 0xffffffff, cacheSize: 1}
+}
+
+func (e *rangeEncoder) bit(p *prob, b uint) uint {
+	bound := (e.rng >> probBits) * uint32(*p)
+	if b == 0 {
+		e.rng = bound
+		*p += (probOne - *p) >> probShift
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+		*p -= *p >> probShift
+	}
+	for e.rng < rangeTop {
+		e.rng <<= 8
+		e.shiftLow()
+	}
+	return b
+}
+
+func (e *rangeEncoder) direct(v uint64, n int) uint64 {
+	for i := n - 1; i >= 0; i-- {
+		e.rng >>= 1
+		if v>>uint(i)&1 == 1 {
+			e.low += uint64(e.rng)
+		}
+		for e.rng < rangeTop {
+			e.rng <<= 8
+			e.shiftLow()
+		}
+	}
+	return v
+}
+
+// shiftLow writes the top byte of low, holding back bytes of 0xff
+// until it is known whether a carry changes them.
+func (e *rangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xff000000 || e.low>>32 != 0 {
+		carry := byte(e.low >> 32)
+		e.buf = append(e.buf, e.cache+carry)
+		for ; e.cacheSize > 1; e.cacheSize-- {
+			e.buf = append(e.buf, 0xff+carry)
+		}
+		e.cacheSize = 0
+		e.cache = byte(e.low >> 24)
+	}
+	e.cacheSize++
+	e.low = uint64(uint32(e.low) << 8)
+}
+
+// finish flushes the coder and returns the coded bytes.
+func (e *rangeEncoder) finish() []byte {
+	for i := 0; i < 5; i++ {
+		e.shiftLow()
+	}
+	return e.buf
+}
+
+type rangeDecoder struct {
+	buf  []byte
+	pos  int
+	rng  uint32
+	code uint32
+}
+
+func newRangeDecoder(buf []byte) *rangeDecoder {
+	d := &rangeDecoder{buf: buf, rng: 0xffffffff}
+	for i := 0; i < 5; i++ {
+		d.code = d.code<<8 | uint32(d.next())
+	}
+	return d
+}
+
+// next returns the next byte, or zero past the end, which is
+// reported by overrun.
+func (d *rangeDecoder) next() byte {
+	d.pos++
+	if d.pos > len(d.buf) {
+		return 0
+	}
+	return d.buf[d.pos-1]
+}
+
+// overrun reports whether more bytes were read than there are.
+func (d *rangeDecoder) overrun() bool {
+	return d.pos > len(d.buf)
+}
+
+func (d *rangeDecoder) bit(p *prob, _ uint) uint {
+	bound := (d.rng >> probBits) * uint32(*p)
+	var b uint
+	if d.code < bound {
+		d.rng = bound
+		*p += (probOne - *p) >> probShift
+	} else {
+		d.code -= bound
+		d.rng -= bound
+		*p -= *p >> probShift
+		b = 1
+	}
+	for d.rng < rangeTop {
+		d.rng <<= 8
+		d.code = d.code<<8 | uint32(d.next())
+	}
+	return b
+}
+
+func (d *rangeDecoder) direct(_ uint64, n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		d.rng >>= 1
+		v <<= 1
+		if d.code >= d.rng {
+			d.code -= d.rng
+			v |= 1
+		}
+		for d.rng < rangeTop {
+			d.rng <<= 8
+			d.code = d.code<<8 | uint32(d.next())
+		}
+	}
+	return v
+}
+
+// codeTree codes the n lowest bits of v, most significant first, with
+// a probability for every prefix. probs has 1<<n entries.
+func codeTree(c coder, probs []prob, v uint64, n int) uint64 {
+	m := uint64(1)
+	for i := n - 1; i >= 0; i-- {
+		m = m<<1 | uint64(c.bit(&probs[m], uint(v>>uint(i)&1)))
+	}
+	return m - 1<<uint(n)
+}
+
+// treeBits is the number of bits below the leading one that are coded
+// with a probability for every prefix. The ones below that have a
+// probability per position.
+const treeBits = 3
+
+// intModel codes unsigned integers: whether they are zero, the number
+// of bits and then the bits below the leading one. It adapts to the
+// distribution of the integers coded with it.
+type intModel struct {
+	zero    prob
+	lengths []prob
+	high    [65][]prob
+	low     [65][]prob
+}
+
+func newIntModel() *intModel {
+	return &intModel{zero: probOne / 2, lengths: newProbs(64)}
+}
+
+func (m *intModel) code(c coder, v uint64) uint64 {
+	var nonzero uint
+	if v != 0 {
+		nonzero = 1
+	}
+	if c.bit(&m.zero, nonzero) == 0 {
+		return 0
+	}
+	n := int(codeTree(c, m.lengths, uint64(bits.Len64(v)-1), 6)) + 1
+	if n == 1 {
+		return 1
+	}
+	high := n - 1
+	if high > treeBits {
+		high = treeBits
+	}
+	if m.high[n] == nil {
+		// Most lengths are never seen.
+		m.high[n], m.low[n] = newProbs(1<<treeBits), newProbs(n-1-high)
+	}
+	res := 1<<uint(n-1) | codeTree(c, m.high[n], v>>uint(n-1-high), high)<<uint(n-1-high)
+	for i := n - 2 - high; i >= 0; i-- {
+		res |= uint64(c.bit(&m.low[n][i], uint(v>>uint(i)&1))) << uint(i)
+	}
+	return res
+}
+
+// codeInt codes a signed integer, mapping small negative and positive
+// values to small unsigned ones.
+func (m *intModel) codeInt(c coder, v int64) int64 {
+	z := m.code(c, uint64(v<<1^v>>63))
+	return int64(z>>1) ^ -int64(z&1)
+}
@@ -0,0 +1,562 @@
+// Package codec encodes readings in a compact binary format for archives.
+//
+// A stream starts with a header holding the format version, followed by
+// blocks of readings. Each block stores its readings column by column:
+// timestamps and frame counters as the change of their difference,
+// measurements XOR'ed with the previous value of the same sensor, as
+// in Facebook's Gorilla, RSSI and LSNR as the difference from their
+// mean, and names as indices into a dictionary. The columns are coded
+// with the adaptive range coder of LZMA, which spends less than a bit
+// on values that are mostly the same. Blocks can be decoded on their
+// own and carry a checksum.
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+const (
+	magic = "MJSC"
+	// Version is the version of the format written by Encoder.
+	// Version 2 added the Extra values of readings.
+	Version = 2
+	// DefaultBlockSize is the number of readings per block.
+	DefaultBlockSize = 4096
+	// maxBlockLength limits the memory used to decode a block.
+	maxBlockLength = 64 << 20
+)
+
+// ErrCorrupt is returned when a stream can't be decoded.
+var ErrCorrupt = errors.New("corrupt stream")
+
+// Encoder writes readings to a stream.
+type Encoder struct {
+	w         io.Writer
+	blockSize int
+	header    bool
+	rows      []scrapejestad.Reading
+}
+
+// Option configures an Encoder.
+type Option func(*Encoder)
+
+// WithBlockSize sets the number of readings buffered before a block is
+// written. Larger blocks compress better.
+func WithBlockSize(n int) Option {
+	return func(e *Encoder) {
+		if n > 0 {
+			e.blockSize = n
+		}
+	}
+}
+
+// NewEncoder returns an Encoder writing to w.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	e := &Encoder{w: w, blockSize: DefaultBlockSize}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Encode adds a reading to the stream. Readings are buffered until
+// a block is full or Flush is called.
+func (e *Encoder) Encode(r scrapejestad.Reading) error {
+	// The gateways are kept until the block is written, so the caller
+	// may change them in the meantime.
+	r.Gateways = append([]scrapejestad.Gateway(nil), r.Gateways...)
+	e.rows = append(e.rows, r)
+	if len(e.rows) >= e.blockSize {
+		return e.Flush()
+	}
+	return nil
+}
+
+// Flush writes the buffered readings as a block.
+func (e *Encoder) Flush() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if len(e.rows) == 0 {
+		return nil
+	}
+	payload := encodeBlock(e.rows)
+	b := make([]byte, 0, len(payload)+2*binary.MaxVarintLen64+4)
+	b = appendUvarint(b, uint64(len(e.rows)))
+	b = appendUvarint(b, uint64(len(payload)))
+	b = append(b, payload...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], crc32.ChecksumIEEE(payload))
+	e.rows = e.rows[:0]
+	_, err := e.w.Write(b)
+	return err
+}
+
+// Close flushes the buffered readings. It doesn't close the underlying writer.
+func (e *Encoder) Close() error {
+	return e.Flush()
+}
+
+func (e *Encoder) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	_, err := e.w.Write(append([]byte(magic), Version))
+	return err
+}
+
+// Decoder reads readings from a stream.
+type Decoder struct {
+	r       *bufio.Reader
+	version byte
+	rows    []scrapejestad.Reading
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next reading in the stream, or io.EOF at its
+// end. Dates are returned in UTC.
+func (d *Decoder) Decode() (scrapejestad.Reading, error) {
+	for len(d.rows) == 0 {
+		if err := d.readBlock(); err != nil {
+			return scrapejestad.Reading{}, err
+		}
+	}
+	r := d.rows[0]
+	d.rows = d.rows[1:]
+	return r, nil
+}
+
+func (d *Decoder) readBlock() error {
+	if d.version == 0 {
+		h := make([]byte, len(magic)+1)
+		if _, err := io.ReadFull(d.r, h); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = ErrCorrupt
+			}
+			return err
+		}
+		if string(h[:len(magic)]) != magic {
+			return ErrCorrupt
+		}
+		d.version = h[len(magic)]
+		if d.version < 1 || d.version > Version {
+			return fmt.Errorf("unsupported version %d", d.version)
+		}
+	}
+
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCorrupt
+		}
+		return err
+	}
+	length, err := binary.ReadUvarint(d.r)
+	if err != nil || length > maxBlockLength || n > 8*length {
+		return ErrCorrupt
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(d.r, int64(length)+4))
+	if err != nil {
+		return err
+	}
+	if uint64(len(b)) != length+4 {
+		return ErrCorrupt
+	}
+	payload := b[:length]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(b[length:]) {
+		return ErrCorrupt
+	}
+	d.rows, err = decodeBlock(d.version, int(n), payload)
+	return err
+}
+
+// maxSteps is the largest number of lost messages in between readings
+// that timestamps are predicted over.
+const maxSteps = 16
+
+// series identifies the values of a sensor, or of a sensor as heard
+// by a gateway, within a column.
+type series struct {
+	sensor  int
+	gateway int
+}
+
+// block holds the columns of a block, which keep the state of their
+// series and the models of the range coder.
+type block struct {
+	c     coder
+	words []string
+	index map[string]int
+	width int
+	// limit is the number of gateways and Extra values left to decode,
+	// so that garbage can't make the decoder allocate without bounds.
+	limit int
+
+	sensors       sensorColumn
+	fcnt, seconds *dodColumn
+	nsec, time    prob
+	readings      []*xorColumn
+	firmware      *wordColumn
+	names         *gatewayColumn
+	gateways      []*xorColumn
+	rssi, lsnr    *scaledColumn
+	frequency     freqColumn
+	sf, cr        *wordColumn
+	extraCount    *countColumn
+	extraNames    *wordColumn
+	extras        map[int]*xorColumn
+}
+
+var readingFloats = []func(r *scrapejestad.Reading) *float32{
+	func(r *scrapejestad.Reading) *float32 { return &r.Temp },
+	func(r *scrapejestad.Reading) *float32 { return &r.Humidity },
+	func(r *scrapejestad.Reading) *float32 { return &r.Light },
+	func(r *scrapejestad.Reading) *float32 { return &r.PM25 },
+	func(r *scrapejestad.Reading) *float32 { return &r.PM10 },
+	func(r *scrapejestad.Reading) *float32 { return &r.Voltage },
+	func(r *scrapejestad.Reading) *float32 { return &r.Position.Lat },
+	func(r *scrapejestad.Reading) *float32 { return &r.Position.Lng },
+}
+
+var gatewayFloats = []func(g *scrapejestad.Gateway) *float32{
+	func(g *scrapejestad.Gateway) *float32 { return &g.Position.Lat },
+	func(g *scrapejestad.Gateway) *float32 { return &g.Position.Lng },
+	func(g *scrapejestad.Gateway) *float32 { return &g.Distance },
+}
+
+func newBlock(c coder, words []string, limit int) *block {
+	b := &block{
+		c:          c,
+		words:      words,
+		index:      make(map[string]int, len(words)),
+		width:      bits.Len(uint(len(words) - 1)),
+		limit:      limit,
+		sensors:    sensorColumn{model: newIntModel()},
+		fcnt:       newDodColumn(),
+		seconds:    newDodColumn(),
+		nsec:       probOne / 2,
+		time:       probOne / 2,
+		firmware:   newWordColumn(),
+		names:      newGatewayColumn(),
+		rssi:       newScaledColumn(1),
+		lsnr:       newScaledColumn(4),
+		frequency:  freqColumn{same: probOne / 2, model: newIntModel()},
+		sf:         newWordColumn(),
+		cr:         newWordColumn(),
+		extraCount: newCountColumn(),
+		extraNames: newWordColumn(),
+		extras:     make(map[int]*xorColumn),
+	}
+	for i, w := range words {
+		b.index[w] = i
+	}
+	for range readingFloats {
+		b.readings = append(b.readings, newXorColumn())
+	}
+	for range gatewayFloats {
+		b.gateways = append(b.gateways, newXorColumn())
+	}
+	return b
+}
+
+// word codes the dictionary index of a word.
+func (b *block) word(i int) (int, error) {
+	i = int(b.c.direct(uint64(i), b.width))
+	if i >= len(b.words) {
+		return 0, ErrCorrupt
+	}
+	return i, nil
+}
+
+// count codes a count of gateways or Extra values.
+func (b *block) count(m *intModel, n int) (int, error) {
+	v := m.code(b.c, uint64(n))
+	if v > uint64(b.limit) {
+		return 0, ErrCorrupt
+	}
+	b.limit -= int(v)
+	return int(v), nil
+}
+
+// flag codes a boolean with the probability p.
+func (b *block) flag(p *prob, set bool) bool {
+	var bit uint
+	if set {
+		bit = 1
+	}
+	return b.c.bit(p, bit) == 1
+}
+
+// dictionary returns the words of the readings, in order of appearance.
+func dictionary(rows []scrapejestad.Reading) []string {
+	var words []string
+	seen := make(map[string]bool)
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			words = append(words, s)
+		}
+	}
+	for _, r := range rows {
+		add(r.SensorID)
+		add(r.Firmware)
+		for _, g := range r.Gateways {
+			add(g.Name)
+			add(g.RadioSettings.Sf)
+			add(g.RadioSettings.Cr)
+		}
+		for _, k := range extraNames(r) {
+			add(k)
+		}
+	}
+	return words
+}
+
+func encodeBlock(rows []scrapejestad.Reading) []byte {
+	words := dictionary(rows)
+	var dict []byte
+	dict = appendUvarint(dict, uint64(len(words)))
+	for _, s := range words {
+		dict = appendUvarint(dict, uint64(len(s)))
+		dict = append(dict, s...)
+	}
+	e := newRangeEncoder(dict)
+	// Encoding codes the values the readings already have, so it can't fail.
+	newBlock(e, words, math.MaxInt32).code(Version, rows)
+	return e.finish()
+}
+
+func decodeBlock(version byte, n int, payload []byte) ([]scrapejestad.Reading, error) {
+	count, err := readUvarint(&payload)
+	if err != nil || count > uint64(len(payload)) {
+		return nil, ErrCorrupt
+	}
+	words := make([]string, count)
+	for i := range words {
+		l, err := readUvarint(&payload)
+		if err != nil || l > uint64(len(payload)) {
+			return nil, ErrCorrupt
+		}
+		words[i] = string(payload[:l])
+		payload = payload[l:]
+	}
+	if len(words) == 0 && n > 0 {
+		return nil, ErrCorrupt
+	}
+	d := newRangeDecoder(payload)
+	rows := make([]scrapejestad.Reading, n)
+	if err := newBlock(d, words, 64*len(payload)).code(version, rows); err != nil {
+		return nil, err
+	}
+	if d.overrun() {
+		return nil, ErrCorrupt
+	}
+	return rows, nil
+}
+
+// code encodes or decodes the readings of a block, depending on the
+// coder. Decoding fills in zero readings.
+func (b *block) code(version byte, rows []scrapejestad.Reading) error {
+	var err error
+	sensors := make([]int, len(rows))
+	for i := range rows {
+		if sensors[i], err = b.sensors.code(b, b.index[rows[i].SensorID]); err != nil {
+			return err
+		}
+		rows[i].SensorID = b.words[sensors[i]]
+	}
+	// The frame counter comes first, as it tells how many messages
+	// were lost in between timestamps.
+	steps := make([]int64, len(rows))
+	last := make(map[int]int64)
+	for i := range rows {
+		fcnt := b.fcnt.code(b.c, sensors[i], int64(rows[i].Fcnt), 1)
+		rows[i].Fcnt = int(fcnt)
+		steps[i] = 1
+		if prev, ok := last[sensors[i]]; ok && abs(fcnt-prev) > 1 && abs(fcnt-prev) <= maxSteps {
+			steps[i] = abs(fcnt - prev)
+		}
+		last[sensors[i]] = fcnt
+	}
+	for i := range rows {
+		sec := b.seconds.code(b.c, sensors[i], rows[i].Date.Unix(), steps[i])
+		nsec := int64(rows[i].Date.Nanosecond())
+		if b.flag(&b.nsec, nsec != 0) {
+			nsec = int64(b.c.direct(uint64(nsec), 30))
+		}
+		rows[i].Date = time.Unix(sec, nsec).UTC()
+	}
+	for i := range rows {
+		t := rows[i].Date.Unix()
+		if b.flag(&b.time, rows[i].Time != t) {
+			t = int64(b.c.direct(uint64(rows[i].Time), 64))
+		}
+		rows[i].Time = t
+	}
+	for c, field := range readingFloats {
+		for i := range rows {
+			v := field(&rows[i])
+			*v = math.Float32frombits(uint32(b.readings[c].code(b.c, series{sensors[i], 0}, uint64(math.Float32bits(*v)))))
+		}
+	}
+	for i := range rows {
+		w, err := b.firmware.code(b, series{sensors[i], 0}, b.index[rows[i].Firmware])
+		if err != nil {
+			return err
+		}
+		rows[i].Firmware = b.words[w]
+	}
+	// The gateway columns hold the gateways of all readings in a row.
+	var links []series
+	var gateways []*scrapejestad.Gateway
+	for i := range rows {
+		names := make([]int, len(rows[i].Gateways))
+		for j, g := range rows[i].Gateways {
+			names[j] = b.index[g.Name]
+		}
+		if names, err = b.names.code(b, sensors[i], names); err != nil {
+			return err
+		}
+		if len(rows[i].Gateways) != len(names) {
+			rows[i].Gateways = make([]scrapejestad.Gateway, len(names))
+		}
+		for j := range rows[i].Gateways {
+			g := &rows[i].Gateways[j]
+			g.Name = b.words[names[j]]
+			links = append(links, series{sensors[i], names[j]})
+			gateways = append(gateways, g)
+		}
+	}
+	for c, field := range gatewayFloats {
+		for i, g := range gateways {
+			k := links[i]
+			if c < 2 {
+				// The position of a gateway is the same for all sensors.
+				k.sensor = 0
+			}
+			v := field(g)
+			*v = math.Float32frombits(uint32(b.gateways[c].code(b.c, k, uint64(math.Float32bits(*v)))))
+		}
+	}
+	rssi := make([]int64, len(gateways))
+	for i, g := range gateways {
+		g.RSSI, rssi[i] = b.rssi.code(b.c, links[i], g.RSSI, 0)
+	}
+	// The LSNR follows the RSSI when the signal is weak, and is
+	// predicted from it then.
+	for i, g := range gateways {
+		g.LSNR, _ = b.lsnr.code(b.c, links[i], g.LSNR, 4*rssi[i])
+	}
+	// The frequency is normally the same for all gateways hearing a
+	// message, so the others only code whether theirs is.
+	for i := range rows {
+		for j := range rows[i].Gateways {
+			g := &rows[i].Gateways[j]
+			if j > 0 && b.flag(&b.frequency.same, g.RadioSettings.Frequency == rows[i].Gateways[0].RadioSettings.Frequency) {
+				g.RadioSettings.Frequency = rows[i].Gateways[0].RadioSettings.Frequency
+				continue
+			}
+			if g.RadioSettings.Frequency, err = b.frequency.code(b, g.RadioSettings.Frequency); err != nil {
+				return err
+			}
+		}
+	}
+	for _, col := range []struct {
+		words *wordColumn
+		field func(g *scrapejestad.Gateway) *string
+	}{
+		{b.sf, func(g *scrapejestad.Gateway) *string { return &g.RadioSettings.Sf }},
+		{b.cr, func(g *scrapejestad.Gateway) *string { return &g.RadioSettings.Cr }},
+	} {
+		for i, g := range gateways {
+			w, err := col.words.code(b, links[i], b.index[*col.field(g)])
+			if err != nil {
+				return err
+			}
+			*col.field(g) = b.words[w]
+		}
+	}
+	if version < 2 {
+		return nil
+	}
+
+	names := make([][]int, len(rows))
+	for i := range rows {
+		n, err := b.extraCount.code(b, sensors[i], len(rows[i].Extra))
+		if err != nil {
+			return err
+		}
+		names[i] = make([]int, n)
+		for j, name := range extraNames(rows[i]) {
+			names[i][j] = b.index[name]
+		}
+	}
+	for i := range rows {
+		for j := range names[i] {
+			if names[i][j], err = b.extraNames.code(b, series{sensors[i], j}, names[i][j]); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range rows {
+		extra := rows[i].Extra
+		decoding := len(extra) != len(names[i])
+		if decoding {
+			extra = make(map[string]float64, len(names[i]))
+			rows[i].Extra = extra
+		}
+		for _, name := range names[i] {
+			col, ok := b.extras[name]
+			if !ok {
+				col = newXorColumn()
+				b.extras[name] = col
+			}
+			v := math.Float64frombits(col.code(b.c, series{sensors[i], 0}, math.Float64bits(extra[b.words[name]])))
+			if decoding {
+				extra[b.words[name]] = v
+			}
+		}
+	}
+	return nil
+}
+
+// extraNames returns the names of the Extra values of a reading, sorted.
+func extraNames(r scrapejestad.Reading) []string {
+	names := make([]string, 0, len(r.Extra))
+	for k := range r.Extra {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func readUvarint(b *[]byte) (uint64, error) {
+	v, n := binary.Uvarint(*b)
+	if n <= 0 {
+		return 0, ErrCorrupt
+	}
+	*b = (*b)[n:]
+	return v, nil
+}
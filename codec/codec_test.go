@@ -0,0 +1,167 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/meteo"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/fiskeben/scrapejestad/simulator"
+	"github.com/google/go-cmp/cmp"
+)
+
+func encode(t *testing.T, readings []scrapejestad.Reading, opts ...Option) []byte {
+	var b bytes.Buffer
+	e := NewEncoder(&b, opts...)
+	for _, r := range readings {
+		if err := e.Encode(r); err != nil {
+			t.Fatalf("error encoding: %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("error closing encoder: %v", err)
+	}
+	return b.Bytes()
+}
+
+func decode(data []byte) ([]scrapejestad.Reading, error) {
+	d := NewDecoder(bytes.NewReader(data))
+	var res []scrapejestad.Reading
+	for {
+		r, err := d.Decode()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return res, err
+		}
+		res = append(res, r)
+	}
+}
+
+func simulated() []scrapejestad.Reading {
+	sim := simulator.New(20, simulator.WithLoss(0.05), simulator.WithStart(time.Date(2019, 12, 1, 0, 0, 0, 0, time.UTC)))
+	return sim.Advance(7 * 24 * time.Hour)
+}
+
+func Test_roundTrip(t *testing.T) {
+	odd := scrapejestad.Reading{
+		SensorID: "1",
+		Date:     time.Date(2020, 1, 1, 0, 0, 0, 123456789, time.UTC),
+		Time:     42,
+		Light:    1200,
+		PM25:     -3.5,
+		Fcnt:     -1,
+		Extra:    map[string]float64{"a": 1e-300, "b": -2},
+	}
+	// Gateways are mostly listed in the same order, but not always.
+	reversed := scrapejestadtest.Fixture()
+	for _, r := range reversed {
+		for i, j := 0, len(r.Gateways)-1; i < j; i, j = i+1, j-1 {
+			r.Gateways[i], r.Gateways[j] = r.Gateways[j], r.Gateways[i]
+		}
+	}
+	twice := scrapejestadtest.Fixture()[0]
+	twice.Gateways = append(twice.Gateways, twice.Gateways[0])
+	tests := []struct {
+		name     string
+		readings []scrapejestad.Reading
+		opts     []Option
+	}{
+		{"fixture", scrapejestadtest.Fixture(), nil},
+		{"one per block", scrapejestadtest.Fixture(), []Option{WithBlockSize(1)}},
+		{"odd values", append(scrapejestadtest.Fixture(), odd, scrapejestad.Reading{}), []Option{WithBlockSize(3)}},
+		{"gateway order", append(append(scrapejestadtest.Fixture(), reversed...), twice), nil},
+		{"simulated", simulated(), []Option{WithBlockSize(1000)}},
+		{"derived", meteo.Attach(simulated()[:500]), nil},
+		{"empty", nil, nil},
+	}
+	for _, test := range tests {
+		got, err := decode(encode(t, test.readings, test.opts...))
+		if err != nil {
+			t.Fatalf("%s: error decoding: %v", test.name, err)
+		}
+		if diff := cmp.Diff(test.readings, got); diff != "" {
+			t.Errorf("%s: readings differ: %s", test.name, diff)
+		}
+	}
+}
+
+func Test_decodeVersion1(t *testing.T) {
+	// The fixture as written in two blocks by version 1, which had no
+	// Extra values.
+	data, err := ioutil.ReadFile("testdata/v1.mjsc")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	got, err := decode(data)
+	if err != nil {
+		t.Fatalf("error decoding: %v", err)
+	}
+	if diff := cmp.Diff(scrapejestadtest.Fixture(), got); diff != "" {
+		t.Errorf("readings differ: %s", diff)
+	}
+}
+
+func Test_size(t *testing.T) {
+	readings := simulated()
+	var plain, zipped bytes.Buffer
+	z := gzip.NewWriter(&zipped)
+	enc := json.NewEncoder(io.MultiWriter(&plain, z))
+	for _, r := range readings {
+		if err := enc.Encode(r); err != nil {
+			t.Fatalf("error encoding json: %v", err)
+		}
+	}
+	z.Close()
+
+	size := float64(len(encode(t, readings)))
+	t.Logf("%.1f bytes per reading, %.1fx smaller than json and %.1fx smaller than gzipped json",
+		size/float64(len(readings)), float64(plain.Len())/size, float64(zipped.Len())/size)
+	// About half of what is left is the noise in the RSSI and LSNR of
+	// the four gateways hearing each simulated reading.
+	if ratio := float64(zipped.Len()) / size; ratio < 6 {
+		t.Errorf("expected at least 6x smaller than gzipped json, got %.1fx", ratio)
+	}
+	if ratio := float64(plain.Len()) / size; ratio < 90 {
+		t.Errorf("expected at least 90x smaller than json, got %.1fx", ratio)
+	}
+}
+
+func Test_corrupt(t *testing.T) {
+	data := encode(t, scrapejestadtest.Fixture())
+	if _, err := decode(data[:len(data)-1]); err != ErrCorrupt {
+		t.Errorf("expected corrupt error for truncated stream, got %v", err)
+	}
+	flipped := append([]byte{}, data...)
+	flipped[len(flipped)/2] ^= 1
+	if _, err := decode(flipped); err != ErrCorrupt {
+		t.Errorf("expected corrupt error for changed byte, got %v", err)
+	}
+	if _, err := decode([]byte("MJSC\x03")); err == nil || err == ErrCorrupt {
+		t.Errorf("expected version error, got %v", err)
+	}
+	if _, err := decode([]byte("{}")); err != ErrCorrupt {
+		t.Errorf("expected corrupt error for json, got %v", err)
+	}
+}
+
+func Test_decodeGarbage(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	valid := encodeBlock(scrapejestadtest.Fixture())
+	for i := 0; i < 10000; i++ {
+		payload := append([]byte{}, valid...)
+		for j := 0; j < 1+rnd.Intn(4); j++ {
+			payload[rnd.Intn(len(payload))] = byte(rnd.Intn(256))
+		}
+		// Only the absence of panics matters here.
+		decodeBlock(Version, 1+rnd.Intn(8), payload)
+	}
+}
@@ -0,0 +1,450 @@
+package codec
+
+import "math"
+
+// The columns code values with a coder, and return the values coded.
+// When decoding, the values passed in are ignored.
+
+// sensorColumn codes the sensor of a reading as its position among the
+// sensors seen, starting at the one seen longest ago. Sensors take
+// turns, so that is mostly the first position.
+type sensorColumn struct {
+	model  *intModel
+	recent []int
+}
+
+func (col *sensorColumn) code(b *block, sensor int) (int, error) {
+	pos := len(col.recent)
+	for i, s := range col.recent {
+		if s == sensor {
+			pos = i
+			break
+		}
+	}
+	p := col.model.code(b.c, uint64(pos))
+	switch {
+	case p < uint64(len(col.recent)):
+		sensor = col.recent[p]
+		col.recent = append(col.recent[:p], col.recent[p+1:]...)
+	case p == uint64(len(col.recent)):
+		var err error
+		if sensor, err = b.word(sensor); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, ErrCorrupt
+	}
+	col.recent = append(col.recent, sensor)
+	return sensor, nil
+}
+
+// dodColumn codes integers that grow at a steady rate, like
+// timestamps, as the change of their difference. The difference is
+// taken per step, so that timestamps of lost messages don't break the
+// rate, and averaged, so that the jitter of one value doesn't carry
+// over to the next. The first value of a sensor is coded as the
+// difference from the last value of another.
+type dodColumn struct {
+	first, delta, dod *intModel
+	last              int64
+	series            map[int]*dodSeries
+}
+
+// dodSeries holds the mean difference in 1/16, weighing the last 16.
+type dodSeries struct {
+	n    int64
+	prev int64
+	mean int64
+}
+
+func newDodColumn() *dodColumn {
+	return &dodColumn{first: newIntModel(), delta: newIntModel(), dod: newIntModel(), series: make(map[int]*dodSeries)}
+}
+
+// code codes v, which is steps steps of the rate after the previous
+// value of the sensor.
+func (col *dodColumn) code(c coder, sensor int, v, steps int64) int64 {
+	s, ok := col.series[sensor]
+	if !ok {
+		s = &dodSeries{}
+		col.series[sensor] = s
+	}
+	switch s.n {
+	case 0:
+		v = col.last + col.first.codeInt(c, v-col.last)
+	case 1:
+		v = s.prev + col.delta.codeInt(c, v-s.prev)
+	default:
+		pred := s.prev + round16(steps*s.mean)
+		v = pred + col.dod.codeInt(c, v-pred)
+	}
+	if s.n > 0 {
+		s.mean += ((v-s.prev)<<4/steps - s.mean) / weight(s.n)
+	}
+	s.prev, col.last = v, v
+	s.n++
+	return v
+}
+
+// weight returns the weight of the n+1st value in a running mean,
+// which is that of a plain mean up to the last 16 values.
+func weight(n int64) int64 {
+	if n > 16 {
+		return 16
+	}
+	return n
+}
+
+// xorColumn codes the bits of floats XOR'ed with the previous value of
+// the series, as in Facebook's Gorilla. Close values share their sign,
+// exponent and first bits of the mantissa, which leaves few bits. The
+// first value of a series is XOR'ed with the last value of another.
+type xorColumn struct {
+	model  *intModel
+	last   uint64
+	series map[series]uint64
+}
+
+func newXorColumn() *xorColumn {
+	return &xorColumn{model: newIntModel(), series: make(map[series]uint64)}
+}
+
+func (col *xorColumn) code(c coder, s series, v uint64) uint64 {
+	prev, ok := col.series[s]
+	if !ok {
+		prev = col.last
+	}
+	v = prev ^ col.model.code(c, v^prev)
+	col.series[s], col.last = v, v
+	return v
+}
+
+// wordColumn codes words as their dictionary index, or a single
+// decision if the word is the same as the previous one of the series.
+// The first word of a series is compared to the last of another.
+type wordColumn struct {
+	changed prob
+	last    map[series]int
+	any     int
+}
+
+func newWordColumn() *wordColumn {
+	return &wordColumn{changed: probOne / 2, last: make(map[series]int)}
+}
+
+func (col *wordColumn) code(b *block, s series, i int) (int, error) {
+	last, ok := col.last[s]
+	if !ok {
+		last = col.any
+	}
+	if b.flag(&col.changed, i != last) {
+		var err error
+		if i, err = b.word(i); err != nil {
+			return 0, err
+		}
+	} else {
+		i = last
+	}
+	col.last[s], col.any = i, i
+	return i, nil
+}
+
+// countColumn codes counts, like the number of gateways hearing a
+// reading, depending on the previous count of the sensor.
+type countColumn struct {
+	models [8]*intModel
+	last   map[int]int
+}
+
+func newCountColumn() *countColumn {
+	col := &countColumn{last: make(map[int]int)}
+	for i := range col.models {
+		col.models[i] = newIntModel()
+	}
+	return col
+}
+
+func (col *countColumn) code(b *block, sensor, n int) (int, error) {
+	ctx := col.last[sensor]
+	if ctx >= len(col.models) {
+		ctx = len(col.models) - 1
+	}
+	n, err := b.count(col.models[ctx], n)
+	col.last[sensor] = n
+	return n, err
+}
+
+// gatewayColumn codes the gateways hearing a reading. For every
+// gateway that heard the sensor before it codes whether the gateway
+// hears it again, depending on whether it heard the previous reading,
+// followed by the gateways new to the sensor. The gateways are mostly
+// listed in the order they were first heard in, and other orders are
+// coded as positions in that order. Readings with a gateway listed
+// twice have their gateways coded one by one.
+type gatewayColumn struct {
+	plain, ordered      prob
+	count, fresh, order *intModel
+	sensors             map[int]*gatewaySensor
+}
+
+// gatewaySensor holds the gateways that heard a sensor, in the order
+// they were first heard in.
+type gatewaySensor struct {
+	known []int
+	// heard is the probability of a gateway hearing a reading, by
+	// whether it heard the previous one, and last is whether it did.
+	heard [][2]prob
+	last  []bool
+}
+
+func newGatewayColumn() *gatewayColumn {
+	return &gatewayColumn{
+		plain:   probOne / 2,
+		ordered: probOne / 2,
+		count:   newIntModel(),
+		fresh:   newIntModel(),
+		order:   newIntModel(),
+		sensors: make(map[int]*gatewaySensor),
+	}
+}
+
+// code codes the dictionary indices of the gateways hearing a reading.
+func (col *gatewayColumn) code(b *block, sensor int, names []int) ([]int, error) {
+	if b.flag(&col.plain, duplicates(names)) {
+		n, err := b.count(col.count, len(names))
+		if err != nil {
+			return nil, err
+		}
+		res := make([]int, n)
+		for j := range res {
+			if j < len(names) {
+				res[j] = names[j]
+			}
+			if res[j], err = b.word(res[j]); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+
+	s, ok := col.sensors[sensor]
+	if !ok {
+		s = &gatewaySensor{}
+		col.sensors[sensor] = s
+	}
+	var heard, fresh []int
+	for k, g := range s.known {
+		ctx := 0
+		if s.last[k] {
+			ctx = 1
+		}
+		s.last[k] = b.flag(&s.heard[k][ctx], index(names, g) >= 0)
+		if s.last[k] {
+			heard = append(heard, g)
+		}
+	}
+	for _, g := range names {
+		if index(s.known, g) < 0 {
+			fresh = append(fresh, g)
+		}
+	}
+	n, err := b.count(col.fresh, len(fresh))
+	if err != nil {
+		return nil, err
+	}
+	for j := 0; j < n; j++ {
+		g := 0
+		if j < len(fresh) {
+			g = fresh[j]
+		}
+		if g, err = b.word(g); err != nil {
+			return nil, err
+		}
+		if index(s.known, g) >= 0 {
+			return nil, ErrCorrupt
+		}
+		s.known = append(s.known, g)
+		s.heard = append(s.heard, [2]prob{probOne / 2, probOne / 2})
+		s.last = append(s.last, true)
+		heard = append(heard, g)
+	}
+
+	if b.flag(&col.ordered, !equal(names, heard)) {
+		res := make([]int, len(heard))
+		for j := range res {
+			pos := 0
+			if j < len(names) {
+				pos = index(heard, names[j])
+			}
+			p := col.order.code(b.c, uint64(pos))
+			if p >= uint64(len(heard)) {
+				return nil, ErrCorrupt
+			}
+			res[j] = heard[p]
+			heard = append(heard[:p], heard[p+1:]...)
+		}
+		return res, nil
+	}
+	return heard, nil
+}
+
+func index(s []int, v int) int {
+	for i, w := range s {
+		if w == v {
+			return i
+		}
+	}
+	return -1
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func duplicates(s []int) bool {
+	for i := range s {
+		if index(s[:i], s[i]) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scaledColumn codes floats that are multiples of 1/scale, like the
+// RSSI in whole dB, as the difference of those multiples from a
+// prediction. The prediction is the mean of the series, or a reference
+// plus the mean difference from it, whichever has been closer. The
+// LSNR follows the RSSI that way up to where it is clamped, so
+// predictions from the reference stay below the highest value seen,
+// and values at it don't count toward the difference. Values that
+// aren't multiples are coded as they are.
+type scaledColumn struct {
+	scale float64
+	raw   prob
+	// models are by whether the prediction is the highest value seen,
+	// which the LSNR is clamped to.
+	models [2]*intModel
+	last   int64
+	max    int64
+	series map[series]*scaledSeries
+}
+
+// scaledSeries holds the means, which are in 1/16 of a multiple and
+// weigh the last 16 values, and the mean errors of the predictions.
+type scaledSeries struct {
+	n               int64
+	mean, offset    int64
+	meanErr, refErr int64
+}
+
+func newScaledColumn(scale float64) *scaledColumn {
+	return &scaledColumn{scale: scale, raw: probOne / 2, models: [2]*intModel{newIntModel(), newIntModel()}, max: math.MinInt64, series: make(map[series]*scaledSeries)}
+}
+
+// code codes v, which is predicted from ref if that has been closer.
+// It returns the value and its multiple, which is zero for values
+// that aren't multiples.
+func (col *scaledColumn) code(c coder, k series, v float32, ref int64) (float32, int64) {
+	q, ok := quantize(v, col.scale)
+	var bit uint
+	if !ok {
+		bit = 1
+	}
+	if c.bit(&col.raw, bit) == 1 {
+		return math.Float32frombits(uint32(c.direct(uint64(math.Float32bits(v)), 32))), 0
+	}
+	s, ok := col.series[k]
+	if !ok {
+		s = &scaledSeries{}
+		col.series[k] = s
+	}
+	pred, byMean, byRef := col.last, round16(s.mean), ref+round16(s.offset)
+	if byRef > col.max {
+		byRef = col.max
+	}
+	if s.n > 0 {
+		pred = byMean
+		if s.refErr < s.meanErr {
+			pred = byRef
+		}
+	}
+	m := col.models[0]
+	if pred == col.max {
+		m = col.models[1]
+	}
+	q = pred + m.codeInt(c, q-pred)
+	if s.n > 0 {
+		s.meanErr += (abs(q-byMean)<<4 - s.meanErr) >> 3
+		s.refErr += (abs(q-byRef)<<4 - s.refErr) >> 3
+	}
+	s.n++
+	s.mean += (q<<4 - s.mean) / weight(s.n)
+	if q != col.max {
+		s.offset += ((q-ref)<<4 - s.offset) / weight(s.n)
+	}
+	col.last = q
+	if q > col.max {
+		col.max = q
+	}
+	return float32(float64(q) / col.scale), q
+}
+
+func round16(v int64) int64 {
+	return (v + 8) >> 4
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// quantize returns v as a multiple of 1/scale, if it is one.
+func quantize(v float32, scale float64) (int64, bool) {
+	q := math.Round(float64(v) * scale)
+	if math.Abs(q) > 1<<40 || float32(q/scale) != v || (v == 0 && math.Signbit(float64(v))) {
+		return 0, false
+	}
+	return int64(q), true
+}
+
+// freqColumn codes frequencies as their index among the frequencies
+// seen, as sensors hop between a few channels.
+type freqColumn struct {
+	// same is the probability of a gateway hearing a message on the
+	// frequency of the first gateway.
+	same   prob
+	model  *intModel
+	values []uint32
+}
+
+func (col *freqColumn) code(b *block, v float32) (float32, error) {
+	x := math.Float32bits(v)
+	i := len(col.values)
+	for j, w := range col.values {
+		if w == x {
+			i = j
+			break
+		}
+	}
+	u := col.model.code(b.c, uint64(i))
+	switch {
+	case u < uint64(len(col.values)):
+		x = col.values[u]
+	case u == uint64(len(col.values)):
+		x = uint32(b.c.direct(uint64(x), 32))
+		col.values = append(col.values, x)
+	default:
+		return 0, ErrCorrupt
+	}
+	return math.Float32frombits(x), nil
+}
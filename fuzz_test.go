@@ -0,0 +1,50 @@
+//go:build go1.18
+// +build go1.18
+
+package scrapejestad
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fuzzSeeds(f *testing.F) {
+	files, err := filepath.Glob("testdata/*.html")
+	if err != nil {
+		f.Fatalf("error listing testdata: %v", err)
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatalf("failed to open testdata: %v", err)
+		}
+		f.Add(data)
+	}
+	for _, doc := range malformed {
+		f.Add([]byte(doc))
+	}
+}
+
+func FuzzParsePage(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := ParsePage(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for _, r := range p.Readings {
+			if len(r.Gateways) == 0 {
+				t.Errorf("reading of sensor %s has no gateways", r.SensorID)
+			}
+		}
+	})
+}
+
+func FuzzParseTree(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseTree(bytes.NewReader(data))
+	})
+}
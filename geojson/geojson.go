@@ -0,0 +1,198 @@
+// Package geojson writes sensors and gateways as GeoJSON (RFC 7946).
+//
+// Every sensor with a position becomes a Point with its latest reading
+// as properties, and every gateway a Point with the number of messages
+// it received. Optionally, LineStrings link each sensor to the gateways
+// that heard its latest reading.
+package geojson
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// The kinds of features, found in the kind property.
+const (
+	KindSensor  = "sensor"
+	KindGateway = "gateway"
+	KindLink    = "link"
+)
+
+// FeatureCollection is a GeoJSON document.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a geometry with properties.
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   Geometry               `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a Point, with a single position, or a LineString.
+// Positions are longitude first, as RFC 7946 requires.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type options struct {
+	links bool
+}
+
+// Option configures the features that are written.
+type Option func(*options)
+
+// WithLinks adds a LineString from every sensor to each gateway that
+// heard its latest reading.
+func WithLinks() Option {
+	return func(o *options) {
+		o.links = true
+	}
+}
+
+// Build returns the features of the sensors and gateways in readings.
+// Sensors and gateways without a position are left out.
+func Build(readings []scrapejestad.Reading, opts ...Option) FeatureCollection {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	latest := make(map[string]scrapejestad.Reading)
+	type gatewayStats struct {
+		position scrapejestad.Position
+		messages int
+		sensors  map[string]bool
+	}
+	gateways := make(map[string]*gatewayStats)
+	for _, r := range readings {
+		if l, ok := latest[r.SensorID]; !ok || r.Date.After(l.Date) {
+			latest[r.SensorID] = r
+		}
+		for _, g := range r.Gateways {
+			s, ok := gateways[g.Name]
+			if !ok {
+				s = &gatewayStats{position: g.Position, sensors: make(map[string]bool)}
+				gateways[g.Name] = s
+			}
+			s.messages++
+			s.sensors[r.SensorID] = true
+		}
+	}
+
+	fc := FeatureCollection{Type: "FeatureCollection", Features: []Feature{}}
+	ids := make([]string, 0, len(latest))
+	for id, r := range latest {
+		if r.Position != (scrapejestad.Position{}) {
+			ids = append(ids, id)
+		}
+	}
+	scrapejestad.SortSensorIDs(ids)
+	for _, id := range ids {
+		fc.Features = append(fc.Features, sensorFeature(latest[id]))
+	}
+
+	names := make([]string, 0, len(gateways))
+	for n, g := range gateways {
+		if g.position != (scrapejestad.Position{}) {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		g := gateways[n]
+		fc.Features = append(fc.Features, Feature{
+			Type:     "Feature",
+			Geometry: Geometry{Type: "Point", Coordinates: point(g.position)},
+			Properties: map[string]interface{}{
+				"kind":     KindGateway,
+				"name":     n,
+				"messages": g.messages,
+				"sensors":  len(g.sensors),
+			},
+		})
+	}
+
+	if o.links {
+		for _, id := range ids {
+			r := latest[id]
+			for _, g := range r.Gateways {
+				if g.Position == (scrapejestad.Position{}) {
+					continue
+				}
+				fc.Features = append(fc.Features, linkFeature(r, g))
+			}
+		}
+	}
+	return fc
+}
+
+// Write writes the features of readings to w.
+func Write(w io.Writer, readings []scrapejestad.Reading, opts ...Option) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(Build(readings, opts...))
+}
+
+func sensorFeature(r scrapejestad.Reading) Feature {
+	props := map[string]interface{}{
+		"kind":        KindSensor,
+		"sensor_id":   r.SensorID,
+		"time":        r.Date.UTC().Format(time.RFC3339),
+		"temperature": number(r.Temp),
+		"humidity":    number(r.Humidity),
+		"voltage":     number(r.Voltage),
+		"firmware":    r.Firmware,
+		"fcnt":        r.Fcnt,
+		"gateways":    len(r.Gateways),
+	}
+	optional := map[string]float32{"light": r.Light, "pm25": r.PM25, "pm10": r.PM10}
+	for k, v := range optional {
+		if v != 0 {
+			props[k] = number(v)
+		}
+	}
+	return Feature{
+		Type:       "Feature",
+		Geometry:   Geometry{Type: "Point", Coordinates: point(r.Position)},
+		Properties: props,
+	}
+}
+
+func linkFeature(r scrapejestad.Reading, g scrapejestad.Gateway) Feature {
+	props := map[string]interface{}{
+		"kind":      KindLink,
+		"sensor_id": r.SensorID,
+		"gateway":   g.Name,
+		"rssi":      number(g.RSSI),
+		"lsnr":      number(g.LSNR),
+	}
+	if g.Distance != 0 {
+		props["distance"] = number(g.Distance)
+	}
+	return Feature{
+		Type:       "Feature",
+		Geometry:   Geometry{Type: "LineString", Coordinates: [][]float64{point(r.Position), point(g.Position)}},
+		Properties: props,
+	}
+}
+
+// point returns a position as [longitude, latitude].
+func point(p scrapejestad.Position) []float64 {
+	return []float64{number(p.Lng), number(p.Lat)}
+}
+
+// number converts a float32 to the float64 with the same shortest
+// decimal representation, so 60.4309 is not written as 60.43090057373047.
+func number(f float32) float64 {
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(float64(f), 'f', -1, 32), 64)
+	return v
+}
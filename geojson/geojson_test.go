@@ -0,0 +1,62 @@
+package geojson
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_build(t *testing.T) {
+	fc := Build(scrapejestadtest.Fixture(), WithLinks())
+
+	var kinds []string
+	for _, f := range fc.Features {
+		kinds = append(kinds, f.Properties["kind"].(string))
+	}
+	want := []string{KindSensor, KindGateway, KindGateway, KindGateway, KindGateway, KindGateway, KindLink, KindLink}
+	if diff := cmp.Diff(want, kinds); diff != "" {
+		t.Fatalf("kinds differ, sensor 372 has no position: %s", diff)
+	}
+
+	sensor := fc.Features[0]
+	if diff := cmp.Diff([]float64{5.23251, 60.4309}, sensor.Geometry.Coordinates); diff != "" {
+		t.Errorf("expected longitude first: %s", diff)
+	}
+	if sensor.Properties["fcnt"] != 28357 || sensor.Properties["time"] != "2019-12-05T21:19:33Z" {
+		t.Errorf("expected latest reading, got %v", sensor.Properties)
+	}
+	if _, ok := sensor.Properties["light"]; ok {
+		t.Errorf("expected no light without a value")
+	}
+
+	gateway := fc.Features[5]
+	if gateway.Properties["name"] != "mjs-bergen-gateway-5" || gateway.Properties["messages"] != 3 || gateway.Properties["sensors"] != 2 {
+		t.Errorf("unexpected gateway %v", gateway.Properties)
+	}
+
+	link := fc.Features[6]
+	wantLink := [][]float64{{5.23251, 60.4309}, {5.231865, 60.431778}}
+	if diff := cmp.Diff(wantLink, link.Geometry.Coordinates); diff != "" {
+		t.Errorf("link coordinates differ: %s", diff)
+	}
+	if link.Properties["gateway"] != "florvaag-1" || link.Properties["rssi"] != -47.0 || link.Properties["distance"] != 0.104 {
+		t.Errorf("unexpected link %v", link.Properties)
+	}
+}
+
+func Test_write(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, nil); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if diff := cmp.Diff(map[string]interface{}{"type": "FeatureCollection", "features": []interface{}{}}, doc); diff != "" {
+		t.Errorf("unexpected document: %s", diff)
+	}
+}
@@ -0,0 +1,330 @@
+// Package influx encodes readings in the InfluxDB line protocol and
+// writes them to a file or to the /write endpoint of InfluxDB.
+//
+// Each reading becomes a line of the meetjestad measurement, tagged
+// with the sensor ID, firmware and dataset. Gateway receptions can be
+// written as a separate measurement tagged with the gateway name.
+package influx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Precision is the unit of timestamps, named like the precision
+// parameter of the /write endpoint.
+type Precision string
+
+// The supported precisions.
+const (
+	Nanosecond  Precision = "ns"
+	Microsecond Precision = "u"
+	Millisecond Precision = "ms"
+	Second      Precision = "s"
+)
+
+var units = map[Precision]time.Duration{
+	Nanosecond:  time.Nanosecond,
+	Microsecond: time.Microsecond,
+	Millisecond: time.Millisecond,
+	Second:      time.Second,
+}
+
+// DefaultMeasurement is the measurement readings are written to.
+const DefaultMeasurement = "meetjestad"
+
+type config struct {
+	measurement string
+	gateways    string
+	precision   Precision
+	datasets    map[string]string
+	token       string
+	http        *http.Client
+	batchSize   int
+}
+
+// Option configures an Encoder or HTTPWriter.
+type Option func(*config)
+
+// WithMeasurement sets the measurement of readings.
+func WithMeasurement(name string) Option {
+	return func(c *config) {
+		c.measurement = name
+	}
+}
+
+// WithGateways writes a line to the given measurement for every
+// gateway that heard a reading.
+func WithGateways(measurement string) Option {
+	return func(c *config) {
+		c.gateways = measurement
+	}
+}
+
+// WithPrecision sets the unit of timestamps. The default is seconds,
+// which is all meetjestad.net provides.
+func WithPrecision(p Precision) Option {
+	return func(c *config) {
+		c.precision = p
+	}
+}
+
+// WithDatasets tags readings with the name of their dataset.
+// A sensor in more than one dataset is tagged with the first.
+func WithDatasets(datasets []scrapejestad.Dataset) Option {
+	return func(c *config) {
+		c.datasets = make(map[string]string)
+		for _, d := range datasets {
+			for _, id := range d.Sensors {
+				if _, ok := c.datasets[strconv.Itoa(id)]; !ok {
+					c.datasets[strconv.Itoa(id)] = d.Name
+				}
+			}
+		}
+	}
+}
+
+// WithToken sets the token an HTTPWriter authenticates with.
+func WithToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the client an HTTPWriter posts with.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config) {
+		c.http = client
+	}
+}
+
+// WithBatchSize sets how many readings an HTTPWriter posts at once.
+func WithBatchSize(n int) Option {
+	return func(c *config) {
+		c.batchSize = n
+	}
+}
+
+func newConfig(opts []Option) (config, error) {
+	c := config{
+		measurement: DefaultMeasurement,
+		precision:   Second,
+		http:        &http.Client{Timeout: 10 * time.Second},
+		batchSize:   5000,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	if _, ok := units[c.precision]; !ok {
+		return c, fmt.Errorf("unknown precision '%s'", c.precision)
+	}
+	if c.batchSize <= 0 {
+		return c, fmt.Errorf("batch size must be positive, got %d", c.batchSize)
+	}
+	return c, nil
+}
+
+// Encoder writes readings as lines.
+type Encoder struct {
+	w   io.Writer
+	cfg config
+	buf []byte
+}
+
+// NewEncoder returns an encoder writing to w.
+func NewEncoder(w io.Writer, opts ...Option) (*Encoder, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{w: w, cfg: cfg}, nil
+}
+
+// Encode writes readings.
+func (e *Encoder) Encode(readings []scrapejestad.Reading) error {
+	e.buf = e.buf[:0]
+	for _, r := range readings {
+		e.buf = e.cfg.appendReading(e.buf, r)
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// tag is a tag key and value. Tags without a value are left out.
+type tag struct {
+	key, value string
+}
+
+// field is a field key and its already formatted value.
+type field struct {
+	key, value string
+}
+
+func (c config) appendReading(b []byte, r scrapejestad.Reading) []byte {
+	tags := []tag{
+		{"sensor_id", r.SensorID},
+		{"firmware", r.Firmware},
+		{"dataset", c.datasets[r.SensorID]},
+	}
+	fields := []field{
+		{"temperature", float(r.Temp)},
+		{"humidity", float(r.Humidity)},
+		{"voltage", float(r.Voltage)},
+		{"fcnt", strconv.Itoa(r.Fcnt) + "i"},
+	}
+	for _, f := range []struct {
+		key   string
+		value float32
+	}{{"light", r.Light}, {"pm25", r.PM25}, {"pm10", r.PM10}} {
+		if f.value != 0 {
+			fields = append(fields, field{f.key, float(f.value)})
+		}
+	}
+	if r.Position != (scrapejestad.Position{}) {
+		fields = append(fields, field{"latitude", float(r.Position.Lat)}, field{"longitude", float(r.Position.Lng)})
+	}
+	ts := c.timestamp(r.Date)
+	b = appendLine(b, c.measurement, tags, fields, ts)
+
+	if c.gateways == "" {
+		return b
+	}
+	for _, g := range r.Gateways {
+		tags := []tag{
+			{"sensor_id", r.SensorID},
+			{"gateway", g.Name},
+			{"dataset", c.datasets[r.SensorID]},
+			{"sf", g.RadioSettings.Sf},
+		}
+		fields := []field{
+			{"rssi", float(g.RSSI)},
+			{"lsnr", float(g.LSNR)},
+			{"frequency", float(g.RadioSettings.Frequency)},
+		}
+		if g.Distance != 0 {
+			fields = append(fields, field{"distance", float(g.Distance)})
+		}
+		b = appendLine(b, c.gateways, tags, fields, ts)
+	}
+	return b
+}
+
+func (c config) timestamp(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(units[c.precision]), 10)
+}
+
+// appendLine appends a line with the tags sorted by key, as InfluxDB recommends.
+func appendLine(b []byte, measurement string, tags []tag, fields []field, ts string) []byte {
+	sort.Slice(tags, func(i, j int) bool { return tags[i].key < tags[j].key })
+
+	b = append(b, measurementEscaper.Replace(measurement)...)
+	for _, t := range tags {
+		if t.value == "" {
+			continue
+		}
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(t.key)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(t.value)...)
+	}
+	for i, f := range fields {
+		if i == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, keyEscaper.Replace(f.key)...)
+		b = append(b, '=')
+		b = append(b, f.value...)
+	}
+	b = append(b, ' ')
+	b = append(b, ts...)
+	return append(b, '\n')
+}
+
+var (
+	// measurementEscaper escapes measurement names.
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	// keyEscaper escapes tag keys, tag values and field keys.
+	keyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
+
+func float(v float32) string {
+	return strconv.FormatFloat(float64(v), 'f', -1, 32)
+}
+
+// HTTPWriter posts readings to the /write endpoint of InfluxDB.
+type HTTPWriter struct {
+	url string
+	cfg config
+}
+
+// NewHTTPWriter returns a writer posting to the write endpoint at
+// rawurl, like "http://localhost:8086/write?db=meetjestad". The
+// precision parameter is added to it.
+func NewHTTPWriter(rawurl string, opts ...Option) (*HTTPWriter, error) {
+	cfg, err := newConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing '%s': %v", rawurl, err)
+	}
+	q := u.Query()
+	q.Set("precision", string(cfg.precision))
+	u.RawQuery = q.Encode()
+	return &HTTPWriter{url: u.String(), cfg: cfg}, nil
+}
+
+// Write posts readings in batches.
+func (w *HTTPWriter) Write(ctx context.Context, readings []scrapejestad.Reading) error {
+	var b []byte
+	for start := 0; start < len(readings); start += w.cfg.batchSize {
+		end := start + w.cfg.batchSize
+		if end > len(readings) {
+			end = len(readings)
+		}
+		b = b[:0]
+		for _, r := range readings[start:end] {
+			b = w.cfg.appendReading(b, r)
+		}
+		if err := w.post(ctx, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *HTTPWriter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.cfg.token != "" {
+		req.Header.Set("Authorization", "Token "+w.cfg.token)
+	}
+	res, err := w.cfg.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing to '%s': %v", w.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("error writing to '%s': %s: %s", w.url, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func encode(t *testing.T, readings []scrapejestad.Reading, opts ...Option) string {
+	var b bytes.Buffer
+	e, err := NewEncoder(&b, opts...)
+	if err != nil {
+		t.Fatalf("error creating encoder: %v", err)
+	}
+	if err := e.Encode(readings); err != nil {
+		t.Fatalf("error encoding: %v", err)
+	}
+	return b.String()
+}
+
+func Test_encode(t *testing.T) {
+	readings := scrapejestadtest.Fixture()[:1]
+	got := encode(t, readings,
+		WithGateways("meetjestad_gateway"),
+		WithDatasets([]scrapejestad.Dataset{{Name: "Bergen, Norway", Sensors: []int{242}}}),
+		WithPrecision(Millisecond),
+	)
+	want := `meetjestad,dataset=Bergen\,\ Norway,firmware=v2,sensor_id=242 temperature=6.875,humidity=107.25,voltage=3.37,fcnt=28357i,latitude=60.4309,longitude=5.23251 1575580773000
+meetjestad_gateway,dataset=Bergen\,\ Norway,gateway=florvaag-1,sensor_id=242,sf=SF9BW125 rssi=-47,lsnr=9.5,frequency=868.5,distance=0.104 1575580773000
+meetjestad_gateway,dataset=Bergen\,\ Norway,gateway=eui-00f142122877fa05,sensor_id=242,sf=SF9BW125 rssi=-117,lsnr=-1,frequency=868.5,distance=5.587 1575580773000
+`
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("lines differ: %s", diff)
+	}
+}
+
+func Test_escaping(t *testing.T) {
+	r := scrapejestad.Reading{SensorID: "a b", Firmware: "x=1,y", Date: scrapejestadtest.Fixture()[0].Date}
+	got := encode(t, []scrapejestad.Reading{r}, WithMeasurement("my measurement,1"))
+	want := `my\ measurement\,1,firmware=x\=1\,y,sensor_id=a\ b temperature=0,humidity=0,voltage=0,fcnt=0i 1575580773` + "\n"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("lines differ: %s", diff)
+	}
+}
+
+func Test_options(t *testing.T) {
+	if _, err := NewEncoder(ioutil.Discard, WithPrecision("h")); err == nil {
+		t.Errorf("expected error for unknown precision")
+	}
+	if _, err := NewHTTPWriter("http://localhost/write", WithBatchSize(0)); err == nil {
+		t.Errorf("expected error for batch size 0")
+	}
+}
+
+func Test_httpWriter(t *testing.T) {
+	var bodies []string
+	var query, auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		query = r.URL.RawQuery
+		auth = r.Header.Get("Authorization")
+		if strings.Contains(string(body), "sensor_id=372") && strings.Contains(string(body), "fcnt=0i") {
+			http.Error(w, "partial write", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	w, err := NewHTTPWriter(srv.URL+"/write?db=meetjestad", WithBatchSize(2), WithToken("secret"))
+	if err != nil {
+		t.Fatalf("error creating writer: %v", err)
+	}
+	readings := scrapejestadtest.Fixture()
+	if err := w.Write(context.Background(), readings[:3]); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if len(bodies) != 2 || strings.Count(bodies[0], "\n") != 2 || strings.Count(bodies[1], "\n") != 1 {
+		t.Errorf("expected batches of 2 and 1 readings, got %q", bodies)
+	}
+	if query != "db=meetjestad&precision=s" {
+		t.Errorf("unexpected query '%s'", query)
+	}
+	if auth != "Token secret" {
+		t.Errorf("unexpected authorization '%s'", auth)
+	}
+
+	err = w.Write(context.Background(), readings[3:])
+	if err == nil || !strings.Contains(err.Error(), "partial write") {
+		t.Errorf("expected error with message from InfluxDB, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+// Package meteo computes meteorological quantities from the temperature
+// and relative humidity of readings.
+//
+// Temperatures are in °C and humidities in percent. Sensors report a
+// relative humidity of a few percent over 100 when condensation forms
+// on them; up to MaxHumidity, such values are taken to be 100. The
+// functions return false for other values outside physical ranges.
+package meteo
+
+import (
+	"math"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// The ranges of accepted inputs.
+const (
+	MinTemperature = -60
+	MaxTemperature = 60
+	MaxHumidity    = 110
+)
+
+// The names of the derived quantities, as used in Reading.Extra.
+const (
+	NameDewPoint         = "dew_point"
+	NameAbsoluteHumidity = "absolute_humidity"
+	NameHeatIndex        = "heat_index"
+	NameHumidex          = "humidex"
+	NameWetBulb          = "wet_bulb"
+)
+
+// Names lists the derived quantities.
+var Names = []string{NameDewPoint, NameAbsoluteHumidity, NameHeatIndex, NameHumidex, NameWetBulb}
+
+var funcs = map[string]func(temp, humidity float64) (float64, bool){
+	NameDewPoint:         DewPoint,
+	NameAbsoluteHumidity: AbsoluteHumidity,
+	NameHeatIndex:        HeatIndex,
+	NameHumidex:          Humidex,
+	NameWetBulb:          WetBulb,
+}
+
+// inputs checks the temperature and humidity and clamps the humidity to 100%.
+func inputs(temp, humidity float64) (float64, float64, bool) {
+	if math.IsNaN(temp) || temp < MinTemperature || temp > MaxTemperature {
+		return 0, 0, false
+	}
+	if math.IsNaN(humidity) || humidity <= 0 || humidity > MaxHumidity {
+		return 0, 0, false
+	}
+	return temp, math.Min(humidity, 100), true
+}
+
+// The Magnus formula with the coefficients of Alduchov and Eskridge.
+const (
+	magnusA = 6.1094
+	magnusB = 17.625
+	magnusC = 243.04
+)
+
+// saturation returns the saturation vapour pressure over water in hPa.
+func saturation(temp float64) float64 {
+	return magnusA * math.Exp(magnusB*temp/(magnusC+temp))
+}
+
+// DewPoint returns the temperature in °C at which the air would be saturated.
+func DewPoint(temp, humidity float64) (float64, bool) {
+	temp, humidity, ok := inputs(temp, humidity)
+	if !ok {
+		return 0, false
+	}
+	g := math.Log(humidity/100) + magnusB*temp/(magnusC+temp)
+	return magnusC * g / (magnusB - g), true
+}
+
+// RelativeHumidity returns the relative humidity in percent of air at
+// temp with the given dew point. Dew points above temp give 100.
+func RelativeHumidity(temp, dewPoint float64) (float64, bool) {
+	if math.IsNaN(temp) || temp < MinTemperature || temp > MaxTemperature {
+		return 0, false
+	}
+	if math.IsNaN(dewPoint) || dewPoint <= -magnusC {
+		return 0, false
+	}
+	return math.Min(100*saturation(dewPoint)/saturation(temp), 100), true
+}
+
+// AbsoluteHumidity returns the mass of water vapour in the air in g/m³.
+func AbsoluteHumidity(temp, humidity float64) (float64, bool) {
+	temp, humidity, ok := inputs(temp, humidity)
+	if !ok {
+		return 0, false
+	}
+	// The ideal gas law, with the gas constant of water vapour of 461.5 J/(kg·K).
+	e := humidity / 100 * saturation(temp) * 100
+	return e / (461.5 * (temp + 273.15)) * 1000, true
+}
+
+// HeatIndex returns the temperature in °C it feels like in the shade,
+// as computed by the US National Weather Service. The heat index isn't
+// defined below 80 °F, where it returns the temperature.
+func HeatIndex(temp, humidity float64) (float64, bool) {
+	temp, humidity, ok := inputs(temp, humidity)
+	if !ok {
+		return 0, false
+	}
+	t := temp*9/5 + 32
+	if t < 80 {
+		return temp, true
+	}
+	hi := 0.5 * (t + 61 + (t-68)*1.2 + humidity*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*humidity -
+			0.22475541*t*humidity - 0.00683783*t*t - 0.05481717*humidity*humidity +
+			0.00122874*t*t*humidity + 0.00085282*t*humidity*humidity -
+			0.00000199*t*t*humidity*humidity
+		switch {
+		case humidity < 13 && t >= 80 && t <= 112:
+			hi -= (13 - humidity) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+		case humidity > 85 && t >= 80 && t <= 87:
+			hi += (humidity - 85) / 10 * (87 - t) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9, true
+}
+
+// Humidex returns the temperature in °C it feels like, as computed by
+// Environment Canada. It is never lower than the temperature.
+func Humidex(temp, humidity float64) (float64, bool) {
+	dew, ok := DewPoint(temp, humidity)
+	if !ok {
+		return 0, false
+	}
+	e := 6.11 * math.Exp(5417.7530*(1/273.16-1/(273.15+dew)))
+	return temp + 0.5555*math.Max(0, e-10), true
+}
+
+// WetBulb returns the temperature in °C a wet thermometer would show,
+// using the approximation by Stull (2011). It is accurate to within
+// 1 °C for temperatures from -20 to 50 °C and humidities from 5%, and
+// returns false outside that range.
+func WetBulb(temp, humidity float64) (float64, bool) {
+	temp, humidity, ok := inputs(temp, humidity)
+	if !ok || temp < -20 || temp > 50 || humidity < 5 {
+		return 0, false
+	}
+	return temp*math.Atan(0.151977*math.Sqrt(humidity+8.313659)) +
+		math.Atan(temp+humidity) - math.Atan(humidity-1.676331) +
+		0.00391838*math.Pow(humidity, 1.5)*math.Atan(0.023101*humidity) -
+		4.686035, true
+}
+
+// Derive returns the named quantities of a reading that can be
+// computed, rounded to hundredths. Without names, all are returned.
+func Derive(r scrapejestad.Reading, names ...string) map[string]float64 {
+	if len(names) == 0 {
+		names = Names
+	}
+	res := make(map[string]float64, len(names))
+	for _, n := range names {
+		f, ok := funcs[n]
+		if !ok {
+			continue
+		}
+		if v, ok := f(float64(r.Temp), float64(r.Humidity)); ok {
+			res[n] = math.Round(v*100) / 100
+		}
+	}
+	return res
+}
+
+// Attach returns copies of readings with the named quantities added to
+// their Extra values. Without names, all are added.
+func Attach(readings []scrapejestad.Reading, names ...string) []scrapejestad.Reading {
+	res := make([]scrapejestad.Reading, len(readings))
+	for i, r := range readings {
+		derived := Derive(r, names...)
+		if len(derived) == 0 {
+			res[i] = r
+			continue
+		}
+		extra := make(map[string]float64, len(r.Extra)+len(derived))
+		for k, v := range r.Extra {
+			extra[k] = v
+		}
+		for k, v := range derived {
+			extra[k] = v
+		}
+		r.Extra = extra
+		res[i] = r
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package meteo
+
+import (
+	"math"
+	"testing"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_quantities(t *testing.T) {
+	tests := []struct {
+		name           string
+		f              func(temp, humidity float64) (float64, bool)
+		temp, humidity float64
+		want           float64
+	}{
+		{"dew point", DewPoint, 20, 50, 9.26},
+		{"saturated dew point", DewPoint, 20, 100, 20},
+		{"absolute humidity", AbsoluteHumidity, 20, 100, 17.25},
+		// 106 °F in the table of the National Weather Service.
+		{"heat index", HeatIndex, 32.22, 70, 41.06},
+		{"cold heat index", HeatIndex, 10, 70, 10},
+		// 41 in the table of Environment Canada.
+		{"humidex", Humidex, 30, 70, 41.21},
+		{"cold humidex", Humidex, -10, 80, -10},
+		// The example of Stull (2011).
+		{"wet bulb", WetBulb, 20, 50, 13.7},
+		{"relative humidity", RelativeHumidity, 20, 9.26, 50},
+		{"supersaturated relative humidity", RelativeHumidity, 20, 25, 100},
+	}
+	for _, test := range tests {
+		got, ok := test.f(test.temp, test.humidity)
+		if !ok {
+			t.Errorf("%s: expected a value", test.name)
+		}
+		if math.Abs(got-test.want) > 0.01 {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func Test_outOfRange(t *testing.T) {
+	for _, in := range [][2]float64{{20, 0}, {20, -5}, {20, 120}, {20, math.NaN()}, {80, 50}, {math.NaN(), 50}} {
+		for _, name := range Names {
+			if v, ok := funcs[name](in[0], in[1]); ok {
+				t.Errorf("%s of %v: expected no value, got %v", name, in, v)
+			}
+		}
+	}
+	if _, ok := WetBulb(-30, 50); ok {
+		t.Errorf("expected no wet bulb temperature below -20 °C")
+	}
+}
+
+func Test_attach(t *testing.T) {
+	readings := scrapejestadtest.Fixture()
+	readings[0].Extra = map[string]float64{"raw": 1}
+	res := Attach(readings, NameDewPoint, NameWetBulb, "unknown")
+
+	// The fixture has a humidity of 107.25%, taken to be 100%.
+	want := map[string]float64{"raw": 1, NameDewPoint: 6.88, NameWetBulb: 6.79}
+	if diff := cmp.Diff(want, res[0].Extra); diff != "" {
+		t.Errorf("extra values differ: %s", diff)
+	}
+	if len(readings[0].Extra) != 1 {
+		t.Errorf("expected the readings to be left alone, got %v", readings[0].Extra)
+	}
+	if got := Derive(res[2]); len(got) != len(Names) {
+		t.Errorf("expected all quantities, got %v", got)
+	}
+	if res := Attach([]scrapejestad.Reading{{}}); res[0].Extra != nil {
+		t.Errorf("expected no extra values without temperature and humidity, got %v", res[0].Extra)
+	}
+}
@@ -0,0 +1,244 @@
+// Package promexport serves the latest sensor values in the Prometheus
+// text exposition format.
+//
+// An Exporter polls meetjestad.net in the background and keeps the
+// latest reading of every sensor. Sensors that have not reported for
+// longer than the staleness period are no longer exported. Besides the
+// sensor values, it exports metrics about the health of the polling.
+package promexport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// ContentType is the content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Exporter polls a query and serves the latest values as metrics.
+type Exporter struct {
+	fetcher   scrapejestad.Fetcher
+	query     scrapejestad.Query
+	interval  time.Duration
+	staleness time.Duration
+	clock     scrapejestad.Clock
+
+	mu          sync.Mutex
+	latest      map[string]scrapejestad.Reading
+	scrapes     int
+	errors      int
+	lastSuccess time.Time
+	warnings    int
+	latency     time.Duration
+	stale       bool
+}
+
+// Option configures an Exporter.
+type Option func(*Exporter)
+
+// WithQuery sets the query that is polled. The default is the latest readings of all sensors.
+func WithQuery(q scrapejestad.Query) Option {
+	return func(e *Exporter) {
+		e.query = q
+	}
+}
+
+// WithInterval sets how often the query is polled. The default is one minute.
+func WithInterval(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.interval = d
+	}
+}
+
+// WithStaleness sets how old the latest reading of a sensor may be
+// before the sensor is no longer exported. The default is one hour.
+func WithStaleness(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.staleness = d
+	}
+}
+
+// WithClock sets the clock used to wait between polls and to decide staleness.
+func WithClock(c scrapejestad.Clock) Option {
+	return func(e *Exporter) {
+		e.clock = c
+	}
+}
+
+// New returns an exporter polling f.
+func New(f scrapejestad.Fetcher, opts ...Option) *Exporter {
+	e := &Exporter{
+		fetcher:   f,
+		interval:  time.Minute,
+		staleness: time.Hour,
+		clock:     scrapejestad.SystemClock,
+		latest:    make(map[string]scrapejestad.Reading),
+	}
+	for _, o := range opts {
+		o(e)
+	}
+	return e
+}
+
+// Run polls until ctx is cancelled. Errors are counted in the
+// scrape error metric. It always returns ctx.Err().
+func (e *Exporter) Run(ctx context.Context) error {
+	for {
+		e.Refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.clock.After(e.interval):
+		}
+	}
+}
+
+// Refresh polls the query once.
+func (e *Exporter) Refresh(ctx context.Context) error {
+	start := e.clock.Now()
+	res, err := e.fetcher.Fetch(ctx, e.query)
+	latency := e.clock.Now().Sub(start)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.scrapes++
+	e.latency = latency
+	if err != nil {
+		e.errors++
+		return err
+	}
+	e.lastSuccess = e.clock.Now()
+	e.warnings = len(res.Warnings)
+	e.stale = res.Stale
+	for _, r := range res.Readings {
+		if l, ok := e.latest[r.SensorID]; !ok || r.Date.After(l.Date) {
+			e.latest[r.SensorID] = r
+		}
+	}
+	return nil
+}
+
+// ServeHTTP writes the metrics.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b bytes.Buffer
+	e.write(&b)
+	w.Header().Set("Content-Type", ContentType)
+	w.Write(b.Bytes())
+}
+
+// metric is a family of samples sharing a name.
+type metric struct {
+	name, help, kind string
+	samples          []sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+func (m *metric) add(value float64, labels ...string) {
+	m.samples = append(m.samples, sample{labels: labels, value: value})
+}
+
+func (e *Exporter) write(b *bytes.Buffer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.clock.Now()
+	ids := make([]string, 0, len(e.latest))
+	for id, r := range e.latest {
+		if now.Sub(r.Date) > e.staleness {
+			delete(e.latest, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	scrapejestad.SortSensorIDs(ids)
+
+	temp := metric{name: "meetjestad_temperature_celsius", help: "Latest temperature of a sensor.", kind: "gauge"}
+	humidity := metric{name: "meetjestad_humidity_percent", help: "Latest relative humidity of a sensor.", kind: "gauge"}
+	voltage := metric{name: "meetjestad_voltage_volts", help: "Latest supply voltage of a sensor.", kind: "gauge"}
+	fcnt := metric{name: "meetjestad_fcnt", help: "Latest frame counter of a sensor.", kind: "gauge"}
+	seen := metric{name: "meetjestad_reading_timestamp_seconds", help: "Time of the latest reading of a sensor.", kind: "gauge"}
+	rssi := metric{name: "meetjestad_gateway_rssi_dbm", help: "Signal strength of the latest reading of a sensor at a gateway.", kind: "gauge"}
+	lsnr := metric{name: "meetjestad_gateway_lsnr_db", help: "Signal to noise ratio of the latest reading of a sensor at a gateway.", kind: "gauge"}
+	for _, id := range ids {
+		r := e.latest[id]
+		temp.add(float64(r.Temp), "sensor_id", id)
+		humidity.add(float64(r.Humidity), "sensor_id", id)
+		voltage.add(float64(r.Voltage), "sensor_id", id)
+		fcnt.add(float64(r.Fcnt), "sensor_id", id)
+		seen.add(float64(r.Date.Unix()), "sensor_id", id)
+		for _, g := range r.Gateways {
+			rssi.add(float64(g.RSSI), "sensor_id", id, "gateway", g.Name)
+			lsnr.add(float64(g.LSNR), "sensor_id", id, "gateway", g.Name)
+		}
+	}
+
+	scrapes := metric{name: "meetjestad_scrapes_total", help: "Number of polls of meetjestad.net.", kind: "counter"}
+	scrapes.add(float64(e.scrapes))
+	errors := metric{name: "meetjestad_scrape_errors_total", help: "Number of polls of meetjestad.net that failed.", kind: "counter"}
+	errors.add(float64(e.errors))
+	success := metric{name: "meetjestad_scrape_last_success_timestamp_seconds", help: "Time of the last successful poll.", kind: "gauge"}
+	if !e.lastSuccess.IsZero() {
+		success.add(float64(e.lastSuccess.Unix()))
+	}
+	warnings := metric{name: "meetjestad_scrape_parse_warnings", help: "Number of rows skipped by the last successful poll.", kind: "gauge"}
+	warnings.add(float64(e.warnings))
+	latency := metric{name: "meetjestad_scrape_duration_seconds", help: "Duration of the last poll.", kind: "gauge"}
+	latency.add(e.latency.Seconds())
+	stale := metric{name: "meetjestad_scrape_stale", help: "Whether the last successful poll was served from a stale cache.", kind: "gauge"}
+	stale.add(boolValue(e.stale))
+
+	for _, m := range []metric{temp, humidity, voltage, fcnt, seen, rssi, lsnr, scrapes, errors, success, warnings, latency, stale} {
+		m.write(b)
+	}
+}
+
+func (m metric) write(b *bytes.Buffer) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, s := range m.samples {
+		b.WriteString(m.name)
+		if len(s.labels) > 0 {
+			b.WriteByte('{')
+			for i := 0; i < len(s.labels); i += 2 {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				fmt.Fprintf(b, "%s=\"%s\"", s.labels[i], labelEscaper.Replace(s.labels[i+1]))
+			}
+			b.WriteByte('}')
+		}
+		b.WriteByte(' ')
+		b.WriteString(formatValue(s.value))
+		b.WriteByte('\n')
+	}
+}
+
+// labelEscaper escapes label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue formats a value with the shortest representation that
+// reads back as the float32 it usually came from.
+func formatValue(v float64) string {
+	if float64(float32(v)) == v {
+		return strconv.FormatFloat(v, 'g', -1, 32)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package promexport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time                       { return c.now }
+func (c *fixedClock) After(time.Duration) <-chan time.Time { return make(chan time.Time) }
+
+type fetcher struct {
+	res *scrapejestad.Result
+	err error
+}
+
+func (f *fetcher) Fetch(context.Context, scrapejestad.Query) (*scrapejestad.Result, error) {
+	return f.res, f.err
+}
+
+func scrape(t *testing.T, e *Exporter) string {
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type '%s'", ct)
+	}
+	return w.Body.String()
+}
+
+func Test_exporter(t *testing.T) {
+	readings := scrapejestadtest.Fixture()
+	clock := &fixedClock{now: readings[0].Date.Add(time.Minute)}
+	f := &fetcher{res: &scrapejestad.Result{Page: scrapejestad.Page{Readings: readings, Warnings: []string{"row 3: oops"}}}}
+	e := New(f, WithClock(clock), WithStaleness(24*time.Hour))
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Sensor 372 last reported in February, so it is stale.
+	body := scrape(t, e)
+	for _, want := range []string{
+		"# TYPE meetjestad_temperature_celsius gauge\nmeetjestad_temperature_celsius{sensor_id=\"242\"} 6.875\n",
+		"meetjestad_humidity_percent{sensor_id=\"242\"} 107.25\n",
+		"meetjestad_voltage_volts{sensor_id=\"242\"} 3.37\n",
+		"meetjestad_fcnt{sensor_id=\"242\"} 28357\n",
+		"meetjestad_reading_timestamp_seconds{sensor_id=\"242\"} 1.575580773e+09\n",
+		"meetjestad_gateway_rssi_dbm{sensor_id=\"242\",gateway=\"florvaag-1\"} -47\n",
+		"meetjestad_gateway_lsnr_db{sensor_id=\"242\",gateway=\"eui-00f142122877fa05\"} -1\n",
+		"meetjestad_scrapes_total 1\n",
+		"meetjestad_scrape_errors_total 0\n",
+		"meetjestad_scrape_parse_warnings 1\n",
+		"meetjestad_scrape_last_success_timestamp_seconds 1.575580833e+09\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, `sensor_id="372"`) {
+		t.Errorf("expected stale sensor 372 to be left out")
+	}
+
+	f.err = errors.New("upstream down")
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Errorf("expected error")
+	}
+	body = scrape(t, e)
+	if !strings.Contains(body, "meetjestad_scrape_errors_total 1\n") || !strings.Contains(body, `meetjestad_temperature_celsius{sensor_id="242"} 6.875`) {
+		t.Errorf("expected failed poll to be counted and the last values kept, got:\n%s", body)
+	}
+}
+
+func Test_labelEscaping(t *testing.T) {
+	r := scrapejestad.Reading{
+		SensorID: "1",
+		Date:     time.Unix(0, 0),
+		Gateways: []scrapejestad.Gateway{{Name: "a \"quoted\" \\ name\n"}},
+	}
+	f := &fetcher{res: &scrapejestad.Result{Page: scrapejestad.Page{Readings: []scrapejestad.Reading{r}}}}
+	e := New(f, WithClock(&fixedClock{now: time.Unix(60, 0)}))
+	e.Refresh(context.Background())
+
+	want := `meetjestad_gateway_rssi_dbm{sensor_id="1",gateway="a \"quoted\" \\ name\n"} 0`
+	if body := scrape(t, e); !strings.Contains(body, want) {
+		t.Errorf("expected %s, got:\n%s", want, body)
+	}
+}
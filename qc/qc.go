@@ -0,0 +1,302 @@
+// Package qc flags implausible readings, like humidity above 100%,
+// frozen values and spikes, so they can be told apart from real data.
+package qc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Flag names a kind of quality issue.
+type Flag string
+
+// The quality issues that are checked.
+const (
+	// Range is set for values outside physical limits.
+	Range Flag = "range"
+	// Saturated is set for a humidity above 100%, which sensors report
+	// when condensation forms on them.
+	Saturated Flag = "saturated"
+	// Spike is set for values far from the median of the previous ones.
+	Spike Flag = "spike"
+	// Stuck is set for values that haven't changed for several readings.
+	Stuck Flag = "stuck"
+	// Brownout is set for readings taken with a supply voltage too low
+	// for the sensor to measure reliably.
+	Brownout Flag = "brownout"
+	// Future is set for readings with a timestamp in the future.
+	Future Flag = "future"
+)
+
+// Limits is the range of plausible values of a measurement.
+type Limits struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Config holds the thresholds of the checks.
+type Config struct {
+	// Limits are the physical limits per measurement.
+	Limits map[scrapejestad.Field]Limits
+	// SpikeWindow is the number of previous readings the median is taken of.
+	SpikeWindow int
+	// SpikeThresholds are the largest plausible differences from the
+	// median per measurement. Measurements without one aren't checked.
+	SpikeThresholds map[scrapejestad.Field]float64
+	// StuckReadings is the number of readings with the same value after
+	// which a measurement is stuck.
+	StuckReadings int
+	// StuckFields are the measurements checked for stuck values.
+	StuckFields []scrapejestad.Field
+	// BrownoutVoltage is the supply voltage below which readings are unreliable.
+	BrownoutVoltage float64
+	// BrownoutDrop is the drop in voltage from the median that makes
+	// a spike or out of range value count as a brownout.
+	BrownoutDrop float64
+	// FutureTolerance is how far ahead of now timestamps may be, for clock skew.
+	FutureTolerance time.Duration
+}
+
+// DefaultConfig returns thresholds that suit the Meet je stad sensors.
+func DefaultConfig() Config {
+	return Config{
+		Limits: map[scrapejestad.Field]Limits{
+			scrapejestad.FieldTemperature: {Min: -40, Max: 60},
+			scrapejestad.FieldHumidity:    {Min: 0, Max: 110},
+			scrapejestad.FieldLight:       {Min: 0, Max: 200000},
+			scrapejestad.FieldPM25:        {Min: 0, Max: 1000},
+			scrapejestad.FieldPM10:        {Min: 0, Max: 1000},
+			scrapejestad.FieldVoltage:     {Min: 2, Max: 4.5},
+		},
+		SpikeWindow: 5,
+		SpikeThresholds: map[scrapejestad.Field]float64{
+			scrapejestad.FieldTemperature: 5,
+			scrapejestad.FieldHumidity:    20,
+		},
+		StuckReadings:   8,
+		StuckFields:     []scrapejestad.Field{scrapejestad.FieldTemperature, scrapejestad.FieldHumidity},
+		BrownoutVoltage: 2.9,
+		BrownoutDrop:    0.2,
+		FutureTolerance: 5 * time.Minute,
+	}
+}
+
+// Issue is a quality issue of a reading.
+type Issue struct {
+	Flag Flag `json:"flag"`
+	// Field is the measurement with the issue, if it concerns one.
+	Field   scrapejestad.Field `json:"field,omitempty"`
+	Message string             `json:"message"`
+}
+
+// Result is a reading with its quality issues.
+type Result struct {
+	Reading scrapejestad.Reading `json:"reading"`
+	Issues  []Issue              `json:"issues,omitempty"`
+}
+
+// Has reports whether the reading has an issue with the flag.
+func (r Result) Has(f Flag) bool {
+	for _, i := range r.Issues {
+		if i.Flag == f {
+			return true
+		}
+	}
+	return false
+}
+
+// history is what a Checker remembers of a sensor.
+type history struct {
+	last   time.Time
+	values map[scrapejestad.Field][]float64
+	// latest is the value of the last reading, independent of the spike
+	// window, and same counts the readings in a row with that value.
+	latest map[scrapejestad.Field]float64
+	same   map[scrapejestad.Field]int
+}
+
+// Checker checks readings as they arrive, remembering the previous
+// readings of every sensor.
+type Checker struct {
+	c       Config
+	sensors map[string]*history
+}
+
+// NewChecker returns a checker using c.
+func NewChecker(c Config) *Checker {
+	return &Checker{c: c, sensors: make(map[string]*history)}
+}
+
+// Check checks a reading. Readings of a sensor must be checked in the
+// order they were taken; older ones are only checked on their own.
+func (c *Checker) Check(r scrapejestad.Reading, now time.Time) Result {
+	res := Result{Reading: r}
+	h, ok := c.sensors[r.SensorID]
+	if !ok {
+		h = &history{
+			values: make(map[scrapejestad.Field][]float64),
+			latest: make(map[scrapejestad.Field]float64),
+			same:   make(map[scrapejestad.Field]int),
+		}
+		c.sensors[r.SensorID] = h
+	}
+	inOrder := !r.Date.Before(h.last)
+
+	if r.Date.After(now.Add(c.c.FutureTolerance)) {
+		res.add(Future, "", "timestamp %s is %v ahead", r.Date.Format(time.RFC3339), r.Date.Sub(now).Round(time.Second))
+	}
+
+	values := make(map[scrapejestad.Field]float64)
+	for _, f := range scrapejestad.Fields {
+		v, _ := r.Value(f)
+		if scrapejestad.OptionalField(f) && v == 0 {
+			continue
+		}
+		values[f] = v
+		if l, ok := c.c.Limits[f]; ok && (v < l.Min || v > l.Max) {
+			res.add(Range, f, "%s of %v is outside %v to %v", f, v, l.Min, l.Max)
+		}
+	}
+	if v, ok := values[scrapejestad.FieldHumidity]; ok && v > 100 {
+		res.add(Saturated, scrapejestad.FieldHumidity, "humidity of %v%% is above saturation", v)
+	}
+
+	if inOrder {
+		for _, f := range scrapejestad.Fields {
+			v, ok := values[f]
+			if !ok {
+				continue
+			}
+			prev := h.values[f]
+			if t, ok := c.c.SpikeThresholds[f]; ok && c.c.SpikeWindow > 0 && len(prev) >= c.c.SpikeWindow {
+				if m := median(prev); abs(v-m) > t {
+					res.add(Spike, f, "%s of %v is %.2f from the median of %v", f, v, abs(v-m), m)
+				}
+			}
+			if l, ok := h.latest[f]; ok && l == v {
+				h.same[f]++
+			} else {
+				h.same[f] = 1
+			}
+			h.latest[f] = v
+			if c.c.StuckReadings > 0 && h.same[f] >= c.c.StuckReadings && contains(c.c.StuckFields, f) {
+				res.add(Stuck, f, "%s has been %v for %d readings", f, v, h.same[f])
+			}
+		}
+	}
+
+	if v, ok := values[scrapejestad.FieldVoltage]; ok {
+		switch {
+		case v < c.c.BrownoutVoltage:
+			res.add(Brownout, scrapejestad.FieldVoltage, "voltage of %v is below %v", v, c.c.BrownoutVoltage)
+		case inOrder && len(h.values[scrapejestad.FieldVoltage]) > 0 && (res.Has(Spike) || res.Has(Range)):
+			if m := median(h.values[scrapejestad.FieldVoltage]); m-v > c.c.BrownoutDrop {
+				res.add(Brownout, scrapejestad.FieldVoltage, "voltage dropped from %v to %v along with implausible values", m, v)
+			}
+		}
+	}
+
+	if inOrder {
+		h.last = r.Date
+		for f, v := range values {
+			window := append(h.values[f], v)
+			if n := c.c.SpikeWindow; len(window) > n {
+				window = window[len(window)-n:]
+			}
+			h.values[f] = window
+		}
+	}
+	return res
+}
+
+func (r *Result) add(f Flag, field scrapejestad.Field, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, Issue{Flag: f, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Check checks readings and returns their results in the same order.
+// The readings of every sensor are checked in the order they were taken.
+func Check(readings []scrapejestad.Reading, c Config, now time.Time) []Result {
+	order := make([]int, len(readings))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return readings[order[i]].Date.Before(readings[order[j]].Date)
+	})
+
+	checker := NewChecker(c)
+	res := make([]Result, len(readings))
+	for _, i := range order {
+		res[i] = checker.Check(readings[i], now)
+	}
+	return res
+}
+
+// Summary counts the issues of a sensor.
+type Summary struct {
+	SensorID string `json:"sensor_id"`
+	Readings int    `json:"readings"`
+	// Flagged is the number of readings with at least one issue.
+	Flagged int          `json:"flagged"`
+	Flags   map[Flag]int `json:"flags"`
+}
+
+// Summarize returns a summary per sensor, sorted by sensor ID.
+func Summarize(results []Result) []Summary {
+	bySensor := make(map[string]*Summary)
+	var ids []string
+	for _, r := range results {
+		s, ok := bySensor[r.Reading.SensorID]
+		if !ok {
+			s = &Summary{SensorID: r.Reading.SensorID, Flags: make(map[Flag]int)}
+			bySensor[s.SensorID] = s
+			ids = append(ids, s.SensorID)
+		}
+		s.Readings++
+		if len(r.Issues) > 0 {
+			s.Flagged++
+		}
+		seen := make(map[Flag]bool)
+		for _, i := range r.Issues {
+			if !seen[i.Flag] {
+				seen[i.Flag] = true
+				s.Flags[i.Flag]++
+			}
+		}
+	}
+	scrapejestad.SortSensorIDs(ids)
+	res := make([]Summary, len(ids))
+	for i, id := range ids {
+		res[i] = *bySensor[id]
+	}
+	return res
+}
+
+func median(values []float64) float64 {
+	s := append([]float64{}, values...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+func abs(v float64) float64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func contains(fields []scrapejestad.Field, f scrapejestad.Field) bool {
+	for _, g := range fields {
+		if g == f {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package qc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+var start = time.Date(2019, 12, 5, 21, 0, 0, 0, time.UTC)
+
+func reading(sensor string, minutes int, temp, humidity, voltage float32) scrapejestad.Reading {
+	d := start.Add(time.Duration(minutes) * time.Minute)
+	return scrapejestad.Reading{SensorID: sensor, Date: d, Time: d.Unix(), Temp: temp, Humidity: humidity, Voltage: voltage}
+}
+
+func flags(r Result) []Flag {
+	var res []Flag
+	for _, i := range r.Issues {
+		res = append(res, i.Flag)
+	}
+	return res
+}
+
+func Test_Check(t *testing.T) {
+	now := start.Add(time.Hour)
+	tests := []struct {
+		name     string
+		readings []scrapejestad.Reading
+		// expected are the flags of the last reading.
+		expected []Flag
+	}{
+		{name: "plausible", readings: []scrapejestad.Reading{reading("1", 0, 10, 80, 3.3)}},
+		{name: "too cold", readings: []scrapejestad.Reading{reading("1", 0, -60, 80, 3.3)}, expected: []Flag{Range}},
+		{name: "saturated", readings: []scrapejestad.Reading{reading("1", 0, 10, 104, 3.3)}, expected: []Flag{Saturated}},
+		{name: "humidity out of range", readings: []scrapejestad.Reading{reading("1", 0, 10, 120, 3.3)}, expected: []Flag{Range, Saturated}},
+		{name: "future", readings: []scrapejestad.Reading{reading("1", 70, 10, 80, 3.3)}, expected: []Flag{Future}},
+		{name: "within clock skew", readings: []scrapejestad.Reading{reading("1", 64, 10, 80, 3.3)}},
+		{name: "low voltage", readings: []scrapejestad.Reading{reading("1", 0, 10, 80, 2.7)}, expected: []Flag{Brownout}},
+		{
+			name: "spike",
+			readings: []scrapejestad.Reading{
+				reading("1", 0, 10, 80, 3.3), reading("1", 1, 10.2, 80, 3.3), reading("1", 2, 10.1, 81, 3.3),
+				reading("1", 3, 10.3, 80, 3.3), reading("1", 4, 10.2, 79, 3.3), reading("1", 5, 21, 80, 3.3),
+			},
+			expected: []Flag{Spike},
+		},
+		{
+			name: "spike with voltage drop",
+			readings: []scrapejestad.Reading{
+				reading("1", 0, 10, 80, 3.3), reading("1", 1, 10.2, 80, 3.3), reading("1", 2, 10.1, 81, 3.3),
+				reading("1", 3, 10.3, 80, 3.3), reading("1", 4, 10.2, 79, 3.3), reading("1", 5, 21, 80, 3.0),
+			},
+			expected: []Flag{Spike, Brownout},
+		},
+		{
+			name: "too few readings for a spike",
+			readings: []scrapejestad.Reading{
+				reading("1", 0, 10, 80, 3.3), reading("1", 1, 10.2, 80, 3.3), reading("1", 2, 21, 80, 3.3),
+			},
+		},
+		{
+			name: "other sensor",
+			readings: []scrapejestad.Reading{
+				reading("1", 0, 10, 80, 3.3), reading("1", 1, 10.2, 80, 3.3), reading("1", 2, 10.1, 81, 3.3),
+				reading("1", 3, 10.3, 80, 3.3), reading("1", 4, 10.2, 79, 3.3), reading("2", 5, 21, 80, 3.3),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Check(tt.readings, DefaultConfig(), now)
+			if len(res) != len(tt.readings) {
+				t.Fatalf("expected %d results, got %d", len(tt.readings), len(res))
+			}
+			if diff := cmp.Diff(tt.expected, flags(res[len(res)-1])); diff != "" {
+				t.Errorf("unexpected flags (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_CheckStuck(t *testing.T) {
+	c := DefaultConfig()
+	c.StuckReadings = 3
+	var readings []scrapejestad.Reading
+	for i := 0; i < 5; i++ {
+		readings = append(readings, reading("1", i, 12.5, 80+float32(i), 3.3))
+	}
+	// Stuck values don't depend on the spike window.
+	for _, window := range []int{c.SpikeWindow, 1, 0} {
+		c.SpikeWindow = window
+		res := Check(readings, c, start.Add(time.Hour))
+		for i, r := range res {
+			if expected := i >= 2; r.Has(Stuck) != expected {
+				t.Errorf("window %d: expected stuck to be %v for reading %d, got %v", window, expected, i, r.Has(Stuck))
+			}
+			for _, issue := range r.Issues {
+				if issue.Field != scrapejestad.FieldTemperature {
+					t.Errorf("window %d: expected only temperature to be stuck, got %s", window, issue.Field)
+				}
+			}
+		}
+	}
+}
+
+func Test_CheckOrder(t *testing.T) {
+	c := DefaultConfig()
+	c.StuckReadings = 3
+	// Given out of order, the readings are still checked oldest first.
+	readings := []scrapejestad.Reading{
+		reading("1", 2, 12.5, 80, 3.3),
+		reading("1", 0, 12.5, 81, 3.3),
+		reading("1", 1, 12.5, 82, 3.3),
+	}
+	res := Check(readings, c, start.Add(time.Hour))
+	if !res[0].Has(Stuck) || res[1].Has(Stuck) || res[2].Has(Stuck) {
+		t.Errorf("expected only the newest reading to be stuck, got %v, %v and %v", flags(res[0]), flags(res[1]), flags(res[2]))
+	}
+	if res[0].Reading.Date != readings[0].Date {
+		t.Errorf("expected results in the order of the readings")
+	}
+}
+
+func Test_CheckerMissingValues(t *testing.T) {
+	c := NewChecker(DefaultConfig())
+	for i := 0; i < 10; i++ {
+		r := reading("1", i, 10, 80, 3.3)
+		r.Temp += float32(i) / 10
+		r.Humidity += float32(i)
+		// Light and particulate matter are zero when missing.
+		if res := c.Check(r, start.Add(time.Hour)); len(res.Issues) > 0 {
+			t.Errorf("expected no issues for reading %d, got %v", i, res.Issues)
+		}
+	}
+}
+
+func Test_Summarize(t *testing.T) {
+	readings := []scrapejestad.Reading{
+		reading("10", 0, 10, 104, 3.3),
+		reading("10", 1, 10, 80, 2.5),
+		reading("2", 0, 10, 120, 3.3),
+		reading("2", 1, 10, 80, 3.3),
+		reading("2", 90, 10, 80, 3.3),
+	}
+	got := Summarize(Check(readings, DefaultConfig(), start.Add(time.Hour)))
+	expected := []Summary{
+		{SensorID: "2", Readings: 3, Flagged: 2, Flags: map[Flag]int{Range: 1, Saturated: 1, Future: 1}},
+		{SensorID: "10", Readings: 2, Flagged: 2, Flags: map[Flag]int{Saturated: 1, Brownout: 1}},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("unexpected summary (-want +got):\n%s", diff)
+	}
+}
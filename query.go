@@ -0,0 +1,96 @@
+package scrapejestad
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultBaseURL is the address of the recent sensor data page on meetjestad.net.
+const DefaultBaseURL = "https://meetjestad.net/data/sensors_recent.php"
+
+// Format is the document format requested from meetjestad.net.
+type Format string
+
+const (
+	// FormatHTML requests the HTML table including gateway receptions.
+	FormatHTML Format = "html"
+	// FormatJSON requests the JSON document without gateway receptions.
+	FormatJSON Format = "json"
+)
+
+// Query describes which readings to request from meetjestad.net.
+type Query struct {
+	Sensors  []int
+	Limit    int
+	Gateways []string
+	Format   Format
+}
+
+// Normalize returns a copy of the query with sorted, de-duplicated
+// sensors and gateways and the default format filled in.
+func (q Query) Normalize() Query {
+	n := Query{
+		Sensors: normalizeSensors(q.Sensors),
+		Limit:   q.Limit,
+		Format:  q.Format,
+	}
+	if n.Format == "" {
+		n.Format = FormatHTML
+	}
+	if n.Limit < 0 {
+		n.Limit = 0
+	}
+	if len(q.Gateways) > 0 {
+		seen := make(map[string]bool, len(q.Gateways))
+		for _, g := range q.Gateways {
+			if g == "" || seen[g] {
+				continue
+			}
+			seen[g] = true
+			n.Gateways = append(n.Gateways, g)
+		}
+		sort.Strings(n.Gateways)
+	}
+	return n
+}
+
+// Values returns the query parameters understood by sensors_recent.php.
+func (q Query) Values() url.Values {
+	q = q.Normalize()
+	v := url.Values{}
+	if len(q.Sensors) > 0 {
+		v.Set("sensors", FormatSensors(q.Sensors))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if len(q.Gateways) > 0 {
+		v.Set("gateways", strings.Join(q.Gateways, ","))
+		v.Set("show_other_gateways", "1")
+	}
+	if q.Format == FormatJSON {
+		v.Set("format", "json")
+	}
+	return v
+}
+
+// Key returns a string that is equal for queries requesting the same data.
+func (q Query) Key() string {
+	return q.Values().Encode()
+}
+
+// URL returns the address of the query relative to the given base URL.
+func (q Query) URL(base string) (*url.URL, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	v := u.Query()
+	for k, vals := range q.Values() {
+		v[k] = vals
+	}
+	u.RawQuery = v.Encode()
+	return u, nil
+}
@@ -0,0 +1,495 @@
+// Package readingcsv writes readings as CSV and reads them back.
+//
+// Readings can be written one row per reading, with the gateway that
+// heard it best, or one row per reading and gateway. The reader accepts
+// both layouts and merges the rows of a reading back together.
+package readingcsv
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Layout decides how gateways are laid out in rows.
+type Layout int
+
+const (
+	// BestGateway writes one row per reading with the gateway that had the strongest signal.
+	BestGateway Layout = iota
+	// PerGateway writes one row for every gateway that heard a reading.
+	PerGateway
+)
+
+// Column is a column of the CSV file. Its value is the column header.
+type Column string
+
+// The columns that can be written.
+const (
+	SensorID         Column = "sensor_id"
+	Time             Column = "time"
+	Temperature      Column = "temperature"
+	Humidity         Column = "humidity"
+	Light            Column = "light"
+	PM25             Column = "pm25"
+	PM10             Column = "pm10"
+	Voltage          Column = "voltage"
+	Firmware         Column = "firmware"
+	Latitude         Column = "latitude"
+	Longitude        Column = "longitude"
+	Fcnt             Column = "fcnt"
+	Gateway          Column = "gateway"
+	GatewayLatitude  Column = "gateway_latitude"
+	GatewayLongitude Column = "gateway_longitude"
+	Distance         Column = "distance"
+	RSSI             Column = "rssi"
+	LSNR             Column = "lsnr"
+	Frequency        Column = "frequency"
+	SpreadingFactor  Column = "sf"
+	CodingRate       Column = "cr"
+)
+
+// DefaultColumns are all columns, in the order they are written by default.
+var DefaultColumns = []Column{
+	SensorID, Time, Temperature, Humidity, Light, PM25, PM10, Voltage, Firmware, Latitude, Longitude, Fcnt,
+	Gateway, GatewayLatitude, GatewayLongitude, Distance, RSSI, LSNR, Frequency, SpreadingFactor, CodingRate,
+}
+
+// format holds the settings shared by Writer and Reader.
+type format struct {
+	columns    []Column
+	layout     Layout
+	delimiter  rune
+	decimal    string
+	timeFormat string
+	location   *time.Location
+	null       string
+	extra      []Column
+}
+
+// Option configures a Writer or Reader.
+type Option func(*format)
+
+// WithColumns sets the columns to write.
+// The reader takes the columns from the header instead.
+func WithColumns(columns ...Column) Option {
+	return func(f *format) {
+		f.columns = columns
+	}
+}
+
+// WithExtra adds columns for values in Reading.Extra, like the ones
+// computed by the meteo package. The reader needs the same option to
+// accept them.
+func WithExtra(names ...string) Option {
+	return func(f *format) {
+		for _, n := range names {
+			f.extra = append(f.extra, Column(n))
+		}
+	}
+}
+
+// WithLayout sets how gateways are written. The default is BestGateway.
+func WithLayout(l Layout) Option {
+	return func(f *format) {
+		f.layout = l
+	}
+}
+
+// WithDelimiter sets the field delimiter. The default is a comma.
+func WithDelimiter(r rune) Option {
+	return func(f *format) {
+		f.delimiter = r
+	}
+}
+
+// WithDecimalSeparator sets the decimal separator, like ',' for
+// spreadsheets in Dutch. Combine it with another delimiter, like ';'.
+func WithDecimalSeparator(r rune) Option {
+	return func(f *format) {
+		f.decimal = string(r)
+	}
+}
+
+// WithTimeFormat sets the layout of timestamps. The default is RFC 3339.
+func WithTimeFormat(layout string) Option {
+	return func(f *format) {
+		f.timeFormat = layout
+	}
+}
+
+// WithLocation sets the time zone of timestamps. The default is UTC.
+func WithLocation(loc *time.Location) Option {
+	return func(f *format) {
+		f.location = loc
+	}
+}
+
+// WithNull sets how missing values are written. The default is an empty field.
+func WithNull(s string) Option {
+	return func(f *format) {
+		f.null = s
+	}
+}
+
+func newFormat(opts []Option) format {
+	f := format{
+		columns:    DefaultColumns,
+		delimiter:  ',',
+		decimal:    ".",
+		timeFormat: time.RFC3339,
+		location:   time.UTC,
+	}
+	for _, o := range opts {
+		o(&f)
+	}
+	return f
+}
+
+// Writer writes readings as CSV. The header is written before the first readings.
+type Writer struct {
+	w      *csv.Writer
+	f      format
+	header bool
+}
+
+// NewWriter returns a writer writing to w.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	cw := &Writer{w: csv.NewWriter(w), f: newFormat(opts)}
+	cw.w.Comma = cw.f.delimiter
+	return cw
+}
+
+// Write writes readings. Call Flush when done.
+func (w *Writer) Write(readings []scrapejestad.Reading) error {
+	columns := w.f.headers()
+	if !w.header {
+		header := make([]string, len(columns))
+		for i, c := range columns {
+			header[i] = string(c)
+		}
+		if err := w.w.Write(header); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	record := make([]string, len(columns))
+	for _, r := range readings {
+		for _, g := range w.gateways(r) {
+			for i, c := range columns {
+				record[i] = w.f.value(c, r, g)
+			}
+			if err := w.w.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return w.w.Error()
+}
+
+// Flush writes buffered rows to the underlying writer.
+func (w *Writer) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// gateways returns the gateways to write a row for. A reading without
+// gateways gets one row with empty gateway columns.
+func (w *Writer) gateways(r scrapejestad.Reading) []*scrapejestad.Gateway {
+	if len(r.Gateways) == 0 {
+		return []*scrapejestad.Gateway{nil}
+	}
+	if w.f.layout == PerGateway {
+		res := make([]*scrapejestad.Gateway, len(r.Gateways))
+		for i := range r.Gateways {
+			res[i] = &r.Gateways[i]
+		}
+		return res
+	}
+	best := &r.Gateways[0]
+	for i := range r.Gateways {
+		if r.Gateways[i].RSSI > best.RSSI {
+			best = &r.Gateways[i]
+		}
+	}
+	return []*scrapejestad.Gateway{best}
+}
+
+func (f format) value(c Column, r scrapejestad.Reading, g *scrapejestad.Gateway) string {
+	switch c {
+	case SensorID:
+		return r.SensorID
+	case Time:
+		return r.Date.In(f.location).Format(f.timeFormat)
+	case Temperature:
+		return f.float(r.Temp)
+	case Humidity:
+		return f.float(r.Humidity)
+	case Light:
+		return f.optional(r.Light)
+	case PM25:
+		return f.optional(r.PM25)
+	case PM10:
+		return f.optional(r.PM10)
+	case Voltage:
+		return f.float(r.Voltage)
+	case Firmware:
+		return r.Firmware
+	case Latitude, Longitude:
+		if r.Position == (scrapejestad.Position{}) {
+			return f.null
+		}
+		if c == Latitude {
+			return f.float(r.Position.Lat)
+		}
+		return f.float(r.Position.Lng)
+	case Fcnt:
+		return strconv.Itoa(r.Fcnt)
+	}
+	if f.isExtra(c) {
+		v, ok := r.Extra[string(c)]
+		if !ok {
+			return f.null
+		}
+		return f.decimalSeparator(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	if g == nil {
+		return f.null
+	}
+	switch c {
+	case Gateway:
+		return g.Name
+	case GatewayLatitude:
+		return f.float(g.Position.Lat)
+	case GatewayLongitude:
+		return f.float(g.Position.Lng)
+	case Distance:
+		return f.optional(g.Distance)
+	case RSSI:
+		return f.float(g.RSSI)
+	case LSNR:
+		return f.float(g.LSNR)
+	case Frequency:
+		return f.float(g.RadioSettings.Frequency)
+	case SpreadingFactor:
+		return g.RadioSettings.Sf
+	case CodingRate:
+		return g.RadioSettings.Cr
+	}
+	return f.null
+}
+
+func (f format) float(v float32) string {
+	return f.decimalSeparator(strconv.FormatFloat(float64(v), 'f', -1, 32))
+}
+
+func (f format) decimalSeparator(s string) string {
+	if f.decimal != "." {
+		s = strings.Replace(s, ".", f.decimal, 1)
+	}
+	return s
+}
+
+// optional writes zero as null, since the site leaves these values out.
+func (f format) optional(v float32) string {
+	if v == 0 {
+		return f.null
+	}
+	return f.float(v)
+}
+
+// Reader reads readings from CSV written by Writer.
+type Reader struct {
+	r *csv.Reader
+	f format
+}
+
+// NewReader returns a reader reading from r. The options must match
+// the ones the file was written with, except for the columns and
+// layout, which are taken from the file.
+func NewReader(r io.Reader, opts ...Option) *Reader {
+	cr := &Reader{r: csv.NewReader(r), f: newFormat(opts)}
+	cr.r.Comma = cr.f.delimiter
+	cr.r.ReuseRecord = true
+	return cr
+}
+
+// ReadAll reads all readings. Consecutive rows of the same reading
+// are merged into one reading with all their gateways.
+func (r *Reader) ReadAll() ([]scrapejestad.Reading, error) {
+	header, err := r.r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	columns := make([]Column, len(header))
+	known := make(map[Column]bool, len(DefaultColumns))
+	for _, c := range DefaultColumns {
+		known[c] = true
+	}
+	for _, c := range r.f.extra {
+		known[c] = true
+	}
+	for i, h := range header {
+		c := Column(strings.TrimSpace(h))
+		if !known[c] {
+			return nil, fmt.Errorf("unknown column '%s'", h)
+		}
+		columns[i] = c
+	}
+
+	var res []scrapejestad.Reading
+	for row := 2; ; row++ {
+		record, err := r.r.Read()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		reading, g, err := r.f.parse(columns, record)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing row %d: %v", row, err)
+		}
+		if n := len(res); n > 0 && sameReading(res[n-1], reading) {
+			if g != nil {
+				res[n-1].Gateways = append(res[n-1].Gateways, *g)
+			}
+			continue
+		}
+		if g != nil {
+			reading.Gateways = []scrapejestad.Gateway{*g}
+		}
+		res = append(res, reading)
+	}
+}
+
+func sameReading(a, b scrapejestad.Reading) bool {
+	return a.SensorID == b.SensorID && a.Date.Equal(b.Date) && a.Fcnt == b.Fcnt
+}
+
+// parse returns the reading in a record and its gateway, if the record has one.
+func (f format) parse(columns []Column, record []string) (scrapejestad.Reading, *scrapejestad.Gateway, error) {
+	var r scrapejestad.Reading
+	var g scrapejestad.Gateway
+	hasGateway := false
+
+	for i, c := range columns {
+		v := record[i]
+		if v == f.null || v == "" {
+			continue
+		}
+		var err error
+		switch c {
+		case SensorID:
+			r.SensorID = v
+		case Time:
+			var t time.Time
+			if t, err = time.ParseInLocation(f.timeFormat, v, f.location); err == nil {
+				r.Date = t.UTC()
+				r.Time = t.Unix()
+			}
+		case Temperature:
+			r.Temp, err = f.parseFloat(v)
+		case Humidity:
+			r.Humidity, err = f.parseFloat(v)
+		case Light:
+			r.Light, err = f.parseFloat(v)
+		case PM25:
+			r.PM25, err = f.parseFloat(v)
+		case PM10:
+			r.PM10, err = f.parseFloat(v)
+		case Voltage:
+			r.Voltage, err = f.parseFloat(v)
+		case Firmware:
+			r.Firmware = v
+		case Latitude:
+			r.Position.Lat, err = f.parseFloat(v)
+		case Longitude:
+			r.Position.Lng, err = f.parseFloat(v)
+		case Fcnt:
+			r.Fcnt, err = strconv.Atoi(v)
+		default:
+			if f.isExtra(c) {
+				var n float64
+				if n, err = strconv.ParseFloat(f.dot(v), 64); err == nil {
+					if r.Extra == nil {
+						r.Extra = make(map[string]float64)
+					}
+					r.Extra[string(c)] = n
+				}
+				break
+			}
+			hasGateway = true
+			err = f.parseGateway(c, v, &g)
+		}
+		if err != nil {
+			return r, nil, fmt.Errorf("error parsing %s '%s': %v", c, v, err)
+		}
+	}
+	if !hasGateway {
+		return r, nil, nil
+	}
+	return r, &g, nil
+}
+
+func (f format) parseGateway(c Column, v string, g *scrapejestad.Gateway) error {
+	var err error
+	switch c {
+	case Gateway:
+		g.Name = v
+	case GatewayLatitude:
+		g.Position.Lat, err = f.parseFloat(v)
+	case GatewayLongitude:
+		g.Position.Lng, err = f.parseFloat(v)
+	case Distance:
+		g.Distance, err = f.parseFloat(v)
+	case RSSI:
+		g.RSSI, err = f.parseFloat(v)
+	case LSNR:
+		g.LSNR, err = f.parseFloat(v)
+	case Frequency:
+		g.RadioSettings.Frequency, err = f.parseFloat(v)
+	case SpreadingFactor:
+		g.RadioSettings.Sf = v
+	case CodingRate:
+		g.RadioSettings.Cr = v
+	}
+	return err
+}
+
+func (f format) parseFloat(v string) (float32, error) {
+	n, err := strconv.ParseFloat(f.dot(v), 32)
+	return float32(n), err
+}
+
+// dot replaces the decimal separator with a dot.
+func (f format) dot(v string) string {
+	if f.decimal != "." {
+		v = strings.Replace(v, f.decimal, ".", 1)
+	}
+	return v
+}
+
+// headers returns the columns followed by the extra columns.
+func (f format) headers() []Column {
+	return append(f.columns[:len(f.columns):len(f.columns)], f.extra...)
+}
+
+func (f format) isExtra(c Column) bool {
+	for _, e := range f.extra {
+		if e == c {
+			return true
+		}
+	}
+	return false
+}
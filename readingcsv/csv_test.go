@@ -0,0 +1,128 @@
+package readingcsv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/meteo"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func write(t *testing.T, readings []scrapejestad.Reading, opts ...Option) string {
+	var b bytes.Buffer
+	w := NewWriter(&b, opts...)
+	if err := w.Write(readings); err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("error flushing: %v", err)
+	}
+	return b.String()
+}
+
+func Test_roundTripPerGateway(t *testing.T) {
+	want := scrapejestadtest.Fixture()
+	data := write(t, want, WithLayout(PerGateway))
+	if n := strings.Count(data, "\n"); n != 10 {
+		t.Errorf("expected a header and 9 rows, got %d lines", n)
+	}
+
+	got, err := NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("readings differ: %s", diff)
+	}
+}
+
+func Test_bestGateway(t *testing.T) {
+	readings := scrapejestadtest.Fixture()
+	data := write(t, readings, WithColumns(SensorID, Fcnt, Gateway, RSSI))
+	want := `sensor_id,fcnt,gateway,rssi
+242,28357,florvaag-1,-47
+242,28356,florvaag-1,-45
+372,1,fana-bergen-gateway-01,-103
+372,0,fana-bergen-gateway-01,-107
+`
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Errorf("csv differs: %s", diff)
+	}
+
+	got, err := NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if len(got) != 4 || len(got[0].Gateways) != 1 || got[0].Gateways[0].RSSI != -47 {
+		t.Errorf("unexpected readings %+v", got)
+	}
+}
+
+func Test_dutchExcel(t *testing.T) {
+	amsterdam := time.FixedZone("CET", 3600)
+	opts := []Option{
+		WithDelimiter(';'),
+		WithDecimalSeparator(','),
+		WithTimeFormat("02-01-2006 15:04:05"),
+		WithLocation(amsterdam),
+		WithNull("NULL"),
+	}
+	readings := scrapejestadtest.Fixture()[2:3]
+	data := write(t, readings, append(opts, WithColumns(SensorID, Time, Temperature, Light, Latitude, Distance))...)
+	want := `sensor_id;time;temperature;light;latitude;distance
+372;15-02-2019 22:22:58;22,6875;NULL;NULL;NULL
+`
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Errorf("csv differs: %s", diff)
+	}
+
+	got, err := NewReader(strings.NewReader(data), opts...).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if len(got) != 1 || got[0].Temp != 22.6875 || !got[0].Date.Equal(readings[0].Date) {
+		t.Errorf("unexpected readings %+v", got)
+	}
+}
+
+func Test_extraColumns(t *testing.T) {
+	readings := meteo.Attach(scrapejestadtest.Fixture()[2:], meteo.NameDewPoint)
+	readings[1].Extra = nil
+	opts := []Option{WithColumns(SensorID, Fcnt, Temperature), WithExtra(meteo.NameDewPoint, "raw"), WithDecimalSeparator(','), WithDelimiter(';')}
+	data := write(t, readings, opts...)
+	want := `sensor_id;fcnt;temperature;dew_point;raw
+372;1;22,6875;4,83;
+372;0;23,8125;;
+`
+	if diff := cmp.Diff(want, data); diff != "" {
+		t.Errorf("csv differs: %s", diff)
+	}
+
+	got, err := NewReader(strings.NewReader(data), opts...).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if len(got) != 2 || !cmp.Equal(got[0].Extra, map[string]float64{meteo.NameDewPoint: 4.83}) || got[1].Extra != nil {
+		t.Errorf("unexpected readings %+v", got)
+	}
+	if _, err := NewReader(strings.NewReader(data), WithDelimiter(';')).ReadAll(); err == nil {
+		t.Errorf("expected error for extra columns without option")
+	}
+}
+
+func Test_readErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown column": "sensor_id,pressure\n242,1013\n",
+		"bad number":     "sensor_id,temperature\n242,warm\n",
+		"bad time":       "sensor_id,time\n242,yesterday\n",
+	}
+	for name, data := range tests {
+		if _, err := NewReader(strings.NewReader(data)).ReadAll(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package scrapejestad
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Render writes readings in the markup of a sensors_recent page,
+// so that Parse returns them unchanged. Every row of the table has
+// a gateway, so readings without gateways get an empty one.
+func Render(w io.Writer, readings []Reading) error {
+	return RenderPage(w, &Page{Readings: readings})
+}
+
+// RenderPage writes a full sensors_recent page. The statistics are
+// computed from the readings, so p.Stats is ignored.
+func RenderPage(w io.Writer, p *Page) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html class=\"no-js\">\n\t<head>\n")
+	if p.Refresh > 0 {
+		fmt.Fprintf(&b, "\t\t<meta http-equiv=\"refresh\" content=\"%d\">\n", int(p.Refresh/time.Second))
+	}
+	b.WriteString(`	</head>
+	<body>
+		<table border="1">
+			<tr>
+				<th>ID</th>
+				<th>Time</th>
+				<th>Temp</th>
+				<th>Humidity</th>
+				<th>Light</th>
+				<th>PM2.5</th>
+				<th>PM10</th>
+				<th>Voltage</th>
+				<th>Extra</th>
+				<th>Firmware</th>
+				<th>Position</th>
+				<th>Fcnt</th>
+				<th>Gateways</th>
+				<th>Distance</th>
+				<th>RSSI</th>
+				<th>LSNR</th>
+				<th>Radiosettings</th>
+			</tr>
+`)
+	for _, r := range p.Readings {
+		renderReading(&b, r)
+	}
+	b.WriteString("\t\t</table>\n")
+	renderStats(&b, p.Readings)
+	renderDatasets(&b, p.Datasets)
+	b.WriteString("\t</body>\n</html>\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func renderReading(b *strings.Builder, r Reading) {
+	gateways := r.Gateways
+	if len(gateways) == 0 {
+		gateways = []Gateway{{}}
+	}
+	span := fmt.Sprintf(`<td rowspan="%d">`, len(gateways))
+	id := html.EscapeString(r.SensorID)
+
+	b.WriteString("<tr>\n")
+	fmt.Fprintf(b, "  %s <a href=\"?sensors=%s&amp;limit=50\">%s</a></td>\n", span, id, id)
+	fmt.Fprintf(b, "  %s %s</td>\n", span, r.Date.UTC().Format(TimestampLayout))
+	fmt.Fprintf(b, "  %s %s°C</td>\n", span, formatFloat(r.Temp))
+	fmt.Fprintf(b, "  %s %s%%</td>\n", span, formatFloat(r.Humidity))
+	fmt.Fprintf(b, "  %s %s</td>\n", span, optionalFloat(r.Light))
+	fmt.Fprintf(b, "  %s %s</td>\n", span, optionalFloat(r.PM25))
+	fmt.Fprintf(b, "  %s %s</td>\n", span, optionalFloat(r.PM10))
+	fmt.Fprintf(b, "  %s %sV</td>\n", span, formatFloat(r.Voltage))
+	fmt.Fprintf(b, "  %s </td>\n", span)
+	fmt.Fprintf(b, "  %s %s</td>\n", span, html.EscapeString(r.Firmware))
+	if r.Position == (Position{}) {
+		fmt.Fprintf(b, "  %s No position</td>\n", span)
+	} else {
+		lat, lng := formatFloat(r.Position.Lat), formatFloat(r.Position.Lng)
+		fmt.Fprintf(b, "  %s <a href=\"http://www.openstreetmap.org/?mlat=%s&amp;mlon=%s\">%s / %s</a></td>\n", span, lat, lng, lat, lng)
+	}
+	fmt.Fprintf(b, "  %s %d</td>\n", span, r.Fcnt)
+	for i, g := range gateways {
+		if i > 0 {
+			b.WriteString("</tr>\n<tr>\n")
+		}
+		renderGateway(b, g)
+	}
+	b.WriteString("</tr>\n")
+}
+
+func renderGateway(b *strings.Builder, g Gateway) {
+	fmt.Fprintf(b, "  <td><a href=\"http://www.openstreetmap.org/?mlat=%s&amp;mlon=%s\">%s</a></td>\n",
+		formatFloat(g.Position.Lat), formatFloat(g.Position.Lng), html.EscapeString(g.Name))
+	if g.Distance == 0 {
+		b.WriteString("  <td>-</td>\n")
+	} else {
+		fmt.Fprintf(b, "  <td>%skm</td>\n", formatFloat(g.Distance))
+	}
+	fmt.Fprintf(b, "  <td>%s</td>\n", formatFloat(g.RSSI))
+	fmt.Fprintf(b, "  <td>%s</td>\n", formatFloat(g.LSNR))
+	s := g.RadioSettings
+	fmt.Fprintf(b, "  <td>%sMhz, %s, %s</td>\n", formatFloat(s.Frequency), html.EscapeString(s.Sf), html.EscapeString(s.Cr))
+}
+
+func renderStats(b *strings.Builder, readings []Reading) {
+	perNode := make(map[string]int)
+	type gatewayStats struct {
+		messages int
+		sensors  map[string]float32
+	}
+	perGateway := make(map[string]*gatewayStats)
+	for _, r := range readings {
+		perNode[r.SensorID]++
+		for _, g := range r.Gateways {
+			s, ok := perGateway[g.Name]
+			if !ok {
+				s = &gatewayStats{sensors: make(map[string]float32)}
+				perGateway[g.Name] = s
+			}
+			s.messages++
+			s.sensors[r.SensorID] = g.Distance
+		}
+	}
+
+	fmt.Fprintf(b, "\t\t<p>Message count: %d</p>\n", len(readings))
+	fmt.Fprintf(b, "\t\t<p>Node count: %d</p>\n", len(perNode))
+	b.WriteString("\t\t<p><b>Messages per node</b></p>\n\t\t<table border=\"1\">\n")
+	b.WriteString("\t\t<tr><th>Number of messages in list above</th><th>Nodes</th></tr>\n")
+	ids := make([]string, 0, len(perNode))
+	for id := range perNode {
+		ids = append(ids, id)
+	}
+	for _, id := range SortSensorIDs(ids) {
+		fmt.Fprintf(b, "\t\t<tr><td>%d</td><td>%s</td></tr>\n", perNode[id], html.EscapeString(id))
+	}
+	b.WriteString("\t\t</table>\n\n")
+
+	names := make([]string, 0, len(perGateway))
+	for n := range perGateway {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if perGateway[names[i]].messages != perGateway[names[j]].messages {
+			return perGateway[names[i]].messages > perGateway[names[j]].messages
+		}
+		return names[i] < names[j]
+	})
+	b.WriteString("\t\t<p><b>Statistics per gateway</b></p>\n\t\t<table border=\"1\">\n")
+	b.WriteString("\t\t<tr><th>Gateway</th><th>Number of messages</th><th>Number of nodes</th><th>Nodes</th></tr>\n")
+	for _, n := range names {
+		s := perGateway[n]
+		ids := make([]string, 0, len(s.sensors))
+		for id := range s.sensors {
+			ids = append(ids, id)
+		}
+		var nodes []string
+		for _, id := range SortSensorIDs(ids) {
+			node := fmt.Sprintf("<a href=\"?sensors=%s\">%s</a>", html.EscapeString(id), html.EscapeString(id))
+			if d := s.sensors[id]; d != 0 {
+				node += fmt.Sprintf(" (%skm)", formatFloat(d))
+			}
+			nodes = append(nodes, node)
+		}
+		name := html.EscapeString(n)
+		fmt.Fprintf(b, "\t\t<tr><td>%s (<a href=\"?gateways=%s&amp;show_other_gateways=1\">filter</a>)</td><td>%d</td><td>%d</td><td>%s</td></tr>\n",
+			name, name, s.messages, len(s.sensors), strings.Join(nodes, ", "))
+	}
+	b.WriteString("\t\t</table>\n")
+}
+
+func renderDatasets(b *strings.Builder, datasets []Dataset) {
+	b.WriteString("\t\t<p><b>Filter by dataset</b></p>\n\t\t<ul>\n")
+	for _, d := range datasets {
+		fmt.Fprintf(b, "\t\t\t<li><a href=\"?sensors=%s\">%s</a></li>\n", FormatSensors(d.Sensors), html.EscapeString(d.Name))
+	}
+	b.WriteString("\t\t</ul>\n")
+}
+
+func formatFloat(f float32) string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 32)
+}
+
+func optionalFloat(f float32) string {
+	if f == 0 {
+		return ""
+	}
+	return formatFloat(f)
+}
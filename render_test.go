@@ -0,0 +1,119 @@
+package scrapejestad
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// randomReadings generates readings that can be shown on a page.
+type randomReadings []Reading
+
+func (randomReadings) Generate(rnd *rand.Rand, size int) reflect.Value {
+	between := func(min, max float64) float32 {
+		return float32(min + rnd.Float64()*(max-min))
+	}
+	res := make(randomReadings, rnd.Intn(size+1))
+	for i := range res {
+		t := time.Unix(1500000000+rnd.Int63n(200000000), 0).UTC()
+		r := Reading{
+			SensorID: strconv.Itoa(rnd.Intn(1000)),
+			Time:     t.Unix(),
+			Date:     t,
+			Temp:     between(-20, 40),
+			Humidity: between(0, 110),
+			Voltage:  between(2.5, 4.2),
+			Firmware: "v" + strconv.Itoa(rnd.Intn(5)),
+			Fcnt:     rnd.Intn(100000),
+		}
+		if rnd.Intn(2) == 0 {
+			r.Light = between(0, 10000)
+			r.PM25 = between(0, 100)
+			r.PM10 = between(0, 100)
+		}
+		if rnd.Intn(4) > 0 {
+			r.Position = Position{Lat: between(50, 62), Lng: between(3, 8)}
+		}
+		r.Gateways = make([]Gateway, 1+rnd.Intn(4))
+		for j := range r.Gateways {
+			g := Gateway{
+				Name:          "gateway-" + strconv.Itoa(rnd.Intn(20)),
+				Position:      Position{Lat: between(50, 62), Lng: between(3, 8)},
+				RSSI:          float32(-rnd.Intn(130)),
+				LSNR:          float32(rnd.Intn(100)-60) / 4,
+				RadioSettings: RadioSettings{Frequency: between(863, 870), Sf: "SF" + strconv.Itoa(7+rnd.Intn(6)) + "BW125", Cr: "4/5CR"},
+			}
+			if r.Position != (Position{}) {
+				g.Distance = between(0.001, 20)
+			}
+			r.Gateways[j] = g
+		}
+		res[i] = r
+	}
+	return reflect.ValueOf(res)
+}
+
+func Test_renderRoundTrip(t *testing.T) {
+	roundTrip := func(readings randomReadings) bool {
+		var b bytes.Buffer
+		if err := Render(&b, readings); err != nil {
+			t.Errorf("error rendering: %v", err)
+			return false
+		}
+		got, err := Parse(&b)
+		if err != nil {
+			t.Errorf("error parsing: %v", err)
+			return false
+		}
+		if len(readings) == 0 {
+			return len(got) == 0
+		}
+		if diff := cmp.Diff([]Reading(readings), got); diff != "" {
+			t.Errorf("readings differ: %s", diff)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 200}); err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_renderTestdata(t *testing.T) {
+	for _, name := range []string{"testdata/example.html", "testdata/missing_data.html"} {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("failed to open testdata: %v", err)
+		}
+		want, err := ParsePage(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("error parsing %s: %v", name, err)
+		}
+
+		var b bytes.Buffer
+		if err := RenderPage(&b, want); err != nil {
+			t.Fatalf("error rendering %s: %v", name, err)
+		}
+		got, err := ParsePage(&b)
+		if err != nil {
+			t.Fatalf("error parsing rendered %s: %v", name, err)
+		}
+		if diff := cmp.Diff(want.Readings, got.Readings); diff != "" {
+			t.Errorf("%s: readings differ: %s", name, diff)
+		}
+		if diff := cmp.Diff(want.Datasets, got.Datasets); diff != "" {
+			t.Errorf("%s: datasets differ: %s", name, diff)
+		}
+		if want.Refresh != got.Refresh {
+			t.Errorf("%s: expected refresh %v, got %v", name, want.Refresh, got.Refresh)
+		}
+	}
+}
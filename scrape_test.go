@@ -29,7 +29,7 @@ func Test_parsing(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to open testdata: %v", err)
 	}
-	res, err := parse(r)
+	res, err := Parse(r)
 	if err != nil {
 		t.Fatalf("error parsing testdata: %v", err)
 	}
@@ -140,7 +140,7 @@ func Test_handleMissingData(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to open testdata: %v", err)
 	}
-	res, err := parse(f)
+	res, err := Parse(f)
 	if err != nil {
 		t.Fatalf("error parsing data: %v", err)
 	}
@@ -0,0 +1,75 @@
+package scrapejestadtest
+
+import (
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Fixture returns the readings of two sensors in Bergen, as they were
+// once shown on meetjestad.net. Sensor 372 has no position and its
+// gateways no distances.
+func Fixture() []scrapejestad.Reading {
+	florvaag := scrapejestad.Position{Lat: 60.431778, Lng: 5.231865}
+	fana := scrapejestad.Position{Lat: 60.394257, Lng: 5.311996}
+	bergen5 := scrapejestad.Position{Lat: 60.389248, Lng: 5.285356}
+	radio := func(f float32) scrapejestad.RadioSettings {
+		return scrapejestad.RadioSettings{Frequency: f, Sf: "SF9BW125", Cr: "4/5CR"}
+	}
+
+	return []scrapejestad.Reading{
+		{
+			SensorID: "242", Time: date("2019-12-05 21:19:33").Unix(), Date: date("2019-12-05 21:19:33"),
+			Temp: 6.875, Humidity: 107.25, Voltage: 3.37, Firmware: "v2",
+			Position: scrapejestad.Position{Lat: 60.4309, Lng: 5.23251}, Fcnt: 28357,
+			Gateways: []scrapejestad.Gateway{
+				{Name: "florvaag-1", Position: florvaag, Distance: 0.104, RSSI: -47, LSNR: 9.5, RadioSettings: radio(868.5)},
+				{Name: "eui-00f142122877fa05", Position: scrapejestad.Position{Lat: 60.41283, Lng: 5.327483}, Distance: 5.587, RSSI: -117, LSNR: -1, RadioSettings: radio(868.5)},
+			},
+		},
+		{
+			SensorID: "242", Time: date("2019-12-05 21:02:39").Unix(), Date: date("2019-12-05 21:02:39"),
+			Temp: 6.875, Humidity: 107.312, Voltage: 3.37, Firmware: "v2",
+			Position: scrapejestad.Position{Lat: 60.4309, Lng: 5.23251}, Fcnt: 28356,
+			Gateways: []scrapejestad.Gateway{
+				{Name: "florvaag-1", Position: florvaag, Distance: 0.104, RSSI: -45, LSNR: 12.25, RadioSettings: radio(867.7)},
+				{Name: "mjs-bergen-gateway-5", Position: bergen5, Distance: 5.465, RSSI: -113, LSNR: -10, RadioSettings: radio(867.7)},
+			},
+		},
+		{
+			SensorID: "372", Time: date("2019-02-15 21:22:58").Unix(), Date: date("2019-02-15 21:22:58"),
+			Temp: 22.6875, Humidity: 31.3125, Voltage: 3.3, Firmware: "v2", Fcnt: 1,
+			Gateways: []scrapejestad.Gateway{
+				{Name: "fana-bergen-gateway-01", Position: fana, RSSI: -103, LSNR: 13, RadioSettings: radio(868.1)},
+				{Name: "mjs-bergen-gateway-5", Position: bergen5, RSSI: -113, LSNR: 0.25, RadioSettings: radio(868.1)},
+			},
+		},
+		{
+			SensorID: "372", Time: date("2019-02-15 21:08:42").Unix(), Date: date("2019-02-15 21:08:42"),
+			Temp: 23.8125, Humidity: 32.8125, Voltage: 3.3, Firmware: "v2", Fcnt: 0,
+			Gateways: []scrapejestad.Gateway{
+				{Name: "fana-bergen-gateway-01", Position: fana, RSSI: -107, LSNR: 13.25, RadioSettings: radio(867.1)},
+				{Name: "mjs-bergen-gateway-5", Position: bergen5, RSSI: -111, LSNR: 5, RadioSettings: radio(867.1)},
+				{Name: "mjs-bergen-gateway-3", Position: scrapejestad.Position{Lat: 60.3905, Lng: 5.3443}, RSSI: -119, LSNR: -3.5, RadioSettings: radio(867.1)},
+			},
+		},
+	}
+}
+
+// FixtureDatasets returns a few of the datasets listed on meetjestad.net.
+func FixtureDatasets() []scrapejestad.Dataset {
+	bergen, _ := scrapejestad.ParseSensors("210-220,222-249,350-360,362-367,372,374-378,381-386,389-399")
+	return []scrapejestad.Dataset{
+		{Name: "Apeldoorn", Sensors: []int{124, 149, 150, 151, 152, 153, 154, 155, 156, 157, 158, 159, 160, 161, 171, 175}},
+		{Name: "Enschede", Sensors: []int{114, 129, 136, 146, 176}},
+		{Name: "Bergen", Sensors: bergen},
+	}
+}
+
+func date(s string) time.Time {
+	t, err := time.Parse(scrapejestad.TimestampLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
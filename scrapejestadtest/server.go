@@ -0,0 +1,277 @@
+// Package scrapejestadtest provides a fake meetjestad.net for tests.
+//
+// The fake serves sensors_recent.php in both the HTML and JSON formats
+// from fixture data, and honors the sensors, limit and gateways
+// parameters. Failures can be injected to exercise error handling:
+//
+//	srv := scrapejestadtest.NewServer(scrapejestadtest.Fixture())
+//	defer srv.Close()
+//	srv.FailNext(2, scrapejestadtest.Failure{Status: http.StatusServiceUnavailable})
+//	client := srv.Client()
+package scrapejestadtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Failure describes how a request should fail.
+type Failure struct {
+	// Latency delays the response.
+	Latency time.Duration
+	// Status responds with this status code and no body.
+	Status int
+	// Truncate sends only the first half of the body.
+	Truncate bool
+	// ChangeColumns adds a column to the readings table,
+	// as if meetjestad.net had changed its layout.
+	ChangeColumns bool
+}
+
+// Server is a fake sensors_recent.php.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	readings []scrapejestad.Reading
+	datasets []scrapejestad.Dataset
+	failures []Failure
+	always   *Failure
+	requests []*http.Request
+}
+
+// NewServer starts a fake serving readings.
+func NewServer(readings []scrapejestad.Reading) *Server {
+	s := &Server{datasets: FixtureDatasets()}
+	s.SetReadings(readings)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// PageURL returns the address of sensors_recent.php on the fake.
+func (s *Server) PageURL() string {
+	return s.Server.URL + "/data/sensors_recent.php"
+}
+
+// Client returns a client for the fake.
+func (s *Server) Client(opts ...scrapejestad.Option) *scrapejestad.Client {
+	return scrapejestad.NewClient(append([]scrapejestad.Option{scrapejestad.WithBaseURL(s.PageURL())}, opts...)...)
+}
+
+// SetReadings replaces the readings served.
+func (s *Server) SetReadings(readings []scrapejestad.Reading) {
+	sorted := make([]scrapejestad.Reading, len(readings))
+	copy(sorted, readings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings = sorted
+}
+
+// AddReadings adds readings to the ones served.
+func (s *Server) AddReadings(readings ...scrapejestad.Reading) {
+	s.mu.Lock()
+	current := s.readings
+	s.mu.Unlock()
+	s.SetReadings(append(append([]scrapejestad.Reading{}, current...), readings...))
+}
+
+// SetDatasets replaces the datasets listed on the page.
+func (s *Server) SetDatasets(datasets []scrapejestad.Dataset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.datasets = datasets
+}
+
+// FailNext makes the next n requests fail with f.
+func (s *Server) FailNext(n int, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, f)
+	}
+}
+
+// Fail makes every request fail with f until Reset is called.
+func (s *Server) Fail(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.always = &f
+}
+
+// Reset removes all injected failures.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+	s.always = nil
+}
+
+// Requests returns the requests served so far.
+func (s *Server) Requests() []*http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*http.Request{}, s.requests...)
+}
+
+func (s *Server) nextFailure() *Failure {
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		return &f
+	}
+	return s.always
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	failure := s.nextFailure()
+	readings := s.readings
+	datasets := s.datasets
+	s.mu.Unlock()
+
+	if r.URL.Path != "/data/sensors_recent.php" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var f Failure
+	if failure != nil {
+		f = *failure
+	}
+	if f.Latency > 0 {
+		select {
+		case <-time.After(f.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if f.Status != 0 {
+		w.WriteHeader(f.Status)
+		return
+	}
+
+	v := r.URL.Query()
+	readings, err := filter(readings, v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body bytes.Buffer
+	if v.Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(&body).Encode(toJSON(readings))
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = scrapejestad.RenderPage(&body, &scrapejestad.Page{Readings: readings, Refresh: time.Minute, Datasets: datasets})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := body.Bytes()
+	if f.ChangeColumns {
+		data = changeColumns(data)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if f.Truncate {
+		// The declared length is longer than the body, so the client sees an unexpected EOF.
+		data = data[:len(data)/2]
+	}
+	w.Write(data)
+}
+
+// filter applies the sensors, gateways and limit parameters.
+func filter(readings []scrapejestad.Reading, v url.Values) ([]scrapejestad.Reading, error) {
+	ids, err := scrapejestad.ParseSensors(v.Get("sensors"))
+	if err != nil {
+		return nil, err
+	}
+	sensors := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		sensors[strconv.Itoa(id)] = true
+	}
+
+	gateways := make(map[string]bool)
+	for _, g := range strings.Split(v.Get("gateways"), ",") {
+		if g != "" {
+			gateways[g] = true
+		}
+	}
+	showOthers := v.Get("show_other_gateways") == "1"
+
+	limit := 0
+	if l := v.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []scrapejestad.Reading
+	for _, r := range readings {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+		if len(sensors) > 0 && !sensors[r.SensorID] {
+			continue
+		}
+		if len(gateways) > 0 {
+			var heard []scrapejestad.Gateway
+			for _, g := range r.Gateways {
+				if gateways[g.Name] {
+					heard = append(heard, g)
+				}
+			}
+			if len(heard) == 0 {
+				continue
+			}
+			if !showOthers {
+				r.Gateways = heard
+			}
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+func toJSON(readings []scrapejestad.Reading) []scrapejestad.JsonReading {
+	res := make([]scrapejestad.JsonReading, len(readings))
+	for i, r := range readings {
+		id, _ := strconv.Atoi(r.SensorID)
+		fw, _ := strconv.Atoi(strings.TrimPrefix(r.Firmware, "v"))
+		res[i] = scrapejestad.JsonReading{
+			Row:             i + 1,
+			Id:              id,
+			Timestamp:       r.Date.UTC().Format(scrapejestad.TimestampLayout),
+			FirmwareVersion: fw,
+			Longitude:       r.Position.Lng,
+			Latitude:        r.Position.Lat,
+			Temperature:     r.Temp,
+			Humidity:        r.Humidity,
+			Supply:          r.Voltage,
+		}
+	}
+	return res
+}
+
+// changeColumns inserts a pressure column after the temperature column.
+func changeColumns(page []byte) []byte {
+	page = bytes.Replace(page, []byte("<th>Temp</th>"), []byte("<th>Temp</th>\n\t\t\t\t<th>Pressure</th>"), 1)
+	return bytes.Replace(page, []byte("°C</td>\n"), []byte("°C</td>\n  <td> 1013hPa</td>\n"), -1)
+}
@@ -0,0 +1,123 @@
+package scrapejestadtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_serverRoundTrip(t *testing.T) {
+	srv := NewServer(Fixture())
+	defer srv.Close()
+
+	res, err := srv.Client().Fetch(context.Background(), scrapejestad.Query{})
+	if err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+	if diff := cmp.Diff(Fixture(), res.Readings); diff != "" {
+		t.Errorf("readings differ: %s", diff)
+	}
+	if diff := cmp.Diff(FixtureDatasets(), res.Datasets); diff != "" {
+		t.Errorf("datasets differ: %s", diff)
+	}
+	if res.Stats.MessageCount != 4 || res.Stats.NodeCount != 2 {
+		t.Errorf("expected 4 messages from 2 nodes, got %d from %d", res.Stats.MessageCount, res.Stats.NodeCount)
+	}
+}
+
+func Test_serverJSON(t *testing.T) {
+	srv := NewServer(Fixture())
+	defer srv.Close()
+
+	res, err := srv.Client().Readings(context.Background(), scrapejestad.Query{Format: scrapejestad.FormatJSON})
+	if err != nil {
+		t.Fatalf("error fetching: %v", err)
+	}
+	if len(res) != 4 {
+		t.Fatalf("expected 4 readings, got %d", len(res))
+	}
+	if res[0].SensorID != "242" || res[0].Temp != 6.875 || len(res[0].Gateways) != 0 {
+		t.Errorf("unexpected first reading %+v", res[0])
+	}
+}
+
+func Test_serverFilters(t *testing.T) {
+	srv := NewServer(Fixture())
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		q        scrapejestad.Query
+		sensors  []string
+		gateways int
+	}{
+		{"sensors", scrapejestad.Query{Sensors: []int{372}}, []string{"372", "372"}, 5},
+		{"limit", scrapejestad.Query{Limit: 1}, []string{"242"}, 2},
+		{"gateways", scrapejestad.Query{Gateways: []string{"florvaag-1"}}, []string{"242", "242"}, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := srv.Client().Readings(context.Background(), test.q)
+			if err != nil {
+				t.Fatalf("error fetching: %v", err)
+			}
+			var sensors []string
+			gateways := 0
+			for _, r := range res {
+				sensors = append(sensors, r.SensorID)
+				gateways += len(r.Gateways)
+			}
+			if diff := cmp.Diff(test.sensors, sensors); diff != "" {
+				t.Errorf("sensors differ: %s", diff)
+			}
+			if gateways != test.gateways {
+				t.Errorf("expected %d gateways, got %d", test.gateways, gateways)
+			}
+		})
+	}
+}
+
+func Test_serverFailures(t *testing.T) {
+	srv := NewServer(Fixture())
+	defer srv.Close()
+	ctx := context.Background()
+
+	srv.FailNext(1, Failure{Status: http.StatusBadGateway})
+	_, err := srv.Client().Readings(ctx, scrapejestad.Query{})
+	var se *scrapejestad.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected status error 502, got %v", err)
+	}
+
+	srv.FailNext(1, Failure{Truncate: true})
+	if _, err := srv.Client().Readings(ctx, scrapejestad.Query{}); err == nil {
+		t.Errorf("expected error from truncated body")
+	}
+
+	srv.FailNext(1, Failure{Latency: 200 * time.Millisecond})
+	if _, err := srv.Client(scrapejestad.WithTimeout(20*time.Millisecond)).Readings(ctx, scrapejestad.Query{}); err == nil {
+		t.Errorf("expected timeout")
+	}
+
+	srv.Fail(Failure{ChangeColumns: true})
+	res, err := srv.Client().Readings(ctx, scrapejestad.Query{})
+	if err != nil {
+		t.Fatalf("error fetching changed page: %v", err)
+	}
+	if len(res) != 0 {
+		t.Errorf("expected rows with unknown columns to be skipped, got %d readings", len(res))
+	}
+
+	srv.Reset()
+	if _, err := srv.Client().Readings(ctx, scrapejestad.Query{}); err != nil {
+		t.Errorf("expected success after reset, got %v", err)
+	}
+	if n := len(srv.Requests()); n != 5 {
+		t.Errorf("expected 5 requests, got %d", n)
+	}
+}
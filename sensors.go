@@ -0,0 +1,101 @@
+package scrapejestad
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxSensors is the largest number of sensor IDs ParseSensors accepts.
+const MaxSensors = 10000
+
+// ParseSensors parses a list of sensor IDs and ranges as used by
+// meetjestad.net, like "1-14,16,18-55", into a sorted list of IDs
+// without duplicates. Lists of more than MaxSensors IDs are rejected.
+func ParseSensors(s string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		from, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid sensor '%s': %v", part, err)
+		}
+		to := from
+		if len(bounds) == 2 {
+			to, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid sensor range '%s': %v", part, err)
+			}
+		}
+		if from < 0 || to < from {
+			return nil, fmt.Errorf("invalid sensor range '%s'", part)
+		}
+		if to-from >= MaxSensors-len(ids) {
+			return nil, fmt.Errorf("too many sensors in '%s': at most %d are allowed", part, MaxSensors)
+		}
+		for id := from; id <= to; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return normalizeSensors(ids), nil
+}
+
+// FormatSensors returns the shortest list of IDs and ranges
+// describing the given sensors, like "1-14,16,18-55".
+func FormatSensors(ids []int) string {
+	ids = normalizeSensors(ids)
+	parts := make([]string, 0, len(ids))
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		switch {
+		case i == j:
+			parts = append(parts, strconv.Itoa(ids[i]))
+		default:
+			parts = append(parts, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func normalizeSensors(ids []int) []int {
+	if len(ids) == 0 {
+		return nil
+	}
+	res := make([]int, len(ids))
+	copy(res, ids)
+	sort.Ints(res)
+	n := 1
+	for i := 1; i < len(res); i++ {
+		if res[i] != res[n-1] {
+			res[n] = res[i]
+			n++
+		}
+	}
+	return res[:n]
+}
+
+// SortSensorIDs sorts sensor IDs numerically, followed by the
+// IDs that aren't numbers in lexical order, and returns them.
+func SortSensorIDs(ids []string) []string {
+	sort.Slice(ids, func(i, j int) bool {
+		a, aErr := strconv.Atoi(ids[i])
+		b, bErr := strconv.Atoi(ids[j])
+		switch {
+		case aErr == nil && bErr == nil && a != b:
+			return a < b
+		case (aErr == nil) != (bErr == nil):
+			return aErr == nil
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
@@ -0,0 +1,56 @@
+package scrapejestad
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_ParseSensors(t *testing.T) {
+	ids, err := ParseSensors("16, 1-3,2,18-20")
+	if err != nil {
+		t.Fatalf("error parsing sensors: %v", err)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3, 16, 18, 19, 20}, ids); diff != "" {
+		t.Errorf("not equal: %v", diff)
+	}
+
+	for _, s := range []string{"a", "3-1", "1-b", "0-9223372036854775807", "0-10000", "1-5000,10001-15001"} {
+		if _, err := ParseSensors(s); err == nil {
+			t.Errorf("expected error parsing '%s'", s)
+		}
+	}
+	if ids, err := ParseSensors("1-10000"); err != nil || len(ids) != MaxSensors {
+		t.Errorf("expected %d sensors, got %d: %v", MaxSensors, len(ids), err)
+	}
+}
+
+func Test_FormatSensors(t *testing.T) {
+	s := FormatSensors([]int{20, 1, 2, 3, 16, 18, 19, 2})
+	if s != "1-3,16,18-20" {
+		t.Errorf("expected '1-3,16,18-20', got '%s'", s)
+	}
+}
+
+func Test_SortSensorIDs(t *testing.T) {
+	want := []string{"2", "007", "7", "10", "242", "1000", "10a", "1a", "x"}
+	for _, in := range [][]string{
+		{"242", "x", "10", "1a", "2", "1000", "7", "10a", "007"},
+		{"1a", "10", "2", "x", "10a", "007", "7", "1000", "242"},
+	} {
+		if diff := cmp.Diff(want, SortSensorIDs(in)); diff != "" {
+			t.Errorf("not equal: %v", diff)
+		}
+	}
+}
+
+func Test_queryKey(t *testing.T) {
+	a := Query{Sensors: []int{3, 1, 2}, Gateways: []string{"b", "a"}, Limit: 10}
+	b := Query{Sensors: []int{1, 2, 3, 3}, Gateways: []string{"a", "b", "a"}, Limit: 10, Format: FormatHTML}
+	if a.Key() != b.Key() {
+		t.Errorf("expected equal keys, got '%s' and '%s'", a.Key(), b.Key())
+	}
+	if a.Key() == (Query{Sensors: []int{1, 2, 3}, Format: FormatJSON}).Key() {
+		t.Errorf("expected different keys for different formats")
+	}
+}
@@ -0,0 +1,286 @@
+// Package series turns irregular readings into regular time series,
+// so that every chart buckets and aggregates readings the same way.
+package series
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// MaxPoints is the largest number of buckets a series can have.
+const MaxPoints = 100000
+
+// Aggregation names a way to combine the values in a bucket.
+type Aggregation string
+
+// The aggregations of a Point.
+const (
+	Mean  Aggregation = "mean"
+	Min   Aggregation = "min"
+	Max   Aggregation = "max"
+	Last  Aggregation = "last"
+	Count Aggregation = "count"
+)
+
+// Aggregations lists the aggregations of a Point.
+var Aggregations = []Aggregation{Mean, Min, Max, Last, Count}
+
+// ParseAggregation returns the Aggregation with the given name.
+func ParseAggregation(s string) (Aggregation, error) {
+	for _, a := range Aggregations {
+		if string(a) == s {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unknown aggregation '%s'", s)
+}
+
+// Point is a bucket of a series.
+type Point struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+	Mean  float64   `json:"mean"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Last  float64   `json:"last"`
+	// Empty is set for buckets without readings.
+	Empty bool `json:"empty,omitempty"`
+	// Filled is set for empty buckets with interpolated values.
+	Filled bool `json:"filled,omitempty"`
+}
+
+// Value returns the aggregated value of the point.
+func (p Point) Value(a Aggregation) float64 {
+	switch a {
+	case Min:
+		return p.Min
+	case Max:
+		return p.Max
+	case Last:
+		return p.Last
+	case Count:
+		return float64(p.Count)
+	}
+	return p.Mean
+}
+
+func (p *Point) add(v float64) {
+	if p.Count == 0 || v < p.Min {
+		p.Min = v
+	}
+	if p.Count == 0 || v > p.Max {
+		p.Max = v
+	}
+	p.Mean += (v - p.Mean) / float64(p.Count+1)
+	p.Last = v
+	p.Count++
+}
+
+// Series is a measurement of a sensor at a regular interval.
+type Series struct {
+	SensorID string             `json:"sensor_id"`
+	Field    scrapejestad.Field `json:"field"`
+	Interval time.Duration      `json:"interval"`
+	Points   []Point            `json:"points"`
+}
+
+type options struct {
+	loc      *time.Location
+	from, to time.Time
+	fields   []scrapejestad.Field
+	maxGap   time.Duration
+}
+
+// Option configures Resample.
+type Option func(*options)
+
+// WithLocation sets the time zone buckets are aligned to. The default is UTC.
+func WithLocation(loc *time.Location) Option {
+	return func(o *options) {
+		o.loc = loc
+	}
+}
+
+// WithRange sets the time span of the series, from inclusive and to
+// exclusive. By default, the series span the readings.
+func WithRange(from, to time.Time) Option {
+	return func(o *options) {
+		o.from, o.to = from, to
+	}
+}
+
+// WithFields sets the measurements to resample. The default is all of them.
+func WithFields(fields ...scrapejestad.Field) Option {
+	return func(o *options) {
+		o.fields = fields
+	}
+}
+
+// WithMaxGap fills empty buckets by linear interpolation, if the gap
+// they make is at most d long.
+func WithMaxGap(d time.Duration) Option {
+	return func(o *options) {
+		o.maxGap = d
+	}
+}
+
+// Resample buckets readings by interval and aggregates them per sensor
+// and measurement, sorted by sensor ID. Buckets follow the wall clock
+// of the time zone: intervals shorter than a day start at midnight and
+// should divide a day, longer ones must be whole days. Measurements a
+// sensor has no values for are left out.
+func Resample(readings []scrapejestad.Reading, interval time.Duration, opts ...Option) ([]Series, error) {
+	o := options{loc: time.UTC, fields: scrapejestad.Fields}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if interval <= 0 || (interval > 24*time.Hour && interval%(24*time.Hour) != 0) {
+		return nil, fmt.Errorf("invalid interval %v", interval)
+	}
+	b := buckets{interval: interval, loc: o.loc}
+
+	sorted := make([]scrapejestad.Reading, len(readings))
+	copy(sorted, readings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+	from, to := o.from, o.to
+	if from.IsZero() && len(sorted) > 0 {
+		from = sorted[0].Date
+	}
+	if to.IsZero() && len(sorted) > 0 {
+		to = sorted[len(sorted)-1].Date.Add(time.Nanosecond)
+	}
+
+	// The points of every series share the same buckets.
+	var starts []time.Time
+	if !from.IsZero() {
+		for t := b.start(from); t.Before(to); t = b.next(t) {
+			if len(starts) == MaxPoints {
+				return nil, fmt.Errorf("more than %d buckets of %v from %v to %v", MaxPoints, interval, from, to)
+			}
+			starts = append(starts, t)
+		}
+	}
+
+	bySensor := make(map[string][]scrapejestad.Reading)
+	var ids []string
+	for _, r := range sorted {
+		if r.Date.Before(from) || !r.Date.Before(to) {
+			continue
+		}
+		if _, ok := bySensor[r.SensorID]; !ok {
+			ids = append(ids, r.SensorID)
+		}
+		bySensor[r.SensorID] = append(bySensor[r.SensorID], r)
+	}
+	scrapejestad.SortSensorIDs(ids)
+
+	var res []Series
+	for _, id := range ids {
+		for _, f := range o.fields {
+			s, ok := resample(bySensor[id], f, starts)
+			if !ok {
+				continue
+			}
+			s.SensorID = id
+			s.Interval = interval
+			if o.maxGap > 0 {
+				fill(s.Points, o.maxGap)
+			}
+			res = append(res, s)
+		}
+	}
+	return res, nil
+}
+
+// resample aggregates the values of a measurement in readings sorted
+// by time. It returns false if there are none.
+func resample(readings []scrapejestad.Reading, f scrapejestad.Field, starts []time.Time) (Series, bool) {
+	s := Series{Field: f, Points: make([]Point, len(starts))}
+	found := false
+	i := 0
+	for _, r := range readings {
+		v, ok := r.Value(f)
+		if !ok || (scrapejestad.OptionalField(f) && v == 0) {
+			continue
+		}
+		for i+1 < len(starts) && !r.Date.Before(starts[i+1]) {
+			i++
+		}
+		s.Points[i].add(v)
+		found = true
+	}
+	for i := range s.Points {
+		s.Points[i].Start = starts[i]
+		s.Points[i].Empty = s.Points[i].Count == 0
+	}
+	return s, found
+}
+
+// fill interpolates the values of runs of empty points between two
+// points with values, if the run is at most maxGap long.
+func fill(points []Point, maxGap time.Duration) {
+	prev := -1
+	for i, p := range points {
+		if p.Empty {
+			continue
+		}
+		if prev >= 0 && i-prev > 1 && points[i].Start.Sub(points[prev+1].Start) <= maxGap {
+			a, b := points[prev], points[i]
+			span := float64(b.Start.Sub(a.Start))
+			for j := prev + 1; j < i; j++ {
+				frac := float64(points[j].Start.Sub(a.Start)) / span
+				v := a.Mean + (b.Mean-a.Mean)*frac
+				points[j].Mean, points[j].Min, points[j].Max, points[j].Last = v, v, v, v
+				points[j].Filled = true
+			}
+		}
+		prev = i
+	}
+}
+
+// buckets aligns times to the wall clock of a time zone.
+type buckets struct {
+	interval time.Duration
+	loc      *time.Location
+}
+
+// start returns the start of the bucket holding t.
+func (b buckets) start(t time.Time) time.Time {
+	t = t.In(b.loc)
+	y, m, d := t.Date()
+	if b.interval >= 24*time.Hour {
+		// Count days from the epoch in the time zone, so multi-day
+		// buckets don't depend on the range.
+		days := int64(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+		n := int64(b.interval / (24 * time.Hour))
+		offset := days % n
+		if offset < 0 {
+			offset += n
+		}
+		return time.Date(y, m, d-int(offset), 0, 0, 0, 0, b.loc)
+	}
+	wall := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	wall -= wall % b.interval
+	return time.Date(y, m, d, 0, 0, 0, int(wall), b.loc)
+}
+
+// next returns the start of the bucket after the one starting at t.
+func (b buckets) next(t time.Time) time.Time {
+	if b.interval >= 24*time.Hour {
+		y, m, d := t.Date()
+		return time.Date(y, m, d+int(b.interval/(24*time.Hour)), 0, 0, 0, 0, b.loc)
+	}
+	// When clocks are turned back, the wall clock repeats itself and
+	// the same bucket can come up again.
+	for k := time.Duration(1); ; k++ {
+		if n := b.start(t.Add(k * b.interval)); n.After(t) {
+			return n
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package series
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+func at(h, m int) time.Time {
+	return time.Date(2019, 12, 5, h, m, 0, 0, time.UTC)
+}
+
+func readings() []scrapejestad.Reading {
+	return []scrapejestad.Reading{
+		{SensorID: "242", Date: at(13, 20), Temp: 8},
+		{SensorID: "372", Date: at(11, 0), Temp: 1},
+		{SensorID: "242", Date: at(10, 10), Temp: 4},
+		{SensorID: "242", Date: at(10, 50), Temp: 6},
+	}
+}
+
+func Test_resample(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want []Point
+	}{
+		{
+			"empty buckets",
+			nil,
+			[]Point{
+				{Start: at(10, 0), Count: 2, Mean: 5, Min: 4, Max: 6, Last: 6},
+				{Start: at(11, 0), Empty: true},
+				{Start: at(12, 0), Empty: true},
+				{Start: at(13, 0), Count: 1, Mean: 8, Min: 8, Max: 8, Last: 8},
+			},
+		},
+		{
+			"gap too long",
+			[]Option{WithMaxGap(time.Hour)},
+			[]Point{
+				{Start: at(10, 0), Count: 2, Mean: 5, Min: 4, Max: 6, Last: 6},
+				{Start: at(11, 0), Empty: true},
+				{Start: at(12, 0), Empty: true},
+				{Start: at(13, 0), Count: 1, Mean: 8, Min: 8, Max: 8, Last: 8},
+			},
+		},
+		{
+			"filled",
+			[]Option{WithMaxGap(2 * time.Hour)},
+			[]Point{
+				{Start: at(10, 0), Count: 2, Mean: 5, Min: 4, Max: 6, Last: 6},
+				{Start: at(11, 0), Mean: 6, Min: 6, Max: 6, Last: 6, Empty: true, Filled: true},
+				{Start: at(12, 0), Mean: 7, Min: 7, Max: 7, Last: 7, Empty: true, Filled: true},
+				{Start: at(13, 0), Count: 1, Mean: 8, Min: 8, Max: 8, Last: 8},
+			},
+		},
+		{
+			"range",
+			[]Option{WithRange(at(9, 30), at(11, 0))},
+			[]Point{
+				{Start: at(9, 0), Empty: true},
+				{Start: at(10, 0), Count: 2, Mean: 5, Min: 4, Max: 6, Last: 6},
+			},
+		},
+	}
+	for _, test := range tests {
+		res, err := Resample(readings(), time.Hour, append(test.opts, WithFields(scrapejestad.FieldTemperature))...)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if len(res) == 0 || res[0].SensorID != "242" || res[0].Field != scrapejestad.FieldTemperature {
+			t.Fatalf("%s: expected temperature of 242 first, got %+v", test.name, res)
+		}
+		if diff := cmp.Diff(test.want, res[0].Points); diff != "" {
+			t.Errorf("%s: points differ: %s", test.name, diff)
+		}
+	}
+}
+
+func Test_resampleSensorsAndFields(t *testing.T) {
+	res, err := Resample(readings(), 24*time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var got []string
+	for _, s := range res {
+		got = append(got, s.SensorID+" "+string(s.Field))
+	}
+	// Light and particulate matter are missing, but zero humidity and voltage are values.
+	want := []string{"242 temperature", "242 humidity", "242 voltage", "372 temperature", "372 humidity", "372 voltage"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("series differ: %s", diff)
+	}
+	if p := res[3].Points; len(p) != 1 || p[0].Count != 1 || !p[0].Start.Equal(at(0, 0)) {
+		t.Errorf("expected one daily point, got %+v", p)
+	}
+
+	for _, d := range []time.Duration{0, -time.Hour, 36 * time.Hour} {
+		if _, err := Resample(readings(), d); err == nil {
+			t.Errorf("expected error for interval %v", d)
+		}
+	}
+	if _, err := Resample(readings(), time.Second, WithRange(at(0, 0), at(0, 0).AddDate(0, 0, 2))); err == nil {
+		t.Errorf("expected error for too many buckets")
+	}
+}
+
+func Test_bucketsInTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+
+	b := buckets{interval: 24 * time.Hour, loc: loc}
+	// Half past midnight in Amsterdam is still the day before in UTC.
+	if got := b.start(time.Date(2019, 12, 5, 23, 30, 0, 0, time.UTC)); !got.Equal(time.Date(2019, 12, 6, 0, 0, 0, 0, loc)) {
+		t.Errorf("expected bucket of 6 December, got %v", got)
+	}
+	if got := b.next(time.Date(2019, 10, 27, 0, 0, 0, 0, loc)); got.Sub(time.Date(2019, 10, 27, 0, 0, 0, 0, loc)) != 25*time.Hour {
+		t.Errorf("expected the day clocks are turned back to be 25 hours, got %v", got)
+	}
+
+	// Hourly buckets keep following the wall clock when it changes.
+	for _, interval := range []time.Duration{5 * time.Minute, time.Hour} {
+		b := buckets{interval: interval, loc: loc}
+		for _, day := range []time.Time{time.Date(2019, 3, 31, 0, 0, 0, 0, loc), time.Date(2019, 10, 27, 0, 0, 0, 0, loc)} {
+			n := 0
+			for t0 := b.start(day); t0.Before(day.AddDate(0, 0, 1)); n++ {
+				next := b.next(t0)
+				if !next.After(t0) {
+					t.Fatalf("%v: bucket after %v is %v", interval, t0, next)
+				}
+				if next.In(loc).Minute()%int(interval/time.Minute) != 0 {
+					t.Errorf("%v: bucket at %v is not aligned", interval, next)
+				}
+				t0 = next
+			}
+			if max := int(25 * time.Hour / interval); n < 23*int(time.Hour/interval) || n > max {
+				t.Errorf("%v: unexpected %d buckets on %v", interval, n, day)
+			}
+		}
+	}
+}
+
+func Test_parseAggregation(t *testing.T) {
+	p := Point{Count: 3, Mean: 2, Min: 1, Max: 4, Last: 3}
+	for a, want := range map[string]float64{"mean": 2, "min": 1, "max": 4, "last": 3, "count": 3} {
+		agg, err := ParseAggregation(a)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := p.Value(agg); got != want {
+			t.Errorf("%s: expected %v, got %v", a, want, got)
+		}
+	}
+	if _, err := ParseAggregation("median"); err == nil {
+		t.Errorf("expected error for unknown aggregation")
+	}
+}
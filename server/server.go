@@ -0,0 +1,368 @@
+// Package server serves meetjestad.net data as a JSON HTTP API.
+//
+// The endpoints are:
+//
+//	GET /readings          readings, filtered by sensors, gateways and time range
+//	GET /readings/latest   the latest reading of every sensor
+//	GET /gateways          statistics per gateway
+//	GET /datasets          the named groups of sensors
+//	GET /stats             message and node counts
+//	GET /series            readings resampled to a regular interval
+//
+// All endpoints take the query parameters sensors (like "242,350-360"),
+// gateways (comma separated) and limit, which are passed on to
+// meetjestad.net. /readings and /series also take from and to as RFC 3339
+// times or Unix timestamps. /series takes interval (like "5m" or "24h"),
+// tz (like "Europe/Amsterdam"), fields (comma separated) and max_gap, the
+// longest gap to fill by interpolation. List endpoints are paginated with
+// page and per_page.
+//
+// Responses carry an ETag and a Warning header when stale data is served.
+//
+// A Stream can be mounted with Handle to push new readings as
+// Server-Sent Events.
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/series"
+)
+
+// Pagination defaults.
+const (
+	DefaultPerPage = 100
+	MaxPerPage     = 1000
+)
+
+// Server is an http.Handler serving the JSON API.
+type Server struct {
+	fetcher scrapejestad.Fetcher
+	mux     *http.ServeMux
+	timeout time.Duration
+}
+
+// New returns a server fetching data with f. Passing a Client
+// configured with a Cache serves repeated requests from the cache.
+func New(f scrapejestad.Fetcher) *Server {
+	s := &Server{
+		fetcher: f,
+		mux:     http.NewServeMux(),
+		timeout: 30 * time.Second,
+	}
+	s.mux.HandleFunc("/readings", s.get(s.readings))
+	s.mux.HandleFunc("/readings/latest", s.get(s.latest))
+	s.mux.HandleFunc("/gateways", s.get(s.gateways))
+	s.mux.HandleFunc("/datasets", s.get(s.datasets))
+	s.mux.HandleFunc("/stats", s.get(s.stats))
+	s.mux.HandleFunc("/series", s.get(s.series))
+	return s
+}
+
+// Handle registers an extra handler on the server, like a stream of readings.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// ServeHTTP serves a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// envelope is the body of every successful response.
+type envelope struct {
+	Data    interface{} `json:"data"`
+	Page    int         `json:"page,omitempty"`
+	PerPage int         `json:"per_page,omitempty"`
+	Total   int         `json:"total,omitempty"`
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// endpoint returns the data to serve for a request, based on the fetched result.
+// Errors are reported as bad requests.
+type endpoint func(r *http.Request, res *scrapejestad.Result) (*envelope, error)
+
+func (s *Server) get(e endpoint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		q, err := parseQuery(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+		res, err := s.fetcher.Fetch(ctx, q)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		env, err := e(r, res)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		body, err := json.Marshal(env)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		h := w.Header()
+		h.Set("ETag", etag)
+		h.Set("Cache-Control", "no-cache")
+		if !res.FetchedAt.IsZero() {
+			h.Set("X-Fetched-At", res.FetchedAt.UTC().Format(time.RFC3339))
+		}
+		if res.Stale {
+			h.Set("Warning", `110 - "Response is Stale"`)
+		}
+		if matchETag(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		h.Set("Content-Type", "application/json")
+		h.Set("Content-Length", strconv.Itoa(len(body)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(body)
+	}
+}
+
+func matchETag(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: msg})
+}
+
+func parseQuery(v url.Values) (scrapejestad.Query, error) {
+	var q scrapejestad.Query
+	sensors, err := scrapejestad.ParseSensors(v.Get("sensors"))
+	if err != nil {
+		return q, err
+	}
+	q.Sensors = sensors
+	if g := v.Get("gateways"); g != "" {
+		q.Gateways = strings.Split(g, ",")
+	}
+	if l := v.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit '%s'", l)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// parseTime parses an RFC 3339 time or a Unix timestamp.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("invalid time '%s', use RFC 3339 or a Unix timestamp", s)
+	}
+	return t, nil
+}
+
+// paginate returns the envelope for one page of a list of n items,
+// using slice to pick the items of the page.
+func paginate(r *http.Request, n int, slice func(from, to int) interface{}) (*envelope, error) {
+	v := r.URL.Query()
+	page, perPage := 1, DefaultPerPage
+	if p := v.Get("page"); p != "" {
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 1 {
+			return nil, fmt.Errorf("invalid page '%s'", p)
+		}
+		page = i
+	}
+	if p := v.Get("per_page"); p != "" {
+		i, err := strconv.Atoi(p)
+		if err != nil || i < 1 || i > MaxPerPage {
+			return nil, fmt.Errorf("invalid per_page '%s', use 1 to %d", p, MaxPerPage)
+		}
+		perPage = i
+	}
+
+	// Pages past the end are empty; checking first keeps large pages from overflowing.
+	from := n
+	if page-1 <= n/perPage {
+		from = (page - 1) * perPage
+	}
+	if from > n {
+		from = n
+	}
+	to := from + perPage
+	if to > n {
+		to = n
+	}
+	return &envelope{Data: slice(from, to), Page: page, PerPage: perPage, Total: n}, nil
+}
+
+func (s *Server) readings(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	v := r.URL.Query()
+	from, err := parseTime(v.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTime(v.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+
+	readings := make([]scrapejestad.Reading, 0, len(res.Readings))
+	for _, rd := range res.Readings {
+		if !from.IsZero() && rd.Date.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !rd.Date.Before(to) {
+			continue
+		}
+		readings = append(readings, rd)
+	}
+	return paginate(r, len(readings), func(from, to int) interface{} {
+		return readings[from:to]
+	})
+}
+
+func (s *Server) latest(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	latest := make(map[string]scrapejestad.Reading)
+	for _, rd := range res.Readings {
+		if l, ok := latest[rd.SensorID]; !ok || rd.Date.After(l.Date) {
+			latest[rd.SensorID] = rd
+		}
+	}
+	ids := make([]string, 0, len(latest))
+	for id := range latest {
+		ids = append(ids, id)
+	}
+	readings := make([]scrapejestad.Reading, 0, len(latest))
+	for _, id := range scrapejestad.SortSensorIDs(ids) {
+		readings = append(readings, latest[id])
+	}
+	return paginate(r, len(readings), func(from, to int) interface{} {
+		return readings[from:to]
+	})
+}
+
+func (s *Server) gateways(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	g := res.Stats.Gateways
+	if g == nil {
+		g = []scrapejestad.GatewayStats{}
+	}
+	return paginate(r, len(g), func(from, to int) interface{} {
+		return g[from:to]
+	})
+}
+
+func (s *Server) datasets(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	d := res.Datasets
+	if d == nil {
+		d = []scrapejestad.Dataset{}
+	}
+	return paginate(r, len(d), func(from, to int) interface{} {
+		return d[from:to]
+	})
+}
+
+func (s *Server) stats(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	return &envelope{Data: res.Stats}, nil
+}
+
+func (s *Server) series(r *http.Request, res *scrapejestad.Result) (*envelope, error) {
+	v := r.URL.Query()
+	interval := time.Hour
+	if i := v.Get("interval"); i != "" {
+		d, err := time.ParseDuration(i)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval '%s'", i)
+		}
+		interval = d
+	}
+	var opts []series.Option
+	if tz := v.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone '%s'", tz)
+		}
+		opts = append(opts, series.WithLocation(loc))
+	}
+	if f := v.Get("fields"); f != "" {
+		var fields []scrapejestad.Field
+		for _, name := range strings.Split(f, ",") {
+			field, err := scrapejestad.ParseField(name)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		opts = append(opts, series.WithFields(fields...))
+	}
+	if g := v.Get("max_gap"); g != "" {
+		d, err := time.ParseDuration(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_gap '%s'", g)
+		}
+		opts = append(opts, series.WithMaxGap(d))
+	}
+	from, err := parseTime(v.Get("from"))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseTime(v.Get("to"))
+	if err != nil {
+		return nil, err
+	}
+	if !from.IsZero() || !to.IsZero() {
+		opts = append(opts, series.WithRange(from, to))
+	}
+
+	ss, err := series.Resample(res.Readings, interval, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ss == nil {
+		ss = []series.Series{}
+	}
+	return paginate(r, len(ss), func(from, to int) interface{} {
+		return ss[from:to]
+	})
+}
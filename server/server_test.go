@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/series"
+)
+
+func newServer(t *testing.T) (*Server, *int) {
+	page, err := ioutil.ReadFile("../testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	status := http.StatusOK
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write(page)
+	}))
+	t.Cleanup(upstream.Close)
+	return New(scrapejestad.NewClient(scrapejestad.WithBaseURL(upstream.URL))), &status
+}
+
+type response struct {
+	Data    json.RawMessage `json:"data"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	Total   int             `json:"total"`
+	Error   string          `json:"error"`
+}
+
+func get(t *testing.T, s *Server, target string, header http.Header) (*httptest.ResponseRecorder, response) {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	var res response
+	if w.Code != http.StatusNotModified {
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: error decoding body: %v", target, err)
+		}
+	}
+	return w, res
+}
+
+func Test_readings(t *testing.T) {
+	s, _ := newServer(t)
+
+	w, res := get(t, s, "/readings?sensors=242&per_page=1&page=2", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, res.Error)
+	}
+	var readings []scrapejestad.Reading
+	if err := json.Unmarshal(res.Data, &readings); err != nil {
+		t.Fatalf("error decoding readings: %v", err)
+	}
+	if res.Total != 2 || res.Page != 2 || len(readings) != 1 || readings[0].Fcnt != 28356 {
+		t.Errorf("expected second of 2 readings, got total=%d page=%d %v", res.Total, res.Page, readings)
+	}
+
+	_, res = get(t, s, "/readings?from=2019-12-05T21:10:00Z&to=1575580800", nil)
+	readings = nil
+	json.Unmarshal(res.Data, &readings)
+	if len(readings) != 1 || readings[0].Fcnt != 28357 {
+		t.Errorf("expected only the reading after 21:10, got %v", readings)
+	}
+
+	for _, target := range []string{"/readings?page=5", "/readings?page=9223372036854775807&per_page=2"} {
+		_, res = get(t, s, target, nil)
+		if string(res.Data) != "[]" {
+			t.Errorf("%s: expected empty page, got %s", target, res.Data)
+		}
+	}
+}
+
+func Test_etag(t *testing.T) {
+	s, _ := newServer(t)
+
+	w, _ := get(t, s, "/readings/latest", nil)
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected ETag")
+	}
+	w, _ = get(t, s, "/readings/latest", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", w.Code)
+	}
+	w, _ = get(t, s, "/readings/latest?per_page=5", http.Header{"If-None-Match": {etag}})
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200 for different data, got %d", w.Code)
+	}
+}
+
+func Test_endpoints(t *testing.T) {
+	s, _ := newServer(t)
+
+	_, res := get(t, s, "/readings/latest", nil)
+	var readings []scrapejestad.Reading
+	json.Unmarshal(res.Data, &readings)
+	if len(readings) != 1 || readings[0].Fcnt != 28357 {
+		t.Errorf("expected latest reading of sensor 242, got %v", readings)
+	}
+
+	_, res = get(t, s, "/gateways?per_page=2", nil)
+	var gateways []scrapejestad.GatewayStats
+	json.Unmarshal(res.Data, &gateways)
+	if res.Total != 9 || len(gateways) != 2 || gateways[0].Name != "eui-00f142122877fa05" {
+		t.Errorf("expected first 2 of 9 gateways, got %d of %d", len(gateways), res.Total)
+	}
+
+	_, res = get(t, s, "/datasets", nil)
+	var datasets []scrapejestad.Dataset
+	json.Unmarshal(res.Data, &datasets)
+	if len(datasets) != 7 || datasets[6].Name != "Bergen" {
+		t.Errorf("expected 7 datasets, got %v", datasets)
+	}
+
+	_, res = get(t, s, "/series?fields=temperature&interval=15m&tz=Europe/Amsterdam", nil)
+	var ss []series.Series
+	json.Unmarshal(res.Data, &ss)
+	if len(ss) != 1 || ss[0].SensorID != "242" || ss[0].Field != scrapejestad.FieldTemperature {
+		t.Fatalf("expected temperature of sensor 242, got %+v", ss)
+	}
+	count := 0
+	for _, p := range ss[0].Points {
+		count += p.Count
+	}
+	if count != 2 {
+		t.Errorf("expected both readings in the series, got %d", count)
+	}
+
+	_, res = get(t, s, "/stats", nil)
+	var stats scrapejestad.Stats
+	json.Unmarshal(res.Data, &stats)
+	if stats.MessageCount != 20 || stats.NodeCount != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func Test_errors(t *testing.T) {
+	s, status := newServer(t)
+
+	for _, target := range []string{"/readings?sensors=x", "/readings?limit=-1", "/readings?from=yesterday", "/readings?per_page=0", "/gateways?page=a", "/series?interval=x", "/series?tz=Mars", "/series?fields=pressure", "/series?interval=1ns", "/readings?sensors=0-999999999999"} {
+		if w, _ := get(t, s, target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/readings", nil)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405, got %d", w.Code)
+	}
+
+	*status = http.StatusInternalServerError
+	if w, _ := get(t, s, "/readings", nil); w.Code != http.StatusBadGateway {
+		t.Errorf("expected status 502, got %d", w.Code)
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Stream sends readings to clients as Server-Sent Events.
+//
+// Clients can limit the stream with the query parameters sensors
+// (like "242,350-360") and dataset (the name of a dataset). Events
+// carry increasing IDs, and clients reconnecting with a Last-Event-ID
+// header get the events they missed from a bounded replay buffer.
+type Stream struct {
+	bufferSize int
+	heartbeat  time.Duration
+	datasets   scrapejestad.Fetcher
+
+	mu      sync.Mutex
+	lastID  uint64
+	buffer  []streamEvent
+	clients map[*subscriber]bool
+}
+
+type streamEvent struct {
+	id      uint64
+	reading scrapejestad.Reading
+	data    []byte
+}
+
+type subscriber struct {
+	events chan streamEvent
+	filter func(scrapejestad.Reading) bool
+	// dropped is closed when the subscriber fell too far behind.
+	dropped chan struct{}
+}
+
+// StreamOption configures a Stream.
+type StreamOption func(*Stream)
+
+// StreamBufferSize sets how many events are kept for clients that reconnect.
+func StreamBufferSize(n int) StreamOption {
+	return func(s *Stream) {
+		s.bufferSize = n
+	}
+}
+
+// StreamHeartbeat sets how often a comment is sent to keep idle connections open.
+// Intervals that aren't positive are ignored.
+func StreamHeartbeat(d time.Duration) StreamOption {
+	return func(s *Stream) {
+		if d > 0 {
+			s.heartbeat = d
+		}
+	}
+}
+
+// StreamDatasets sets the fetcher used to look up datasets by name.
+// Without it the dataset parameter is rejected.
+func StreamDatasets(f scrapejestad.Fetcher) StreamOption {
+	return func(s *Stream) {
+		s.datasets = f
+	}
+}
+
+// NewStream returns a stream without clients.
+func NewStream(opts ...StreamOption) *Stream {
+	s := &Stream{
+		bufferSize: 1000,
+		heartbeat:  15 * time.Second,
+		clients:    make(map[*subscriber]bool),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// Run publishes the readings reported by w until ctx is cancelled.
+func (s *Stream) Run(ctx context.Context, w *scrapejestad.Watcher) error {
+	return w.Run(ctx, s.Publish)
+}
+
+// Publish sends a reading to all clients whose filter matches it.
+func (s *Stream) Publish(r scrapejestad.Reading) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	e := streamEvent{id: s.lastID, reading: r, data: data}
+	s.buffer = append(s.buffer, e)
+	if len(s.buffer) > s.bufferSize {
+		s.buffer = s.buffer[len(s.buffer)-s.bufferSize:]
+	}
+	for c := range s.clients {
+		if !c.filter(r) {
+			continue
+		}
+		select {
+		case c.events <- e:
+		default:
+			// The client cannot keep up. It can reconnect and replay from the buffer.
+			delete(s.clients, c)
+			close(c.dropped)
+		}
+	}
+}
+
+// ServeHTTP streams events to a client until it disconnects.
+func (s *Stream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	filter, err := s.filter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var lastID uint64
+	if v := lastEventID(r); v != "" {
+		lastID, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid Last-Event-ID '%s'", v))
+			return
+		}
+	}
+
+	c := &subscriber{
+		events:  make(chan streamEvent, 64),
+		filter:  filter,
+		dropped: make(chan struct{}),
+	}
+	replay := s.subscribe(c, lastID)
+	defer s.unsubscribe(c)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	for _, e := range replay {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c.dropped:
+			return
+		case e := <-c.events:
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribe adds a client and returns the buffered events after lastID
+// that it should be sent first. A lastID of zero replays nothing.
+func (s *Stream) subscribe(c *subscriber, lastID uint64) []streamEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c] = true
+	if lastID == 0 {
+		return nil
+	}
+	var replay []streamEvent
+	for _, e := range s.buffer {
+		if e.id > lastID && c.filter(e.reading) {
+			replay = append(replay, e)
+		}
+	}
+	return replay
+}
+
+func (s *Stream) unsubscribe(c *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// filter returns the function selecting the readings a client asked for.
+func (s *Stream) filter(r *http.Request) (func(scrapejestad.Reading) bool, error) {
+	v := r.URL.Query()
+	ids, err := scrapejestad.ParseSensors(v.Get("sensors"))
+	if err != nil {
+		return nil, err
+	}
+	if name := v.Get("dataset"); name != "" {
+		if s.datasets == nil {
+			return nil, fmt.Errorf("datasets are not available")
+		}
+		res, err := s.datasets.Fetch(r.Context(), scrapejestad.Query{Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, d := range res.Datasets {
+			if d.Name == name {
+				ids = append(ids, d.Sensors...)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown dataset '%s'", name)
+		}
+	}
+	if len(ids) == 0 {
+		return func(scrapejestad.Reading) bool { return true }, nil
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[strconv.Itoa(id)] = true
+	}
+	return func(r scrapejestad.Reading) bool { return set[r.SensorID] }, nil
+}
+
+// lastEventID returns the ID of the last event a reconnecting client saw.
+// Clients that cannot set headers may pass it as the lastEventId parameter.
+func lastEventID(r *http.Request) string {
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+func writeEvent(w http.ResponseWriter, e streamEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: reading\ndata: %s\n\n", e.id, e.data)
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+type sseEvent struct {
+	id      string
+	comment string
+	reading scrapejestad.Reading
+}
+
+// sseClient reads events from a stream in the background.
+type sseClient struct {
+	events chan sseEvent
+	cancel func()
+}
+
+func connect(t *testing.T, url string, lastID string) *sseClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req = req.WithContext(ctx)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatalf("error connecting: %v", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		cancel()
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got '%s'", ct)
+	}
+
+	c := &sseClient{events: make(chan sseEvent, 100), cancel: cancel}
+	s := bufio.NewScanner(res.Body)
+	// Wait for the retry field, which is sent once the client is subscribed.
+	for s.Scan() && !strings.HasPrefix(s.Text(), "retry:") {
+	}
+	go func() {
+		defer res.Body.Close()
+		var e sseEvent
+		for s.Scan() {
+			line := s.Text()
+			switch {
+			case line == "":
+				if e.id != "" || e.comment != "" {
+					c.events <- e
+				}
+				e = sseEvent{}
+			case strings.HasPrefix(line, ": "):
+				e.comment = line[2:]
+			case strings.HasPrefix(line, "id: "):
+				e.id = line[4:]
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(line[6:]), &e.reading)
+			}
+		}
+	}()
+	return c
+}
+
+func (c *sseClient) next(t *testing.T) sseEvent {
+	select {
+	case e := <-c.events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("no event received")
+		return sseEvent{}
+	}
+}
+
+func (c *sseClient) nextReading(t *testing.T) sseEvent {
+	for {
+		if e := c.next(t); e.comment == "" {
+			return e
+		}
+	}
+}
+
+type datasetFetcher struct{}
+
+func (datasetFetcher) Fetch(context.Context, scrapejestad.Query) (*scrapejestad.Result, error) {
+	return &scrapejestad.Result{Page: scrapejestad.Page{
+		Datasets: []scrapejestad.Dataset{{Name: "Bergen", Sensors: []int{242, 243}}},
+	}}, nil
+}
+
+func Test_streamFilterAndResume(t *testing.T) {
+	stream := NewStream(StreamBufferSize(3), StreamDatasets(datasetFetcher{}), StreamHeartbeat(time.Hour))
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+
+	all := connect(t, srv.URL, "")
+	defer all.cancel()
+	bergen := connect(t, srv.URL+"?dataset=Bergen", "")
+	defer bergen.cancel()
+	one := connect(t, srv.URL+"?sensors=243", "")
+	defer one.cancel()
+
+	for _, id := range []string{"242", "100", "243", "242"} {
+		stream.Publish(scrapejestad.Reading{SensorID: id})
+	}
+
+	for i, want := range []string{"242", "100", "243", "242"} {
+		if e := all.nextReading(t); e.reading.SensorID != want {
+			t.Errorf("%d: expected sensor %s, got %s", i, want, e.reading.SensorID)
+		}
+	}
+	for i, want := range []string{"1", "3", "4"} {
+		if e := bergen.nextReading(t); e.id != want {
+			t.Errorf("%d: expected event %s, got %s", i, want, e.id)
+		}
+	}
+	if e := one.nextReading(t); e.id != "3" || e.reading.SensorID != "243" {
+		t.Errorf("expected event 3 from sensor 243, got %s from %s", e.id, e.reading.SensorID)
+	}
+
+	// The buffer holds the last 3 events, so resuming after 1 replays 2 to 4.
+	resumed := connect(t, srv.URL, "1")
+	defer resumed.cancel()
+	for i, want := range []string{"2", "3", "4"} {
+		if e := resumed.nextReading(t); e.id != want {
+			t.Errorf("%d: expected replayed event %s, got %s", i, want, e.id)
+		}
+	}
+	stream.Publish(scrapejestad.Reading{SensorID: "242"})
+	if e := resumed.nextReading(t); e.id != "5" {
+		t.Errorf("expected live event 5, got %s", e.id)
+	}
+}
+
+func Test_streamHeartbeat(t *testing.T) {
+	stream := NewStream(StreamHeartbeat(10 * time.Millisecond))
+	srv := httptest.NewServer(stream)
+	defer srv.Close()
+
+	c := connect(t, srv.URL, "")
+	defer c.cancel()
+	if e := c.next(t); e.comment != "heartbeat" {
+		t.Errorf("expected heartbeat, got %+v", e)
+	}
+}
+
+func Test_streamInvalidHeartbeat(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Second} {
+		stream := NewStream(StreamHeartbeat(d))
+		srv := httptest.NewServer(stream)
+		c := connect(t, srv.URL, "")
+		stream.Publish(scrapejestad.Reading{SensorID: "242"})
+		if e := c.nextReading(t); e.id == "" {
+			t.Errorf("expected a reading with a heartbeat of %v, got %+v", d, e)
+		}
+		c.cancel()
+		srv.Close()
+	}
+}
+
+func Test_streamBadRequests(t *testing.T) {
+	stream := NewStream()
+	for _, target := range []string{"/?sensors=x", "/?sensors=0-999999999999", "/?dataset=Bergen", "/?lastEventId=abc"} {
+		w := httptest.NewRecorder()
+		stream.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, w.Code)
+		}
+	}
+}
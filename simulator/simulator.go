@@ -0,0 +1,282 @@
+// Package simulator generates readings from a network of virtual sensors.
+//
+// The sensors follow a daily temperature cycle with the humidity moving
+// the other way, run down their batteries, lose some of their messages
+// and are heard by gateways depending on how far away they are. The
+// readings can be served by scrapejestadtest or fed to the exporters.
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// channels are the frequencies in MHz used by sensors in the EU.
+var channels = []float32{867.1, 867.3, 867.5, 867.7, 867.9, 868.1, 868.3, 868.5}
+
+// DefaultGateways are placed around the center of Bergen.
+var DefaultGateways = []scrapejestad.Gateway{
+	{Name: "florvaag-1", Position: scrapejestad.Position{Lat: 60.431778, Lng: 5.231865}},
+	{Name: "fana-bergen-gateway-01", Position: scrapejestad.Position{Lat: 60.394257, Lng: 5.311996}},
+	{Name: "mjs-bergen-gateway-1", Position: scrapejestad.Position{Lat: 60.362316, Lng: 5.340381}},
+	{Name: "mjs-bergen-gateway-2", Position: scrapejestad.Position{Lat: 60.408367, Lng: 5.3243}},
+	{Name: "mjs-bergen-gateway-5", Position: scrapejestad.Position{Lat: 60.389248, Lng: 5.285356}},
+}
+
+// Simulator produces readings for a number of virtual sensors.
+type Simulator struct {
+	rand     *rand.Rand
+	now      time.Time
+	interval time.Duration
+	loss     float64
+	drain    float64
+	radius   float64
+	firstID  int
+	gateways []scrapejestad.Gateway
+	sensors  []*sensor
+}
+
+type sensor struct {
+	id       string
+	position scrapejestad.Position
+	// mean is the daily mean temperature and dewPoint the dew point,
+	// which sets the humidity.
+	mean     float64
+	dewPoint float64
+	charge   float64
+	fcnt     int
+	// next is when the sensor sends its next message.
+	next time.Time
+}
+
+// Option configures a Simulator.
+type Option func(*Simulator)
+
+// WithSeed sets the seed of the random numbers, so that runs can be repeated.
+func WithSeed(seed int64) Option {
+	return func(s *Simulator) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithStart sets the time of the first readings.
+func WithStart(t time.Time) Option {
+	return func(s *Simulator) {
+		s.now = t
+	}
+}
+
+// WithInterval sets how often sensors send a message.
+// Intervals that aren't positive are ignored.
+func WithInterval(d time.Duration) Option {
+	return func(s *Simulator) {
+		if d > 0 {
+			s.interval = d
+		}
+	}
+}
+
+// WithLoss sets the share of messages, between 0 and 1, that are lost.
+// Lost messages still increment the frame counter.
+func WithLoss(p float64) Option {
+	return func(s *Simulator) {
+		s.loss = p
+	}
+}
+
+// WithDrain sets how many volts the battery loses per message.
+func WithDrain(volts float64) Option {
+	return func(s *Simulator) {
+		s.drain = volts
+	}
+}
+
+// WithGateways sets the gateways that can hear the sensors.
+// Sensors are placed within radius km of the first gateway.
+func WithGateways(g []scrapejestad.Gateway, radius float64) Option {
+	return func(s *Simulator) {
+		s.gateways = g
+		s.radius = radius
+	}
+}
+
+// WithFirstSensorID sets the ID of the first sensor. The others follow it.
+func WithFirstSensorID(id int) Option {
+	return func(s *Simulator) {
+		s.firstID = id
+	}
+}
+
+// New returns a simulator of n sensors.
+func New(n int, opts ...Option) *Simulator {
+	s := &Simulator{
+		rand:     rand.New(rand.NewSource(1)),
+		now:      time.Now().UTC().Truncate(time.Second),
+		interval: 15 * time.Minute,
+		drain:    0.0001,
+		radius:   8,
+		firstID:  1,
+		gateways: DefaultGateways,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+
+	for i := 0; i < n; i++ {
+		s.sensors = append(s.sensors, s.newSensor(s.firstID+i))
+	}
+	return s
+}
+
+func (s *Simulator) newSensor(id int) *sensor {
+	sn := &sensor{
+		id:     strconv.Itoa(id),
+		mean:   8 + s.rand.NormFloat64()*2,
+		charge: 3.9 + s.rand.Float64()*0.3,
+		fcnt:   s.rand.Intn(1000),
+		next:   s.now.Add(time.Duration(s.rand.Int63n(int64(s.interval)))),
+	}
+	sn.dewPoint = sn.mean - 3 - s.rand.Float64()*3
+	// Some sensors have no GPS fix.
+	if s.rand.Float64() < 0.9 && len(s.gateways) > 0 {
+		sn.position = offset(s.gateways[0].Position, s.rand.Float64()*s.radius, s.rand.Float64()*2*math.Pi)
+	}
+	return sn
+}
+
+// Now returns the time of the simulation.
+func (s *Simulator) Now() time.Time {
+	return s.now
+}
+
+// Advance moves the simulation forward by d and returns the readings
+// received in that time, newest first like on meetjestad.net.
+func (s *Simulator) Advance(d time.Duration) []scrapejestad.Reading {
+	end := s.now.Add(d)
+	var res []scrapejestad.Reading
+	for _, sn := range s.sensors {
+		for !sn.next.After(end) {
+			if r, ok := s.send(sn); ok {
+				res = append(res, r)
+			}
+			jitter := time.Duration(s.rand.NormFloat64() * float64(s.interval) / 50)
+			sn.next = sn.next.Add(s.interval + jitter)
+		}
+	}
+	s.now = end
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Date.After(res[j].Date)
+	})
+	return res
+}
+
+// send makes a sensor send a message, and reports whether it was received.
+func (s *Simulator) send(sn *sensor) (scrapejestad.Reading, bool) {
+	// A sensor stops sending when its battery is empty.
+	if sn.charge < 2.8 {
+		return scrapejestad.Reading{}, false
+	}
+	fcnt := sn.fcnt
+	sn.fcnt++
+	sn.charge -= s.drain
+
+	if s.rand.Float64() < s.loss {
+		return scrapejestad.Reading{}, false
+	}
+	freq := channels[s.rand.Intn(len(channels))]
+	var gateways []scrapejestad.Gateway
+	for _, g := range s.gateways {
+		if rg, ok := s.receive(sn, g, freq); ok {
+			gateways = append(gateways, rg)
+		}
+	}
+	if len(gateways) == 0 {
+		return scrapejestad.Reading{}, false
+	}
+
+	t := sn.next.UTC().Truncate(time.Second)
+	temp := s.temperature(sn, t)
+	return scrapejestad.Reading{
+		SensorID: sn.id,
+		Time:     t.Unix(),
+		Date:     t,
+		Temp:     float32(quantize(temp, 1.0/16)),
+		Humidity: float32(quantize(humidity(temp, sn.dewPoint+s.rand.NormFloat64()*0.5), 1.0/16)),
+		Voltage:  float32(quantize(sn.charge-0.005*math.Max(0, 10-temp), 0.01)),
+		Firmware: "v2",
+		Position: sn.position,
+		Fcnt:     fcnt,
+		Gateways: gateways,
+	}, true
+}
+
+// temperature follows a daily cycle that peaks mid-afternoon local time.
+func (s *Simulator) temperature(sn *sensor, t time.Time) float64 {
+	solar := float64(t.Hour()) + float64(t.Minute())/60 + float64(sn.position.Lng)/15
+	return sn.mean + 5*math.Cos(2*math.Pi*(solar-15)/24) + s.rand.NormFloat64()*0.3
+}
+
+// humidity returns the relative humidity of air at temp with the given dew point.
+func humidity(temp, dewPoint float64) float64 {
+	magnus := func(t float64) float64 {
+		return math.Exp(17.625 * t / (243.04 + t))
+	}
+	return math.Min(100, 100*magnus(dewPoint)/magnus(temp))
+}
+
+// receive reports whether a gateway hears a sensor, with the signal it
+// was received with. The signal weakens with the log of the distance.
+func (s *Simulator) receive(sn *sensor, g scrapejestad.Gateway, freq float32) (scrapejestad.Gateway, bool) {
+	pos := sn.position
+	if pos == (scrapejestad.Position{}) {
+		// Without a fix the sensor is somewhere near the first gateway.
+		pos = offset(s.gateways[0].Position, s.radius/2, 0)
+	}
+	d := distance(pos, g.Position)
+	rssi := -87 - 40*math.Log10(math.Max(0.05, d)) + s.rand.NormFloat64()*4
+	lsnr := math.Max(-20, math.Min(13.5, rssi+115+s.rand.NormFloat64()*2))
+	if lsnr < -12.5 {
+		return g, false
+	}
+
+	g.RSSI = float32(math.Round(rssi))
+	g.LSNR = float32(quantize(lsnr, 0.25))
+	g.RadioSettings = scrapejestad.RadioSettings{Frequency: freq, Sf: "SF9BW125", Cr: "4/5CR"}
+	g.Distance = 0
+	if sn.position != (scrapejestad.Position{}) {
+		g.Distance = float32(quantize(d, 0.001))
+	}
+	return g, true
+}
+
+// distance returns the great-circle distance between two positions in km.
+func distance(a, b scrapejestad.Position) float64 {
+	const earthRadius = 6371.0
+	lat1, lat2 := radians(a.Lat), radians(b.Lat)
+	dLat, dLng := lat2-lat1, radians(b.Lng)-radians(a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// offset returns the position d km from p in the direction of bearing radians.
+func offset(p scrapejestad.Position, d, bearing float64) scrapejestad.Position {
+	const kmPerDegree = 111.32
+	lat := float64(p.Lat) + d*math.Cos(bearing)/kmPerDegree
+	lng := float64(p.Lng) + d*math.Sin(bearing)/(kmPerDegree*math.Cos(radians(p.Lat)))
+	return scrapejestad.Position{Lat: float32(quantize(lat, 1e-6)), Lng: float32(quantize(lng, 1e-6))}
+}
+
+func radians(deg float32) float64 {
+	return float64(deg) * math.Pi / 180
+}
+
+// quantize rounds v to a multiple of step, like the sensors report their values.
+func quantize(v, step float64) float64 {
+	return math.Round(v/step) * step
+}
@@ -0,0 +1,124 @@
+package simulator
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+var start = time.Date(2019, 12, 5, 0, 0, 0, 0, time.UTC)
+
+func Test_seedRepeats(t *testing.T) {
+	a := New(5, WithSeed(42), WithStart(start)).Advance(6 * time.Hour)
+	b := New(5, WithSeed(42), WithStart(start)).Advance(6 * time.Hour)
+	if len(a) == 0 {
+		t.Fatalf("expected readings")
+	}
+	if diff := cmp.Diff(a, b); diff != "" {
+		t.Errorf("expected the same readings for the same seed: %s", diff)
+	}
+}
+
+func Test_invalidInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Minute} {
+		a := New(2, WithStart(start), WithInterval(d)).Advance(6 * time.Hour)
+		b := New(2, WithStart(start)).Advance(6 * time.Hour)
+		if diff := cmp.Diff(b, a); diff != "" {
+			t.Errorf("expected an interval of %v to be ignored: %s", d, diff)
+		}
+	}
+}
+
+func Test_sensorBehavior(t *testing.T) {
+	sim := New(1, WithStart(start), WithDrain(0.001))
+	readings := sim.Advance(48 * time.Hour)
+	if len(readings) < 150 {
+		t.Fatalf("expected about 192 readings, got %d", len(readings))
+	}
+	if !sim.Now().Equal(start.Add(48 * time.Hour)) {
+		t.Errorf("expected the simulation to be at %v, got %v", start.Add(48*time.Hour), sim.Now())
+	}
+
+	var night, afternoon, nightHumidity, afternoonHumidity float32
+	var nights, afternoons int
+	for i, r := range readings {
+		if i > 0 {
+			newer := readings[i-1]
+			if !newer.Date.After(r.Date) {
+				t.Fatalf("expected readings newest first")
+			}
+			if newer.Fcnt <= r.Fcnt {
+				t.Errorf("expected frame counter to increase, got %d after %d", newer.Fcnt, r.Fcnt)
+			}
+		}
+		if len(r.Gateways) == 0 {
+			t.Errorf("expected reading to be heard by a gateway")
+		}
+		switch h := r.Date.Hour(); {
+		case h >= 1 && h < 5:
+			night += r.Temp
+			nightHumidity += r.Humidity
+			nights++
+		case h >= 13 && h < 17:
+			afternoon += r.Temp
+			afternoonHumidity += r.Humidity
+			afternoons++
+		}
+	}
+	if afternoon/float32(afternoons) <= night/float32(nights)+5 {
+		t.Errorf("expected afternoons to be warmer than nights")
+	}
+	if afternoonHumidity/float32(afternoons) >= nightHumidity/float32(nights) {
+		t.Errorf("expected afternoons to be drier than nights")
+	}
+	first, last := readings[len(readings)-1], readings[0]
+	if last.Voltage >= first.Voltage-0.1 {
+		t.Errorf("expected battery to discharge, got %v then %v", first.Voltage, last.Voltage)
+	}
+}
+
+func Test_loss(t *testing.T) {
+	readings := New(1, WithStart(start), WithLoss(0.5)).Advance(24 * time.Hour)
+	first, last := readings[len(readings)-1], readings[0]
+	sent := last.Fcnt - first.Fcnt + 1
+	if len(readings) > sent*3/4 || len(readings) < sent/4 {
+		t.Errorf("expected about half of %d messages to be lost, got %d", sent, len(readings))
+	}
+}
+
+func Test_reception(t *testing.T) {
+	near := scrapejestad.Gateway{Name: "near", Position: scrapejestad.Position{Lat: 60.39, Lng: 5.32}}
+	far := scrapejestad.Gateway{Name: "far", Position: offset(near.Position, 60, 0)}
+	readings := New(20, WithStart(start), WithGateways([]scrapejestad.Gateway{near, far}, 1)).Advance(time.Hour)
+	for _, r := range readings {
+		for _, g := range r.Gateways {
+			if g.Name == "far" {
+				t.Errorf("expected far gateway to hear nothing, got %+v", g)
+			}
+			if g.RSSI > -20 || g.RSSI < -140 {
+				t.Errorf("unexpected RSSI %v", g.RSSI)
+			}
+			if r.Position != (scrapejestad.Position{}) && g.Distance > 1 {
+				t.Errorf("expected sensor within 1 km, got %v", g.Distance)
+			}
+		}
+	}
+}
+
+func Test_renderSimulated(t *testing.T) {
+	readings := New(10, WithStart(start)).Advance(2 * time.Hour)
+	var b bytes.Buffer
+	if err := scrapejestad.Render(&b, readings); err != nil {
+		t.Fatalf("error rendering: %v", err)
+	}
+	got, err := scrapejestad.Parse(&b)
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+	if diff := cmp.Diff(readings, got); diff != "" {
+		t.Errorf("readings differ: %s", diff)
+	}
+}
@@ -0,0 +1,580 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// Resolution is the time span covered by an aggregate.
+type Resolution string
+
+// The resolutions history is kept at.
+const (
+	Raw    Resolution = "raw"
+	Hourly Resolution = "1h"
+	Daily  Resolution = "1d"
+)
+
+// Duration returns the length of the time span, or zero for Raw.
+func (r Resolution) Duration() time.Duration {
+	switch r {
+	case Hourly:
+		return time.Hour
+	case Daily:
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+const (
+	hourlyExt   = ".1h"
+	dailyExt    = ".1d"
+	monthLayout = "2006-01"
+)
+
+// Summary describes the values of a measurement in a time span.
+type Summary struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+	Count int     `json:"count"`
+}
+
+func (s Summary) add(v float64) Summary {
+	return s.merge(Summary{Min: v, Max: v, Mean: v, Count: 1})
+}
+
+func (s Summary) merge(o Summary) Summary {
+	if s.Count == 0 {
+		return o
+	}
+	if o.Count == 0 {
+		return s
+	}
+	n := s.Count + o.Count
+	return Summary{
+		Min:   minFloat(s.Min, o.Min),
+		Max:   maxFloat(s.Max, o.Max),
+		Mean:  (s.Mean*float64(s.Count) + o.Mean*float64(o.Count)) / float64(n),
+		Count: n,
+	}
+}
+
+// Aggregate summarizes the readings of a sensor in a time span.
+// A raw reading is an aggregate of one.
+type Aggregate struct {
+	SensorID   string                         `json:"sensor_id"`
+	Start      time.Time                      `json:"start"`
+	Resolution Resolution                     `json:"resolution"`
+	Count      int                            `json:"count"`
+	Values     map[scrapejestad.Field]Summary `json:"values"`
+}
+
+func (a *Aggregate) add(r scrapejestad.Reading) {
+	if a.Values == nil {
+		a.Values = make(map[scrapejestad.Field]Summary)
+	}
+	a.Count++
+	for _, f := range scrapejestad.Fields {
+		v, ok := r.Value(f)
+		if !ok || (scrapejestad.OptionalField(f) && v == 0) {
+			continue
+		}
+		a.Values[f] = a.Values[f].add(v)
+	}
+}
+
+func (a *Aggregate) merge(o Aggregate) {
+	if a.Values == nil {
+		a.Values = make(map[scrapejestad.Field]Summary)
+	}
+	a.Count += o.Count
+	for f, s := range o.Values {
+		a.Values[f] = a.Values[f].merge(s)
+	}
+}
+
+// rawAggregate returns a reading as an aggregate of one.
+func rawAggregate(r scrapejestad.Reading) Aggregate {
+	a := Aggregate{SensorID: r.SensorID, Start: r.Date, Resolution: Raw}
+	a.add(r)
+	return a
+}
+
+func rawAggregates(readings []scrapejestad.Reading) []Aggregate {
+	res := make([]Aggregate, len(readings))
+	for i, r := range readings {
+		res[i] = rawAggregate(r)
+	}
+	return res
+}
+
+// rollup groups aggregates into spans of the given resolution.
+func rollup(in []Aggregate, res Resolution) []Aggregate {
+	var out []Aggregate
+	index := make(map[time.Time]int)
+	for _, a := range in {
+		start := a.Start.UTC().Truncate(res.Duration())
+		i, ok := index[start]
+		if !ok {
+			i = len(out)
+			index[start] = i
+			out = append(out, Aggregate{SensorID: a.SensorID, Start: start, Resolution: res})
+		}
+		out[i].merge(a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start.Before(out[j].Start) })
+	return out
+}
+
+// rollupFile is the content of the files holding aggregates.
+type rollupFile struct {
+	// Source is the size of the segment the aggregates were computed from.
+	Source int64 `json:"source,omitempty"`
+	// Sealed is set once the segment is deleted. Readings appended
+	// for the day after that are added to the aggregates.
+	Sealed bool `json:"sealed,omitempty"`
+	// Keys are the time and frame counter of the readings in the
+	// aggregates, so readings appended again after the segment was
+	// deleted are not counted twice.
+	Keys       [][2]int64  `json:"keys,omitempty"`
+	Aggregates []Aggregate `json:"aggregates"`
+}
+
+// merged returns the keys of the readings in the aggregates.
+func (f *rollupFile) merged() map[key]bool {
+	res := make(map[key]bool, len(f.Keys))
+	for _, k := range f.Keys {
+		res[key{time: k[0], fcnt: int32(k[1])}] = true
+	}
+	return res
+}
+
+// addKeys records that readings were added to the aggregates.
+func (f *rollupFile) addKeys(readings []scrapejestad.Reading) {
+	for _, r := range readings {
+		f.Keys = append(f.Keys, [2]int64{r.Date.Unix(), int64(r.Fcnt)})
+	}
+}
+
+// setDayKeys replaces the keys of the readings of a day.
+func (f *rollupFile) setDayKeys(day time.Time, keys [][2]int64) {
+	res := f.Keys[:0]
+	for _, k := range f.Keys {
+		if k[0] < day.Unix() || k[0] >= day.AddDate(0, 0, 1).Unix() {
+			res = append(res, k)
+		}
+	}
+	f.Keys = append(res, keys...)
+}
+
+// unmerged returns the readings that are not in merged.
+func unmerged(merged map[key]bool, readings []scrapejestad.Reading) []scrapejestad.Reading {
+	var res []scrapejestad.Reading
+	for _, r := range readings {
+		if !merged[key{time: r.Date.Unix(), fcnt: int32(r.Fcnt)}] {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+func readRollup(name string) (*rollupFile, error) {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f rollupFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("error reading '%s': %v", name, err)
+	}
+	return &f, nil
+}
+
+func writeRollup(name string, f *rollupFile) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFile(name, b)
+}
+
+// Policy decides how long history is kept at each resolution.
+// A zero duration keeps that resolution forever.
+type Policy struct {
+	Raw    time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+}
+
+// RetentionStats reports what applying a policy did.
+type RetentionStats struct {
+	DaysRolledUp    int
+	SegmentsDeleted int
+	RollupsDeleted  int
+}
+
+// ApplyRetention rolls completed days up into hourly and daily
+// aggregates and deletes what the policy no longer keeps. Days that
+// have not changed since the last run are skipped, so it can be run
+// as often as needed.
+func (s *Store) ApplyRetention(p Policy, now time.Time) (RetentionStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats RetentionStats
+	ids, err := s.sensors()
+	if err != nil {
+		return stats, err
+	}
+	for _, id := range ids {
+		days, err := s.days(id)
+		if err != nil {
+			return stats, err
+		}
+		for _, day := range days {
+			end := day.AddDate(0, 0, 1)
+			if end.After(now) {
+				continue
+			}
+			expired := p.Raw > 0 && !end.After(now.Add(-p.Raw))
+			rolled, deleted, err := s.rollupDay(id, day, expired)
+			if err != nil {
+				return stats, err
+			}
+			if rolled {
+				stats.DaysRolledUp++
+			}
+			if deleted {
+				stats.SegmentsDeleted++
+			}
+		}
+
+		n, err := s.expireRollups(id, hourlyExt, dayLayout, p.Hourly, now, func(t time.Time) time.Time { return t.AddDate(0, 0, 1) })
+		stats.RollupsDeleted += n
+		if err != nil {
+			return stats, err
+		}
+		n, err = s.expireRollups(id, dailyExt, monthLayout, p.Daily, now, func(t time.Time) time.Time { return t.AddDate(0, 1, 0) })
+		stats.RollupsDeleted += n
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// rollupDay updates the aggregates of a day of raw readings and
+// deletes the raw readings if they expired. It reports whether the
+// aggregates changed and whether the segment was deleted.
+func (s *Store) rollupDay(id string, day time.Time, expired bool) (bool, bool, error) {
+	path := s.segmentPath(id, day)
+	info, err := os.Stat(path + dataExt)
+	if err != nil {
+		return false, false, err
+	}
+	hourlyName := path + hourlyExt
+	hourly, err := readRollup(hourlyName)
+	if err != nil {
+		return false, false, err
+	}
+	monthName := filepath.Join(s.dir, id, day.Format(monthLayout)+dailyExt)
+	daily, err := readRollup(monthName)
+	if err != nil {
+		return false, false, err
+	}
+	if daily == nil {
+		daily = &rollupFile{}
+	}
+	dayIndex := -1
+	for i, a := range daily.Aggregates {
+		if a.Start.Equal(day) {
+			dayIndex = i
+		}
+	}
+	// Without hourly aggregates, a day that has daily ones was rolled
+	// up before and its hourly aggregates expired since.
+	sealed := (hourly != nil && hourly.Sealed) || (hourly == nil && dayIndex >= 0)
+
+	rolled := false
+	if sealed || hourly == nil || hourly.Source != info.Size() {
+		readings, err := s.read(path, Query{})
+		if err != nil {
+			return false, false, err
+		}
+		switch {
+		case hourly != nil && hourly.Sealed:
+			// Readings appended after the day was sealed are added,
+			// unless they were added before.
+			late := unmerged(hourly.merged(), readings)
+			hourly.Source = info.Size()
+			hourly.Aggregates = rollup(append(hourly.Aggregates, rawAggregates(late)...), Hourly)
+			hourly.addKeys(late)
+		case sealed:
+			// Only the daily aggregate is left to add the late readings to.
+			// The segment may have no valid records at all.
+			if late := unmerged(daily.merged(), readings); len(late) > 0 {
+				daily.Aggregates[dayIndex].merge(rollup(rawAggregates(late), Daily)[0])
+				daily.addKeys(late)
+			}
+		default:
+			hourly = &rollupFile{Source: info.Size(), Aggregates: rollup(rawAggregates(readings), Hourly)}
+			hourly.addKeys(readings)
+		}
+		if hourly != nil {
+			if err := writeRollup(hourlyName, hourly); err != nil {
+				return false, false, err
+			}
+			days := rollup(hourly.Aggregates, Daily)
+			switch {
+			case len(days) == 0 && dayIndex >= 0:
+				daily.Aggregates = append(daily.Aggregates[:dayIndex], daily.Aggregates[dayIndex+1:]...)
+			case len(days) == 0:
+			case dayIndex >= 0:
+				daily.Aggregates[dayIndex] = days[0]
+			default:
+				daily.Aggregates = append(daily.Aggregates, days[0])
+				sort.Slice(daily.Aggregates, func(i, j int) bool {
+					return daily.Aggregates[i].Start.Before(daily.Aggregates[j].Start)
+				})
+			}
+			daily.setDayKeys(day, hourly.Keys)
+		}
+		if err := writeRollup(monthName, daily); err != nil {
+			return false, false, err
+		}
+		rolled = true
+	}
+
+	if !expired && !sealed {
+		return rolled, false, nil
+	}
+	// Mark the aggregates as sealed before the readings are deleted,
+	// so a crash in between cannot count the readings twice.
+	if hourly != nil && !hourly.Sealed {
+		hourly.Sealed = true
+		if err := writeRollup(hourlyName, hourly); err != nil {
+			return rolled, false, err
+		}
+	}
+	if seg, ok := s.segments[path]; ok {
+		seg.close()
+		delete(s.segments, path)
+	}
+	if err := os.Remove(path + indexExt); err != nil && !os.IsNotExist(err) {
+		return rolled, false, err
+	}
+	if err := os.Remove(path + dataExt); err != nil {
+		return rolled, false, err
+	}
+	return rolled, true, syncDir(filepath.Dir(path))
+}
+
+// expireRollups deletes the rollup files with the given extension
+// whose span, named with layout, ended longer than keep ago.
+func (s *Store) expireRollups(id, ext, layout string, keep time.Duration, now time.Time, end func(time.Time) time.Time) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	names, err := s.rollupFiles(id, ext, layout)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for start, name := range names {
+		if end(start).After(now.Add(-keep)) {
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// rollupFiles returns the rollup files of a sensor by the start of their span.
+func (s *Store) rollupFiles(id, ext, layout string) (map[time.Time]string, error) {
+	dir := filepath.Join(s.dir, id)
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[time.Time]string)
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+		start, err := time.Parse(layout, strings.TrimSuffix(name, ext))
+		if err != nil {
+			continue
+		}
+		files[start] = filepath.Join(dir, name)
+	}
+	return files, nil
+}
+
+// History returns the aggregates of the readings matching q, oldest
+// first, at the finest resolution still kept for each day: raw
+// readings where they exist, then hourly and then daily aggregates.
+// Readings appended for a day after it was rolled up are merged into
+// its aggregates.
+func (s *Store) History(q Query) ([]Aggregate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensors := q.Sensors
+	if len(sensors) == 0 {
+		var err error
+		if sensors, err = s.sensors(); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []Aggregate
+	for _, id := range sensors {
+		if err := checkSensorID(id); err != nil {
+			return nil, err
+		}
+		aggs, err := s.history(id, q)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, aggs...)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Start.Before(res[j].Start)
+	})
+	return res, nil
+}
+
+func (s *Store) history(id string, q Query) ([]Aggregate, error) {
+	inRange := func(t time.Time) bool {
+		return (q.From.IsZero() || !t.Before(q.From)) && (q.To.IsZero() || t.Before(q.To))
+	}
+
+	hourly, err := s.rollupFiles(id, hourlyExt, dayLayout)
+	if err != nil {
+		return nil, err
+	}
+	daily, err := s.rollupFiles(id, dailyExt, monthLayout)
+	if err != nil {
+		return nil, err
+	}
+	dailyAggs := make(map[time.Time]Aggregate)
+	dailyMerged := make(map[key]bool)
+	for _, name := range daily {
+		f, err := readRollup(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range f.Aggregates {
+			dailyAggs[a.Start] = a
+		}
+		for k := range f.merged() {
+			dailyMerged[k] = true
+		}
+	}
+
+	covered := make(map[time.Time]bool)
+	var res []Aggregate
+	days, err := s.days(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range days {
+		covered[day] = true
+		if (!q.To.IsZero() && !day.Before(q.To)) || (!q.From.IsZero() && !day.AddDate(0, 0, 1).After(q.From)) {
+			continue
+		}
+		var f *rollupFile
+		if name, ok := hourly[day]; ok {
+			if f, err = readRollup(name); err != nil {
+				return nil, err
+			}
+		}
+		d, hasDaily := dailyAggs[day]
+		if (f == nil || !f.Sealed) && (f != nil || !hasDaily) {
+			readings, err := s.read(s.segmentPath(id, day), q)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range readings {
+				res = append(res, rawAggregate(r))
+			}
+			continue
+		}
+
+		// The segment holds readings that arrived after the day was
+		// rolled up, which are added to the aggregates unless they
+		// were added before.
+		readings, err := s.read(s.segmentPath(id, day), Query{})
+		if err != nil {
+			return nil, err
+		}
+		aggs := []Aggregate{d}
+		if f != nil {
+			late := unmerged(f.merged(), readings)
+			aggs = rollup(append(f.Aggregates, rawAggregates(late)...), Hourly)
+		} else if late := unmerged(dailyMerged, readings); len(late) > 0 {
+			aggs[0].merge(rollup(rawAggregates(late), Daily)[0])
+		}
+		for _, a := range aggs {
+			if inRange(a.Start) {
+				res = append(res, a)
+			}
+		}
+	}
+
+	for day, name := range hourly {
+		if covered[day] {
+			continue
+		}
+		covered[day] = true
+		f, err := readRollup(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range f.Aggregates {
+			if inRange(a.Start) {
+				res = append(res, a)
+			}
+		}
+	}
+
+	for start, a := range dailyAggs {
+		if !covered[start] && inRange(start) {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
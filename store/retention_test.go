@@ -0,0 +1,207 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/google/go-cmp/cmp"
+)
+
+func reading(fcnt int, date time.Time, temp float32) scrapejestad.Reading {
+	return scrapejestad.Reading{SensorID: "242", Date: date, Time: date.Unix(), Temp: temp, Humidity: 80, Voltage: 3.5, Fcnt: fcnt}
+}
+
+func history(t *testing.T, s *Store, q Query) []Aggregate {
+	res, err := s.History(q)
+	if err != nil {
+		t.Fatalf("error reading history: %v", err)
+	}
+	return res
+}
+
+func Test_retention(t *testing.T) {
+	s := open(t, t.TempDir())
+	day := time.Date(2019, 12, 5, 0, 0, 0, 0, time.UTC)
+	if _, err := s.Append(
+		reading(1, day.Add(10*time.Hour), 4),
+		reading(2, day.Add(10*time.Hour+30*time.Minute), 6),
+		reading(3, day.Add(11*time.Hour), 5),
+		reading(4, day.Add(36*time.Hour), 7),
+		reading(5, day.Add(72*time.Hour+30*time.Minute), 8),
+	); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+
+	p := Policy{Raw: 48 * time.Hour, Hourly: 10 * 24 * time.Hour}
+	now := day.Add(73 * time.Hour)
+	stats, err := s.ApplyRetention(p, now)
+	if err != nil {
+		t.Fatalf("error applying retention: %v", err)
+	}
+	if want := (RetentionStats{DaysRolledUp: 2, SegmentsDeleted: 1}); stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+	if stats, _ := s.ApplyRetention(p, now); stats != (RetentionStats{}) {
+		t.Errorf("expected nothing to do when applied again, got %+v", stats)
+	}
+	if res := query(t, s, Query{To: day.AddDate(0, 0, 1)}); len(res) != 0 {
+		t.Errorf("expected raw readings of the first day to be deleted, got %d", len(res))
+	}
+
+	got := history(t, s, Query{})
+	want := []struct {
+		start time.Time
+		res   Resolution
+		count int
+	}{
+		{day.Add(10 * time.Hour), Hourly, 2},
+		{day.Add(11 * time.Hour), Hourly, 1},
+		{day.Add(36 * time.Hour), Raw, 1},
+		{day.Add(72*time.Hour + 30*time.Minute), Raw, 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d aggregates, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		if a := got[i]; !a.Start.Equal(w.start) || a.Resolution != w.res || a.Count != w.count {
+			t.Errorf("%d: expected %d %s readings at %v, got %d %s at %v", i, w.count, w.res, w.start, a.Count, a.Resolution, a.Start)
+		}
+	}
+	wantValues := map[scrapejestad.Field]Summary{
+		scrapejestad.FieldTemperature: {Min: 4, Max: 6, Mean: 5, Count: 2},
+		scrapejestad.FieldHumidity:    {Min: 80, Max: 80, Mean: 80, Count: 2},
+		scrapejestad.FieldVoltage:     {Min: 3.5, Max: 3.5, Mean: 3.5, Count: 2},
+	}
+	if diff := cmp.Diff(wantValues, got[0].Values); diff != "" {
+		t.Errorf("values differ: %s", diff)
+	}
+
+	// A late reading for a deleted day is added to its rollups.
+	if _, err := s.Append(reading(6, day.Add(10*time.Hour+45*time.Minute), 9)); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	// Until retention runs again, it is merged with the rollups when read.
+	got = history(t, s, Query{To: day.AddDate(0, 0, 1)})
+	if len(got) != 2 || got[0].Resolution != Hourly || got[0].Count != 3 || got[1].Count != 1 {
+		t.Errorf("expected late reading merged into hourly aggregates before retention, got %+v", got)
+	}
+	stats, err = s.ApplyRetention(p, now)
+	if err != nil {
+		t.Fatalf("error applying retention: %v", err)
+	}
+	if want := (RetentionStats{DaysRolledUp: 1, SegmentsDeleted: 1}); stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+	got = history(t, s, Query{To: day.Add(11 * time.Hour)})
+	if len(got) != 1 || got[0].Count != 3 || got[0].Values[scrapejestad.FieldTemperature].Max != 9 {
+		t.Errorf("expected late reading in hourly aggregate, got %+v", got)
+	}
+
+	// Once hourly aggregates expire, only the daily ones are left.
+	stats, err = s.ApplyRetention(p, day.AddDate(0, 0, 20))
+	if err != nil {
+		t.Fatalf("error applying retention: %v", err)
+	}
+	if want := (RetentionStats{DaysRolledUp: 1, SegmentsDeleted: 2, RollupsDeleted: 3}); stats != want {
+		t.Errorf("expected %+v, got %+v", want, stats)
+	}
+	got = history(t, s, Query{})
+	if len(got) != 3 {
+		t.Fatalf("expected 3 daily aggregates, got %d", len(got))
+	}
+	if a := got[0]; a.Resolution != Daily || !a.Start.Equal(day) || a.Count != 4 {
+		t.Errorf("expected 4 readings in daily aggregate of %v, got %+v", day, a)
+	}
+	if mean := got[0].Values[scrapejestad.FieldTemperature].Mean; mean != 6 {
+		t.Errorf("expected mean temperature of 6, got %v", mean)
+	}
+	got = history(t, s, Query{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 0, 2)})
+	if len(got) != 1 || got[0].Count != 1 {
+		t.Errorf("expected daily aggregate of the second day, got %+v", got)
+	}
+
+	// With only the daily aggregate left, late readings are merged into it.
+	if _, err := s.Append(reading(7, day.Add(20*time.Hour), 2)); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	got = history(t, s, Query{To: day.AddDate(0, 0, 1)})
+	if len(got) != 1 || got[0].Resolution != Daily || got[0].Count != 5 || got[0].Values[scrapejestad.FieldTemperature].Min != 2 {
+		t.Errorf("expected late reading merged into daily aggregate, got %+v", got)
+	}
+}
+
+func Test_retentionCorruptLateSegment(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	day := time.Date(2019, 12, 5, 0, 0, 0, 0, time.UTC)
+	if _, err := s.Append(reading(1, day.Add(10*time.Hour), 4)); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	p := Policy{Raw: 24 * time.Hour, Hourly: 24 * time.Hour}
+	if _, err := s.ApplyRetention(p, day.AddDate(0, 0, 5)); err != nil {
+		t.Fatalf("error applying retention: %v", err)
+	}
+
+	// A late segment whose only record is corrupt.
+	if _, err := s.Append(reading(2, day.Add(11*time.Hour), 5)); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	s.Close()
+	path := filepath.Join(dir, "242", "2019-12-05.seg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading segment: %v", err)
+	}
+	data[headerSize+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("error writing segment: %v", err)
+	}
+
+	stats, err := s.ApplyRetention(p, day.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatalf("error applying retention: %v", err)
+	}
+	if stats.SegmentsDeleted != 1 {
+		t.Errorf("expected the empty segment to be deleted, got %+v", stats)
+	}
+	if got := history(t, s, Query{}); len(got) != 1 || got[0].Resolution != Daily || got[0].Count != 1 {
+		t.Errorf("expected the daily aggregate to be unchanged, got %+v", got)
+	}
+}
+
+func Test_retentionReappended(t *testing.T) {
+	day := time.Date(2019, 12, 5, 0, 0, 0, 0, time.UTC)
+	for _, p := range []Policy{{Raw: 24 * time.Hour}, {Raw: 24 * time.Hour, Hourly: 24 * time.Hour}} {
+		s := open(t, t.TempDir())
+		first := reading(1, day.Add(10*time.Hour), 4)
+		if _, err := s.Append(first); err != nil {
+			t.Fatalf("error appending: %v", err)
+		}
+		if _, err := s.ApplyRetention(p, day.AddDate(0, 0, 5)); err != nil {
+			t.Fatalf("error applying retention: %v", err)
+		}
+
+		// The segment is gone, so the first reading is stored again.
+		if _, err := s.Append(first, reading(2, day.Add(11*time.Hour), 5)); err != nil {
+			t.Fatalf("error appending: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			count := 0
+			for _, a := range history(t, s, Query{}) {
+				count += a.Count
+			}
+			if count != 2 {
+				t.Errorf("%+v, run %d: expected 2 readings in the history, got %d", p, i, count)
+			}
+			if _, err := s.ApplyRetention(p, day.AddDate(0, 0, 5)); err != nil {
+				t.Fatalf("error applying retention: %v", err)
+			}
+			if _, err := s.Append(first); err != nil {
+				t.Fatalf("error appending: %v", err)
+			}
+		}
+	}
+}
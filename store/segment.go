@@ -0,0 +1,419 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// A segment holds the readings of one sensor on one day. The data file
+// is a sequence of records, each a header of the payload length and its
+// CRC-32C checksum followed by the reading as JSON. The index file has
+// an entry of fixed size per record, so a range of a day can be read
+// without decoding the others.
+const (
+	dataExt    = ".seg"
+	indexExt   = ".idx"
+	headerSize = 8
+	entrySize  = 24
+)
+
+// maxRecordSize bounds the length of a record, as a damaged length
+// could otherwise be as large as the file.
+const maxRecordSize = 1 << 20
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errCorrupt is returned for records whose checksum does not match.
+var errCorrupt = errors.New("corrupt record")
+
+// entry locates a record in a data file.
+type entry struct {
+	time   int64
+	fcnt   int32
+	length uint32
+	offset int64
+}
+
+func (e entry) end() int64 {
+	return e.offset + headerSize + int64(e.length)
+}
+
+type key struct {
+	time int64
+	fcnt int32
+}
+
+func (e entry) key() key {
+	return key{time: e.time, fcnt: e.fcnt}
+}
+
+func (e entry) marshal(b []byte) []byte {
+	var buf [entrySize]byte
+	binary.LittleEndian.PutUint64(buf[0:], uint64(e.time))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(e.fcnt))
+	binary.LittleEndian.PutUint32(buf[12:], e.length)
+	binary.LittleEndian.PutUint64(buf[16:], uint64(e.offset))
+	return append(b, buf[:]...)
+}
+
+func unmarshalEntry(b []byte) entry {
+	return entry{
+		time:   int64(binary.LittleEndian.Uint64(b[0:])),
+		fcnt:   int32(binary.LittleEndian.Uint32(b[8:])),
+		length: binary.LittleEndian.Uint32(b[12:]),
+		offset: int64(binary.LittleEndian.Uint64(b[16:])),
+	}
+}
+
+// appendRecord appends the record of r to b and returns its entry.
+func appendRecord(b []byte, offset int64, r scrapejestad.Reading) ([]byte, entry, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return b, entry{}, err
+	}
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	b = append(b, header[:]...)
+	b = append(b, payload...)
+	return b, entry{time: r.Date.Unix(), fcnt: int32(r.Fcnt), length: uint32(len(payload)), offset: offset}, nil
+}
+
+// readRecord reads the reading of an entry.
+func readRecord(f io.ReaderAt, e entry) (scrapejestad.Reading, error) {
+	var r scrapejestad.Reading
+	buf := make([]byte, headerSize+int(e.length))
+	if _, err := f.ReadAt(buf, e.offset); err != nil {
+		return r, err
+	}
+	payload := buf[headerSize:]
+	if binary.LittleEndian.Uint32(buf[0:]) != e.length || binary.LittleEndian.Uint32(buf[4:]) != crc32.Checksum(payload, crcTable) {
+		return r, errCorrupt
+	}
+	err := json.Unmarshal(payload, &r)
+	return r, err
+}
+
+// scan reads the entries of every intact record in a data file. It
+// returns the size of the file up to the end of the last complete
+// record, so a record torn by a crash can be cut off. Complete records
+// with a wrong checksum are skipped. When a damaged length hides where
+// the next record starts, scan looks for it byte by byte, so only a
+// damaged tail with no intact record after it is cut off.
+func scan(f *os.File) ([]entry, int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+
+	var entries []entry
+	var offset int64
+	for offset+headerSize <= size {
+		e, err := recordAt(f, offset, size)
+		if err == nil {
+			entries = append(entries, e)
+			offset = e.end()
+			continue
+		}
+		if err != errCorrupt {
+			return nil, 0, err
+		}
+		// A record with an intact length is followed by the next one.
+		if e.length > 0 && e.end() <= size {
+			if e.end() == size {
+				offset = size
+				break
+			}
+			if _, err := recordAt(f, e.end(), size); err == nil {
+				offset = e.end()
+				continue
+			}
+		}
+		next, err := resync(f, offset+1, size)
+		if err != nil {
+			return nil, 0, err
+		}
+		if next < 0 {
+			break
+		}
+		offset = next
+	}
+	return entries, offset, nil
+}
+
+// recordAt returns the entry of the intact record at offset, or
+// errCorrupt with the entry as given by the header.
+func recordAt(f *os.File, offset, size int64) (entry, error) {
+	var header [headerSize]byte
+	if offset+headerSize > size {
+		return entry{}, errCorrupt
+	}
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		return entry{}, err
+	}
+	e := entry{length: binary.LittleEndian.Uint32(header[0:]), offset: offset}
+	if e.length == 0 || e.length > maxRecordSize || e.end() > size {
+		return e, errCorrupt
+	}
+	r, err := readRecord(f, e)
+	if _, ok := err.(*os.PathError); ok {
+		return e, err
+	}
+	if err != nil {
+		// Payloads that aren't readings are damage too.
+		return e, errCorrupt
+	}
+	e.time = r.Date.Unix()
+	e.fcnt = int32(r.Fcnt)
+	return e, nil
+}
+
+// resync returns the offset of the first intact record from offset on,
+// or -1 if there is none.
+func resync(f *os.File, offset, size int64) (int64, error) {
+	for ; offset+headerSize <= size; offset++ {
+		_, err := recordAt(f, offset, size)
+		if err == nil {
+			return offset, nil
+		}
+		if err != errCorrupt {
+			return 0, err
+		}
+	}
+	return -1, nil
+}
+
+// readIndex reads the index of a data file of the given size. It falls
+// back to scanning the data file when the index is missing or does not
+// cover the whole file, as happens when a crash interrupted a write.
+func readIndex(path string, data *os.File, size int64) ([]entry, error) {
+	b, err := ioutil.ReadFile(path + indexExt)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b)%entrySize == 0 {
+		entries := make([]entry, 0, len(b)/entrySize)
+		for i := 0; i < len(b); i += entrySize {
+			entries = append(entries, unmarshalEntry(b[i:]))
+		}
+		var end int64
+		if n := len(entries); n > 0 {
+			end = entries[n-1].end()
+		}
+		if end == size {
+			return entries, nil
+		}
+	}
+	entries, _, err := scan(data)
+	return entries, err
+}
+
+// segment is a data file opened for appending.
+type segment struct {
+	path  string
+	data  *os.File
+	index *os.File
+	size  int64
+	seen  map[key]bool
+}
+
+// openSegment opens a segment for appending, repairing the damage a
+// crash during an earlier write may have left.
+func openSegment(path string) (*segment, error) {
+	created := false
+	if _, err := os.Stat(path + dataExt); os.IsNotExist(err) {
+		created = true
+	}
+	data, err := os.OpenFile(path+dataExt, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	entries, size, err := scan(data)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	if info, err := data.Stat(); err == nil && info.Size() != size {
+		if err := data.Truncate(size); err != nil {
+			data.Close()
+			return nil, fmt.Errorf("error truncating torn record in '%s': %v", path+dataExt, err)
+		}
+	}
+	if err := writeIndex(path, entries); err != nil {
+		data.Close()
+		return nil, err
+	}
+	index, err := os.OpenFile(path+indexExt, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	if created {
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			data.Close()
+			index.Close()
+			return nil, err
+		}
+	}
+
+	s := &segment{path: path, data: data, index: index, size: size, seen: make(map[key]bool, len(entries))}
+	for _, e := range entries {
+		s.seen[e.key()] = true
+	}
+	return s, nil
+}
+
+// append writes readings that are not in the segment yet and returns how many were written.
+func (s *segment) append(readings []scrapejestad.Reading) (int, error) {
+	var data, index []byte
+	offset := s.size
+	added := make(map[key]bool)
+	for _, r := range readings {
+		var e entry
+		var err error
+		data, e, err = appendRecord(data, offset, r)
+		if err != nil {
+			return 0, err
+		}
+		if s.seen[e.key()] || added[e.key()] {
+			data = data[:offset-s.size]
+			continue
+		}
+		added[e.key()] = true
+		index = e.marshal(index)
+		offset = e.end()
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+
+	// The data is synced before the index, so the index never points past the data.
+	if _, err := s.data.WriteAt(data, s.size); err != nil {
+		return 0, err
+	}
+	if err := s.data.Sync(); err != nil {
+		return 0, err
+	}
+	s.size = offset
+	for k := range added {
+		s.seen[k] = true
+	}
+	if _, err := s.index.Write(index); err != nil {
+		return 0, err
+	}
+	if err := s.index.Sync(); err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+func (s *segment) close() error {
+	err := s.data.Close()
+	if ierr := s.index.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+// writeIndex replaces the index of a segment.
+func writeIndex(path string, entries []entry) error {
+	b := make([]byte, 0, len(entries)*entrySize)
+	for _, e := range entries {
+		b = e.marshal(b)
+	}
+	return writeFile(path+indexExt, b)
+}
+
+// compact rewrites a segment with its readings sorted by time and
+// without duplicates or corrupt records.
+func compact(path string) error {
+	data, err := os.Open(path + dataExt)
+	if err != nil {
+		return err
+	}
+	entries, _, err := scan(data)
+	if err != nil {
+		data.Close()
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].time < entries[j].time
+	})
+
+	var b []byte
+	var index []entry
+	seen := make(map[key]bool, len(entries))
+	for _, e := range entries {
+		if seen[e.key()] {
+			continue
+		}
+		seen[e.key()] = true
+		r, err := readRecord(data, e)
+		if err != nil {
+			data.Close()
+			return err
+		}
+		var ne entry
+		if b, ne, err = appendRecord(b, int64(len(b)), r); err != nil {
+			data.Close()
+			return err
+		}
+		index = append(index, ne)
+	}
+	data.Close()
+
+	// Without an index the data file is scanned, so a crash between
+	// replacing the data and the index leaves a readable segment.
+	if err := os.Remove(path + indexExt); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeFile(path+dataExt, b); err != nil {
+		return err
+	}
+	return writeIndex(path, index)
+}
+
+// writeFile atomically replaces a file and syncs it to disk.
+func writeFile(name string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+// syncDir makes the creation and renaming of files in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
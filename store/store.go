@@ -0,0 +1,286 @@
+// Package store keeps readings on local disk without an external database.
+//
+// Readings are appended to segments partitioned by sensor and day, in
+// files named <dir>/<sensor>/<yyyy-mm-dd>.seg. Every record carries a
+// checksum and every write is synced to disk before it returns. An
+// index file next to each segment locates readings by time. Segments
+// are append-only; Compact rewrites them sorted and without duplicates.
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+)
+
+// dayLayout names the segment files.
+const dayLayout = "2006-01-02"
+
+// Store is a directory of segments.
+type Store struct {
+	dir string
+
+	mu       sync.Mutex
+	segments map[string]*segment
+}
+
+// Query selects readings from a store.
+type Query struct {
+	// Sensors are the sensor IDs to read. Empty means all sensors.
+	Sensors []string
+	// From and To limit the readings to From <= time < To.
+	// A zero time leaves that end of the range open.
+	From time.Time
+	To   time.Time
+}
+
+// Open opens the store in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating store '%s': %v", dir, err)
+	}
+	return &Store{dir: dir, segments: make(map[string]*segment)}, nil
+}
+
+// Close closes the segments opened for appending.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for path, seg := range s.segments {
+		if cerr := seg.close(); err == nil {
+			err = cerr
+		}
+		delete(s.segments, path)
+	}
+	return err
+}
+
+// Append stores readings and returns how many were new. Readings that
+// are already stored, with the same sensor, time and fcnt, are skipped.
+func (s *Store) Append(readings ...scrapejestad.Reading) (int, error) {
+	bySegment := make(map[string][]scrapejestad.Reading)
+	var paths []string
+	for _, r := range readings {
+		if err := checkSensorID(r.SensorID); err != nil {
+			return 0, err
+		}
+		path := s.segmentPath(r.SensorID, r.Date)
+		if _, ok := bySegment[path]; !ok {
+			paths = append(paths, path)
+		}
+		bySegment[path] = append(bySegment[path], r)
+	}
+	sort.Strings(paths)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := 0
+	for _, path := range paths {
+		seg, err := s.segment(path)
+		if err != nil {
+			return added, err
+		}
+		n, err := seg.append(bySegment[path])
+		added += n
+		if err != nil {
+			return added, fmt.Errorf("error appending to '%s': %v", path+dataExt, err)
+		}
+	}
+	return added, nil
+}
+
+// segment returns the segment at path opened for appending.
+func (s *Store) segment(path string) (*segment, error) {
+	if seg, ok := s.segments[path]; ok {
+		return seg, nil
+	}
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := syncDir(s.dir); err != nil {
+			return nil, err
+		}
+	}
+	seg, err := openSegment(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening '%s': %v", path+dataExt, err)
+	}
+	s.segments[path] = seg
+	return seg, nil
+}
+
+// Query returns the readings matching q, oldest first. Records with
+// a wrong checksum are left out.
+func (s *Store) Query(q Query) ([]scrapejestad.Reading, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sensors := q.Sensors
+	if len(sensors) == 0 {
+		var err error
+		if sensors, err = s.sensors(); err != nil {
+			return nil, err
+		}
+	}
+
+	var res []scrapejestad.Reading
+	for _, id := range sensors {
+		if err := checkSensorID(id); err != nil {
+			return nil, err
+		}
+		days, err := s.days(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			if !q.To.IsZero() && !day.Before(q.To) {
+				continue
+			}
+			if !q.From.IsZero() && !day.AddDate(0, 0, 1).After(q.From) {
+				continue
+			}
+			readings, err := s.read(s.segmentPath(id, day), q)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, readings...)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Date.Before(res[j].Date)
+	})
+	return res, nil
+}
+
+// read returns the readings of a segment within the time range of q.
+func (s *Store) read(path string, q Query) ([]scrapejestad.Reading, error) {
+	data, err := os.Open(path + dataExt)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	info, err := data.Stat()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readIndex(path, data, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("error reading index of '%s': %v", path+dataExt, err)
+	}
+
+	var res []scrapejestad.Reading
+	for _, e := range entries {
+		if !q.From.IsZero() && e.time < q.From.Unix() {
+			continue
+		}
+		if !q.To.IsZero() && e.time >= q.To.Unix() {
+			continue
+		}
+		r, err := readRecord(data, e)
+		if err == errCorrupt {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading '%s': %v", path+dataExt, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// Sensors returns the IDs of the sensors in the store.
+func (s *Store) Sensors() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sensors()
+}
+
+func (s *Store) sensors() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, info := range infos {
+		if info.IsDir() && checkSensorID(info.Name()) == nil {
+			ids = append(ids, info.Name())
+		}
+	}
+	scrapejestad.SortSensorIDs(ids)
+	return ids, nil
+}
+
+// days returns the days a sensor has segments for, oldest first.
+func (s *Store) days(id string) ([]time.Time, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(s.dir, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, dataExt) {
+			continue
+		}
+		day, err := time.Parse(dayLayout, strings.TrimSuffix(name, dataExt))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// Compact rewrites every segment sorted by time, without duplicates
+// and without records with a wrong checksum.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.sensors()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		days, err := s.days(id)
+		if err != nil {
+			return err
+		}
+		for _, day := range days {
+			path := s.segmentPath(id, day)
+			if seg, ok := s.segments[path]; ok {
+				seg.close()
+				delete(s.segments, path)
+			}
+			if err := compact(path); err != nil {
+				return fmt.Errorf("error compacting '%s': %v", path+dataExt, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) segmentPath(id string, t time.Time) string {
+	return filepath.Join(s.dir, id, t.UTC().Format(dayLayout))
+}
+
+// checkSensorID makes sure a sensor ID can be used as a directory name.
+func checkSensorID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("invalid sensor ID '%s'", id)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/scrapejestadtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// oldestFirst returns the fixture sorted like query results.
+func oldestFirst() []scrapejestad.Reading {
+	f := scrapejestadtest.Fixture()
+	return []scrapejestad.Reading{f[3], f[2], f[1], f[0]}
+}
+
+func open(t *testing.T, dir string) *Store {
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func query(t *testing.T, s *Store, q Query) []scrapejestad.Reading {
+	res, err := s.Query(q)
+	if err != nil {
+		t.Fatalf("error querying: %v", err)
+	}
+	return res
+}
+
+func Test_appendAndQuery(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+
+	n, err := s.Append(scrapejestadtest.Fixture()...)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 readings added, got %d: %v", n, err)
+	}
+	if n, _ := s.Append(scrapejestadtest.Fixture()[1:3]...); n != 0 {
+		t.Errorf("expected duplicates to be skipped, got %d added", n)
+	}
+	if diff := cmp.Diff(oldestFirst(), query(t, s, Query{})); diff != "" {
+		t.Errorf("readings differ: %s", diff)
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []scrapejestad.Reading
+	}{
+		{"sensor", Query{Sensors: []string{"372"}}, oldestFirst()[:2]},
+		{"from", Query{From: oldestFirst()[2].Date}, oldestFirst()[2:]},
+		{"to", Query{To: oldestFirst()[1].Date}, oldestFirst()[:1]},
+		{"day", Query{From: time.Date(2019, 12, 5, 21, 10, 0, 0, time.UTC), To: time.Date(2019, 12, 6, 0, 0, 0, 0, time.UTC)}, oldestFirst()[3:]},
+		{"unknown sensor", Query{Sensors: []string{"1"}}, nil},
+	}
+	for _, test := range tests {
+		if diff := cmp.Diff(test.want, query(t, s, test.q)); diff != "" {
+			t.Errorf("%s: readings differ: %s", test.name, diff)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "242", "2019-12-05.seg")); err != nil {
+		t.Errorf("expected segment per sensor and day: %v", err)
+	}
+	if ids, _ := s.Sensors(); !cmp.Equal(ids, []string{"242", "372"}) {
+		t.Errorf("unexpected sensors %v", ids)
+	}
+	if _, err := s.Append(scrapejestad.Reading{SensorID: "../etc"}); err == nil {
+		t.Errorf("expected error for sensor ID with a path")
+	}
+}
+
+func Test_recoverTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	if _, err := s.Append(scrapejestadtest.Fixture()[2:]...); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	s.Close()
+
+	// Simulate a crash halfway through writing a record.
+	path := filepath.Join(dir, "372", "2019-02-15.seg")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("error opening segment: %v", err)
+	}
+	f.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, '{', '"'})
+	f.Close()
+
+	s = open(t, dir)
+	if diff := cmp.Diff(oldestFirst()[:2], query(t, s, Query{})); diff != "" {
+		t.Errorf("readings differ after crash: %s", diff)
+	}
+	later := scrapejestadtest.Fixture()[2]
+	later.Date = later.Date.Add(time.Hour)
+	later.Fcnt = 2
+	if _, err := s.Append(later); err != nil {
+		t.Fatalf("error appending after crash: %v", err)
+	}
+	if got := query(t, s, Query{}); len(got) != 3 || got[2].Fcnt != 2 {
+		t.Errorf("expected torn record to be replaced, got %d readings", len(got))
+	}
+}
+
+func Test_corruptLength(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	if _, err := s.Append(scrapejestadtest.Fixture()...); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	s.Close()
+
+	// Damage the length of the first record, so it seems to run past the end.
+	path := filepath.Join(dir, "242", "2019-12-05.seg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading segment: %v", err)
+	}
+	data[2] = 0x7f
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("error writing segment: %v", err)
+	}
+	os.Remove(filepath.Join(dir, "242", "2019-12-05.idx"))
+
+	s = open(t, dir)
+	later := scrapejestadtest.Fixture()[0]
+	later.Date = later.Date.Add(time.Hour)
+	if _, err := s.Append(later); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() <= int64(len(data)) {
+		t.Errorf("expected the records after the damaged one to be kept")
+	}
+	// The damaged record is the newest reading of the fixture.
+	want := append(oldestFirst()[:3], later)
+	if diff := cmp.Diff(want, query(t, s, Query{})); diff != "" {
+		t.Errorf("expected only the damaged record to be left out: %s", diff)
+	}
+}
+
+func Test_corruptionAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir)
+	// Append newest first, so compaction has to sort.
+	if _, err := s.Append(scrapejestadtest.Fixture()...); err != nil {
+		t.Fatalf("error appending: %v", err)
+	}
+
+	path := filepath.Join(dir, "242", "2019-12-05.seg")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading segment: %v", err)
+	}
+	data[headerSize+2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("error writing segment: %v", err)
+	}
+
+	want := oldestFirst()[:3]
+	if diff := cmp.Diff(want, query(t, s, Query{})); diff != "" {
+		t.Errorf("expected corrupt record to be left out: %s", diff)
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	if diff := cmp.Diff(want, query(t, s, Query{})); diff != "" {
+		t.Errorf("readings differ after compaction: %s", diff)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() >= int64(len(data)) {
+		t.Errorf("expected compaction to drop the corrupt record")
+	}
+
+	// A missing index is rebuilt from the data.
+	os.Remove(filepath.Join(dir, "372", "2019-02-15.idx"))
+	if diff := cmp.Diff(want, query(t, s, Query{})); diff != "" {
+		t.Errorf("readings differ without index: %s", diff)
+	}
+	if _, err := s.Append(scrapejestadtest.Fixture()...); err != nil {
+		t.Fatalf("error appending after compaction: %v", err)
+	}
+	if got := query(t, s, Query{}); len(got) != 4 {
+		t.Errorf("expected corrupt reading to be stored again, got %d readings", len(got))
+	}
+}
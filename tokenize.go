@@ -0,0 +1,531 @@
+package scrapejestad
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// cell is the text content of a table cell together with
+// the target and text of the first link found inside it.
+type cell struct {
+	text    string
+	hasLink bool
+	href    string
+	link    string
+}
+
+// parseTokens parses the readings table of a sensors_recent page
+// by streaming over the tokens of the document instead of building
+// the full node tree.
+func parseTokens(r io.Reader) ([]Reading, error) {
+	p, err := ParsePage(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.Readings, nil
+}
+
+// tableKind identifies the tables on a sensors_recent page.
+type tableKind int
+
+const (
+	tableUnknown tableKind = iota
+	tableReadings
+	tableNodes
+	tableGateways
+	tableOther
+)
+
+// pageParser holds the state of parsing a page token by token.
+type pageParser struct {
+	page *Page
+
+	tables int
+	kind   tableKind
+	// row is the number of the current row in its table.
+	row    int
+	header bool
+	cells  []cell
+	rows   []Reading
+
+	// capture is the element whose text is being collected, if any.
+	capture string
+	inLink  bool
+	current cell
+	text    strings.Builder
+	link    strings.Builder
+}
+
+// ParsePage parses a sensors_recent page, including the statistics
+// and datasets shown below the readings.
+func ParsePage(r io.Reader) (*Page, error) {
+	z := html.NewTokenizer(r)
+	p := &pageParser{page: &Page{}}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			p.endTable()
+			return p.page, nil
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "meta":
+				if d, ok := parseRefresh(z, hasAttr); ok {
+					p.page.Refresh = d
+				}
+			case "table":
+				p.endTable()
+				p.tables++
+				p.kind = tableUnknown
+				p.row = 0
+			case "tr":
+				p.cells = p.cells[:0]
+				p.header = false
+				p.row++
+			case "th", "td", "p", "li":
+				if string(name) == "th" {
+					p.header = true
+				}
+				p.startCapture(string(name))
+			case "a":
+				if p.capture == "" || p.current.hasLink {
+					continue
+				}
+				p.current.hasLink = true
+				p.inLink = true
+				p.link.Reset()
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					if string(k) == "href" {
+						p.current.href = string(v)
+						break
+					}
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "table":
+				p.endTable()
+			case "a":
+				if p.inLink {
+					p.current.link = strings.TrimSpace(p.link.String())
+					p.inLink = false
+				}
+			case "th", "td":
+				if p.capture == string(name) {
+					p.cells = append(p.cells, p.endCapture())
+				}
+			case "p":
+				if p.capture == "p" {
+					p.parseParagraph(p.endCapture())
+				}
+			case "li":
+				if p.capture == "li" {
+					p.parseItem(p.endCapture())
+				}
+			case "tr":
+				p.endRow()
+			}
+		case html.TextToken:
+			if p.capture == "" {
+				continue
+			}
+			data := z.Text()
+			p.text.Write(data)
+			if p.inLink {
+				p.link.Write(data)
+			}
+		}
+	}
+}
+
+func (p *pageParser) startCapture(name string) {
+	p.capture = name
+	p.inLink = false
+	p.current = cell{}
+	p.text.Reset()
+}
+
+func (p *pageParser) endCapture() cell {
+	c := p.current
+	c.text = strings.TrimSpace(p.text.String())
+	p.capture = ""
+	p.inLink = false
+	return c
+}
+
+// endTable stores the readings once the readings table is done.
+func (p *pageParser) endTable() {
+	if p.kind == tableReadings || (p.kind == tableUnknown && p.tables == 1) {
+		if p.page.Readings == nil {
+			p.page.Readings = p.rows
+			if p.rows == nil {
+				p.page.Readings = []Reading{}
+			}
+		}
+	}
+	p.kind = tableOther
+}
+
+func (p *pageParser) endRow() {
+	if p.tables == 0 {
+		return
+	}
+	if p.header {
+		if p.kind == tableUnknown && len(p.cells) > 0 {
+			p.kind = classifyTable(p.cells[0].text)
+		}
+		return
+	}
+	if len(p.cells) == 0 {
+		return
+	}
+	if p.kind == tableUnknown {
+		p.kind = tableReadings
+	}
+
+	switch p.kind {
+	case tableReadings:
+		p.readingRow()
+	case tableNodes:
+		if len(p.cells) == 2 {
+			if n, err := strconv.Atoi(p.cells[0].text); err == nil {
+				p.page.Stats.MessagesPerNode = append(p.page.Stats.MessagesPerNode, NodeStats{
+					Messages: n,
+					Sensors:  sensorIDs(p.cells[1].text),
+				})
+			}
+		}
+	case tableGateways:
+		if len(p.cells) == 4 {
+			if g, err := parseGatewayStats(p.cells); err == nil {
+				p.page.Stats.Gateways = append(p.page.Stats.Gateways, g)
+			}
+		}
+	}
+}
+
+func (p *pageParser) readingRow() {
+	if p.rows == nil {
+		p.rows = make([]Reading, 0, 10)
+	}
+	switch len(p.cells) {
+	case 5:
+		g, err := parseGatewayCells(p.cells)
+		if err != nil {
+			p.warn("error parsing gateway: %v", err)
+			return
+		}
+		if len(p.rows) == 0 {
+			p.warn("gateway %s has no reading", g.Name)
+			return
+		}
+		row := p.rows[len(p.rows)-1]
+		row.Gateways = append(row.Gateways, g)
+		p.rows[len(p.rows)-1] = row
+	case 17:
+		row, err := parseReadingCells(p.cells)
+		if err != nil {
+			p.warn("error parsing reading: %v", err)
+			return
+		}
+		p.rows = append(p.rows, *row)
+	default:
+		p.warn("unexpected number of cells: %d", len(p.cells))
+	}
+}
+
+// warn records a problem with the current row of the readings table.
+func (p *pageParser) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf("row %d: %s", p.row, fmt.Sprintf(format, args...))
+	p.page.Warnings = append(p.page.Warnings, msg)
+}
+
+func classifyTable(header string) tableKind {
+	switch {
+	case header == "ID":
+		return tableReadings
+	case strings.HasPrefix(header, "Number of messages"):
+		return tableNodes
+	case header == "Gateway":
+		return tableGateways
+	}
+	return tableOther
+}
+
+// parseParagraph reads the message and node counts below the readings.
+func (p *pageParser) parseParagraph(c cell) {
+	parts := strings.SplitN(c.text, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return
+	}
+	switch strings.TrimSpace(parts[0]) {
+	case "Message count":
+		p.page.Stats.MessageCount = n
+	case "Node count":
+		p.page.Stats.NodeCount = n
+	}
+}
+
+// parseItem reads a dataset link like <li><a href="?sensors=1-14">Amersfoort</a></li>.
+func (p *pageParser) parseItem(c cell) {
+	if !c.hasLink || c.link == "" {
+		return
+	}
+	u, err := url.Parse(c.href)
+	if err != nil {
+		return
+	}
+	ids, err := ParseSensors(u.Query().Get("sensors"))
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	p.page.Datasets = append(p.page.Datasets, Dataset{Name: c.link, Sensors: ids})
+}
+
+// sensorDistance matches a sensor ID optionally followed by its distance, like "242 (5.587km)".
+var sensorDistance = regexp.MustCompile(`(\d+)(?:\s*\(([0-9.]+)km\))?`)
+
+// sensorIDs returns the sensor IDs in a cell. Some pages show
+// the links as escaped markup, so tags are removed from the text first.
+func sensorIDs(text string) []string {
+	var ids []string
+	for _, m := range sensorDistance.FindAllStringSubmatch(stripTags(text), -1) {
+		ids = append(ids, m[1])
+	}
+	return ids
+}
+
+func parseGatewayStats(c []cell) (GatewayStats, error) {
+	var g GatewayStats
+	name := c[0].text
+	if i := strings.Index(name, " ("); i != -1 {
+		name = name[:i]
+	}
+	g.Name = strings.TrimSpace(name)
+
+	var err error
+	if g.Messages, err = strconv.Atoi(c[1].text); err != nil {
+		return g, err
+	}
+	if g.Nodes, err = strconv.Atoi(c[2].text); err != nil {
+		return g, err
+	}
+	for _, m := range sensorDistance.FindAllStringSubmatch(stripTags(c[3].text), -1) {
+		s := GatewaySensor{SensorID: m[1]}
+		if m[2] != "" {
+			d, err := strconv.ParseFloat(m[2], 32)
+			if err != nil {
+				return g, err
+			}
+			s.Distance = float32(d)
+		}
+		g.Sensors = append(g.Sensors, s)
+	}
+	return g, nil
+}
+
+func stripTags(s string) string {
+	var b strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// parseRefresh reads the interval of a <meta http-equiv="refresh"> tag.
+func parseRefresh(z *html.Tokenizer, hasAttr bool) (time.Duration, bool) {
+	var equiv, content string
+	for hasAttr {
+		var k, v []byte
+		k, v, hasAttr = z.TagAttr()
+		switch string(k) {
+		case "http-equiv":
+			equiv = string(v)
+		case "content":
+			content = string(v)
+		}
+	}
+	if !strings.EqualFold(equiv, "refresh") {
+		return 0, false
+	}
+	if i := strings.Index(content, ";"); i != -1 {
+		content = content[:i]
+	}
+	secs, err := strconv.Atoi(strings.TrimSpace(content))
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+func parseReadingCells(c []cell) (*Reading, error) {
+	var r Reading
+
+	r.SensorID = c[0].text
+
+	t, err := time.Parse(TimestampLayout, c[1].text)
+	if err != nil {
+		return nil, err
+	}
+	r.Date = t
+	r.Time = t.Unix()
+
+	if r.Temp, err = parseUnit(c[2].text, "°C"); err != nil {
+		return nil, err
+	}
+	if r.Humidity, err = parseUnit(c[3].text, "%"); err != nil {
+		return nil, err
+	}
+	if r.Light, err = parseOptional(c[4].text); err != nil {
+		return nil, err
+	}
+	if r.PM25, err = parseOptional(c[5].text); err != nil {
+		return nil, err
+	}
+	if r.PM10, err = parseOptional(c[6].text); err != nil {
+		return nil, err
+	}
+	if r.Voltage, err = parseUnit(c[7].text, "V"); err != nil {
+		return nil, err
+	}
+
+	r.Firmware = c[9].text
+
+	pos, err := parsePositionCell(c[10])
+	if err != nil {
+		return nil, err
+	}
+	r.Position = pos
+
+	fcnt, err := strconv.Atoi(c[11].text)
+	if err != nil {
+		return nil, err
+	}
+	r.Fcnt = fcnt
+
+	g, err := parseGatewayCells(c[12:])
+	if err != nil {
+		return nil, err
+	}
+	r.Gateways = []Gateway{g}
+
+	return &r, nil
+}
+
+func parseGatewayCells(c []cell) (Gateway, error) {
+	var g Gateway
+
+	if c[0].hasLink {
+		pos, _ := extractPositionFromURL(c[0].href)
+		g.Position = pos
+		g.Name = c[0].link
+	}
+
+	if data := c[1].text; len(data) > 2 {
+		dist, err := parseUnit(data, "km")
+		if err != nil {
+			return g, err
+		}
+		g.Distance = dist
+	}
+
+	rssi, err := strconv.ParseFloat(c[2].text, 32)
+	if err != nil {
+		return g, err
+	}
+	g.RSSI = float32(rssi)
+
+	lsnr, err := strconv.ParseFloat(c[3].text, 32)
+	if err != nil {
+		return g, err
+	}
+	g.LSNR = float32(lsnr)
+
+	s, err := parseRadioSettings(c[4].text)
+	if err != nil {
+		return g, err
+	}
+	g.RadioSettings = s
+
+	return g, nil
+}
+
+func parsePositionCell(c cell) (Position, error) {
+	if !c.hasLink {
+		return Position{}, nil
+	}
+	parts := strings.Fields(c.link)
+	if len(parts) == 0 {
+		return Position{}, nil
+	}
+	lat, err := strconv.ParseFloat(parts[0], 32)
+	if err != nil {
+		return Position{}, err
+	}
+	lng, err := strconv.ParseFloat(parts[len(parts)-1], 32)
+	if err != nil {
+		return Position{}, err
+	}
+	return Position{Lat: float32(lat), Lng: float32(lng)}, nil
+}
+
+func parseRadioSettings(data string) (RadioSettings, error) {
+	parts := strings.Split(data, ",")
+	if len(parts) != 3 {
+		return RadioSettings{}, fmt.Errorf("unexpected radio settings '%s'", data)
+	}
+	freq, err := parseUnit(strings.TrimSpace(parts[0]), "Mhz")
+	if err != nil {
+		return RadioSettings{}, err
+	}
+	return RadioSettings{
+		Frequency: freq,
+		Sf:        strings.TrimSpace(parts[1]),
+		Cr:        strings.TrimSpace(parts[2]),
+	}, nil
+}
+
+// parseUnit parses a number followed by the given unit, like "3.37V".
+func parseUnit(data, unit string) (float32, error) {
+	v := strings.TrimSuffix(data, unit)
+	f, err := strconv.ParseFloat(v, 32)
+	if err != nil {
+		return 0, err
+	}
+	return float32(f), nil
+}
+
+// parseOptional parses a number that may be left out.
+func parseOptional(data string) (float32, error) {
+	if data == "" {
+		return 0, nil
+	}
+	return parseUnit(data, "")
+}
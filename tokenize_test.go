@@ -0,0 +1,262 @@
+package scrapejestad
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseTokensMatchesTree(t *testing.T) {
+	for _, name := range []string{"testdata/example.html", "testdata/missing_data.html"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("failed to open testdata: %v", err)
+		}
+		want, err := parseTree(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("error parsing %s with tree parser: %v", name, err)
+		}
+		got, err := parseTokens(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("error parsing %s with tokenizer: %v", name, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s not equal: %v", name, diff)
+		}
+	}
+}
+
+func Test_parsePositionWhitespace(t *testing.T) {
+	data, err := os.ReadFile("testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	data = bytes.Replace(data, []byte("60.4309 / 5.23251"), []byte("60.4309\n\t/  5.23251"), -1)
+	got, err := parseTokens(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+	want := Position{Lat: 60.4309, Lng: 5.23251}
+	if len(got) == 0 || got[0].Position != want {
+		t.Errorf("expected position %v, got %v", want, got)
+	}
+}
+
+func Test_parseTokensGeneratedPage(t *testing.T) {
+	page := generatePage(500)
+	want, err := parseTree(bytes.NewReader(page))
+	if err != nil {
+		t.Fatalf("error parsing with tree parser: %v", err)
+	}
+	got, err := parseTokens(bytes.NewReader(page))
+	if err != nil {
+		t.Fatalf("error parsing with tokenizer: %v", err)
+	}
+	if len(got) != 500 {
+		t.Errorf("expected 500 readings, got %d", len(got))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("not equal: %v", diff)
+	}
+}
+
+func Test_parseTokensNoTable(t *testing.T) {
+	res, err := parseTokens(bytes.NewBufferString("<html><body><p>nothing</p></body></html>"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res != nil {
+		t.Errorf("expected no readings, got %d", len(res))
+	}
+}
+
+// generatePage returns a sensors_recent page with n readings,
+// each received by two gateways.
+func generatePage(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<!DOCTYPE html>
+<html class="no-js">
+	<head>
+		<meta http-equiv="refresh" content="60">
+	</head>
+	<body>
+		<table border="1">
+			<tr><th>ID</th><th>Time</th><th>Temp</th><th>Humidity</th><th>Light</th><th>PM2.5</th><th>PM10</th><th>Voltage</th><th>Extra</th><th>Firmware</th><th>Position</th><th>Fcnt</th><th>Gateways</th><th>Distance</th><th>RSSI</th><th>LSNR</th><th>Radiosettings</th></tr>
+`)
+	start := time.Date(2019, 12, 5, 21, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id := 200 + i%50
+		writeGeneratedRow(&b, id, start.Add(-time.Duration(i)*time.Minute), i)
+	}
+	b.WriteString(`		</table>
+	</body>
+</html>`)
+	return b.Bytes()
+}
+
+func writeGeneratedRow(w io.Writer, id int, t time.Time, i int) {
+	fmt.Fprintf(w, `<tr>
+  <td rowspan="2"> <a href="?sensors=%d&amp;limit=50">%d</a></td>
+  <td rowspan="2"> %s</td>
+  <td rowspan="2"> %.3f°C</td>
+  <td rowspan="2"> %.2f%%</td>
+  <td rowspan="2"> </td>
+  <td rowspan="2"> </td>
+  <td rowspan="2"> </td>
+  <td rowspan="2"> 3.37V</td>
+  <td rowspan="2"> </td>
+  <td rowspan="2"> v2</td>
+  <td rowspan="2"> <a href="http://www.openstreetmap.org/?mlat=60.4309&amp;mlon=5.23251">60.4309 / 5.23251</a></td>
+  <td rowspan="2"> %d</td>
+  <td><a href="http://www.openstreetmap.org/?mlat=60.431778&amp;mlon=5.231865">florvaag-1</a></td>
+  <td>0.104km</td>
+  <td>-%d</td>
+  <td>9.5</td>
+  <td>868.5Mhz, SF9BW125, 4/5CR</td>
+</tr>
+<tr>
+  <td><a href="http://www.openstreetmap.org/?mlat=60.41283&amp;mlon=5.327483">eui-00f142122877fa05</a></td>
+  <td>5.587km</td>
+  <td>-117</td>
+  <td>-1</td>
+  <td>868.5Mhz, SF9BW125, 4/5CR</td>
+</tr>
+`, id, id, t.Format("2006-01-02 15:04:05"), float64(i%300)/10, 40+float64(i%600)/10, i, 40+i%60)
+}
+
+func benchmarkParser(b *testing.B, rows int, parser func(io.Reader) ([]Reading, error)) {
+	page := generatePage(rows)
+	b.SetBytes(int64(len(page)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res, err := parser(bytes.NewReader(page))
+		if err != nil {
+			b.Fatalf("error parsing page: %v", err)
+		}
+		if len(res) != rows {
+			b.Fatalf("expected %d readings, got %d", rows, len(res))
+		}
+	}
+}
+
+func BenchmarkParseTree10k(b *testing.B)   { benchmarkParser(b, 10000, parseTree) }
+func BenchmarkParseTokens10k(b *testing.B) { benchmarkParser(b, 10000, parseTokens) }
+func BenchmarkParseTree50k(b *testing.B)   { benchmarkParser(b, 50000, parseTree) }
+func BenchmarkParseTokens50k(b *testing.B) { benchmarkParser(b, 50000, parseTokens) }
+
+func Test_parsePageStats(t *testing.T) {
+	f, err := os.Open("testdata/example.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	defer f.Close()
+	p, err := ParsePage(f)
+	if err != nil {
+		t.Fatalf("error parsing testdata: %v", err)
+	}
+
+	if p.Refresh != time.Minute {
+		t.Errorf("expected refresh of 1m, got %v", p.Refresh)
+	}
+	if p.Stats.MessageCount != 20 || p.Stats.NodeCount != 1 {
+		t.Errorf("expected 20 messages from 1 node, got %d from %d", p.Stats.MessageCount, p.Stats.NodeCount)
+	}
+	if diff := cmp.Diff([]NodeStats{{Messages: 20, Sensors: []string{"242"}}}, p.Stats.MessagesPerNode); diff != "" {
+		t.Errorf("messages per node not equal: %v", diff)
+	}
+	if len(p.Stats.Gateways) != 9 {
+		t.Fatalf("expected 9 gateways, got %d", len(p.Stats.Gateways))
+	}
+	want := GatewayStats{
+		Name:     "eui-00f142122877fa05",
+		Messages: 38,
+		Nodes:    1,
+		Sensors:  []GatewaySensor{{SensorID: "242", Distance: 5.587}},
+	}
+	if diff := cmp.Diff(want, p.Stats.Gateways[0]); diff != "" {
+		t.Errorf("gateway not equal: %v", diff)
+	}
+	if len(p.Datasets) != 7 {
+		t.Fatalf("expected 7 datasets, got %d", len(p.Datasets))
+	}
+	if d := p.Datasets[4]; d.Name != "Apeldoorn" || len(d.Sensors) != 16 || d.Sensors[0] != 124 {
+		t.Errorf("unexpected dataset %+v", d)
+	}
+}
+
+func Test_parsePageStatsWithLinks(t *testing.T) {
+	f, err := os.Open("testdata/missing_data.html")
+	if err != nil {
+		t.Fatalf("failed to open testdata: %v", err)
+	}
+	defer f.Close()
+	p, err := ParsePage(f)
+	if err != nil {
+		t.Fatalf("error parsing testdata: %v", err)
+	}
+	want := []GatewayStats{
+		{Name: "fana-bergen-gateway-01", Messages: 3, Nodes: 1, Sensors: []GatewaySensor{{SensorID: "372", Distance: 0.017}}},
+		{Name: "mjs-bergen-gateway-5", Messages: 3, Nodes: 1, Sensors: []GatewaySensor{{SensorID: "372", Distance: 1.566}}},
+		{Name: "mjs-bergen-gateway-3", Messages: 1, Nodes: 1, Sensors: []GatewaySensor{{SensorID: "372"}}},
+		{Name: "mjs-bergen-gateway-1", Messages: 1, Nodes: 1, Sensors: []GatewaySensor{{SensorID: "372", Distance: 3.896}}},
+		{Name: "mjs-bergen-gateway-2", Messages: 1, Nodes: 1, Sensors: []GatewaySensor{{SensorID: "372", Distance: 1.696}}},
+	}
+	if diff := cmp.Diff(want, p.Stats.Gateways); diff != "" {
+		t.Errorf("gateways not equal: %v", diff)
+	}
+}
+
+// malformed are documents that used to make the parsers panic.
+var malformed = []string{
+	"<table>",
+	"<table></table>",
+	"<table><tr><td></td></tr></table>",
+	"<table><tr><th></th></tr><tr><td>1</td><td>2</td><td>3</td><td>4</td><td>5</td></tr></table>",
+	"<table><tr>" + strings.Repeat("<td></td>", 17) + "</tr></table>",
+	"<table><tr>" + strings.Repeat("<td/>", 17) + "</tr></table>",
+	"<table><tr><td><a></a></td><td>-</td><td>1</td><td>1</td><td>868.5Mhz</td></tr></table>",
+	`<table><tr><td><a href="?mlat">g</a></td><td>1km</td><td>1</td><td>1</td><td>1Mhz,a,b</td></tr></table>`,
+	`<table><tbody><tr><td><a>1</a></td><td>2019-12-05 21:19:33</td><td>1°C</td><td>1%</td><td></td><td></td><td></td><td>3V</td><td></td><td>v2</td><td><a></a></td><td>1</td><td><a href="x?mlon=">g</a></td><td>-</td><td>1</td><td>1</td><td>Mhz,,</td></tr></tbody></table>`,
+}
+
+func Test_parseMalformed(t *testing.T) {
+	for i, doc := range malformed {
+		if _, err := parseTree(strings.NewReader(doc)); err != nil {
+			t.Errorf("%d: unexpected error from tree parser: %v", i, err)
+		}
+		if _, err := ParsePage(strings.NewReader(doc)); err != nil {
+			t.Errorf("%d: unexpected error from tokenizer: %v", i, err)
+		}
+	}
+}
+
+func Test_parseWarnings(t *testing.T) {
+	doc := `<table>
+<tr><th>ID</th></tr>
+<tr><td><a>g</a></td><td>-</td><td>1</td><td>1</td><td>868.5Mhz, SF9BW125, 4/5CR</td></tr>
+<tr><td>1</td><td>2</td></tr>
+<tr>` + strings.Repeat("<td>x</td>", 17) + `</tr>
+</table>`
+	p, err := ParsePage(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(p.Readings) != 0 {
+		t.Errorf("expected no readings, got %d", len(p.Readings))
+	}
+	want := []string{
+		"row 2: gateway g has no reading",
+		"row 3: unexpected number of cells: 2",
+		`row 4: error parsing reading: parsing time "x" as "2006-01-02 15:04:05": cannot parse "x" as "2006"`,
+	}
+	if diff := cmp.Diff(want, p.Warnings); diff != "" {
+		t.Errorf("warnings not equal: %v", diff)
+	}
+}
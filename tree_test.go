@@ -0,0 +1,255 @@
+package scrapejestad
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// parseTree parses a document by building the full node tree
+// and walking it. It is kept as a reference for parseTokens in
+// tests and benchmarks, and skips rows it cannot parse.
+func parseTree(r io.Reader) ([]Reading, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSubtree(doc), nil
+}
+
+func parseSubtree(n *html.Node) []Reading {
+	if n.Type == html.ElementNode && n.Data == "table" {
+		return parseTable(n)
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if res := parseSubtree(c); res != nil {
+			return res
+		}
+	}
+	return nil
+}
+
+func parseTable(t *html.Node) []Reading {
+	rows := make([]Reading, 0, 10)
+	for _, c := range tableRows(t) {
+		nodes := mapRow(c)
+		switch len(nodes) {
+		case 0:
+			continue
+		case 5:
+			g, err := parseGateway(nodes)
+			if err != nil || len(rows) == 0 {
+				continue
+			}
+			row := rows[len(rows)-1]
+			row.Gateways = append(row.Gateways, g)
+			rows[len(rows)-1] = row
+		case 17:
+			row, err := parseRow(nodes)
+			if err != nil {
+				continue
+			}
+			rows = append(rows, *row)
+		}
+	}
+	return rows
+}
+
+// tableRows returns the rows of a table, looking inside tbody and friends.
+func tableRows(t *html.Node) []*html.Node {
+	var rows []*html.Node
+	for c := t.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "tr":
+			rows = append(rows, c)
+		case "thead", "tbody", "tfoot":
+			rows = append(rows, tableRows(c)...)
+		}
+	}
+	return rows
+}
+
+func parseRow(n []*html.Node) (*Reading, error) {
+	var r Reading
+
+	r.SensorID = getID(n[0])
+
+	t, err := time.Parse(TimestampLayout, text(n[1]))
+	if err != nil {
+		return nil, err
+	}
+	r.Date = t
+	r.Time = t.Unix()
+
+	if r.Temp, err = parseUnit(text(n[2]), "°C"); err != nil {
+		return nil, err
+	}
+	if r.Humidity, err = parseUnit(text(n[3]), "%"); err != nil {
+		return nil, err
+	}
+	if r.Light, err = parseOptional(text(n[4])); err != nil {
+		return nil, err
+	}
+	if r.PM25, err = parseOptional(text(n[5])); err != nil {
+		return nil, err
+	}
+	if r.PM10, err = parseOptional(text(n[6])); err != nil {
+		return nil, err
+	}
+	if r.Voltage, err = parseUnit(text(n[7]), "V"); err != nil {
+		return nil, err
+	}
+
+	r.Firmware = text(n[9])
+
+	pos, err := parsePosition(n[10])
+	if err != nil {
+		return nil, err
+	}
+	r.Position = pos
+
+	fcnt, err := strconv.Atoi(text(n[11]))
+	if err != nil {
+		return nil, err
+	}
+	r.Fcnt = fcnt
+
+	g, err := parseGateway(n[12:])
+	if err != nil {
+		return nil, err
+	}
+	r.Gateways = []Gateway{g}
+
+	return &r, nil
+}
+
+func parseGateway(n []*html.Node) (Gateway, error) {
+	var g Gateway
+
+	if a := findLink(n[0]); a != nil {
+		pos, _ := extractPositionFromURL(getHref(a))
+		g.Position = pos
+		g.Name = text(a)
+	}
+
+	if data := text(n[1]); len(data) > 2 {
+		dist, err := parseUnit(data, "km")
+		if err != nil {
+			return g, err
+		}
+		g.Distance = dist
+	}
+
+	rssi, err := strconv.ParseFloat(text(n[2]), 32)
+	if err != nil {
+		return g, err
+	}
+	g.RSSI = float32(rssi)
+
+	lsnr, err := strconv.ParseFloat(text(n[3]), 32)
+	if err != nil {
+		return g, err
+	}
+	g.LSNR = float32(lsnr)
+
+	s, err := parseRadioSettings(text(n[4]))
+	if err != nil {
+		return g, err
+	}
+	g.RadioSettings = s
+
+	return g, nil
+}
+
+// mapRow returns the data cells of a row, or none for a header row.
+func mapRow(n *html.Node) []*html.Node {
+	res := make([]*html.Node, 0, 5)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		switch c.Data {
+		case "th":
+			return res[:0]
+		case "td":
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// text returns the trimmed text content of a node.
+func text(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.TrimSpace(b.String())
+}
+
+// findLink returns the first link below n, if any.
+func findLink(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "a" {
+			return c
+		}
+		if a := findLink(c); a != nil {
+			return a
+		}
+	}
+	return nil
+}
+
+func getID(n *html.Node) string {
+	if a := findLink(n); a != nil {
+		if id := text(a); id != "" {
+			return id
+		}
+	}
+	return text(n)
+}
+
+func parsePosition(n *html.Node) (Position, error) {
+	a := findLink(n)
+	if a == nil {
+		return Position{}, nil
+	}
+
+	parts := strings.Fields(text(a))
+	if len(parts) == 0 {
+		return Position{}, nil
+	}
+	lat, err := strconv.ParseFloat(parts[0], 32)
+	if err != nil {
+		return Position{}, err
+	}
+	lng, err := strconv.ParseFloat(parts[len(parts)-1], 32)
+	if err != nil {
+		return Position{}, err
+	}
+	return Position{Lat: float32(lat), Lng: float32(lng)}, nil
+}
+
+func getHref(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "href" {
+			return a.Val
+		}
+	}
+	return ""
+}
@@ -18,6 +18,54 @@ type JsonReading struct {
 	Supply          float32 `json:"supply"`
 }
 
+// Page is the parsed content of a sensors_recent page.
+type Page struct {
+	Readings []Reading `json:"readings"`
+	// Refresh is the reload interval the page asks browsers to use.
+	Refresh  time.Duration `json:"refresh"`
+	Stats    Stats         `json:"stats"`
+	Datasets []Dataset     `json:"datasets"`
+	// Warnings describes the rows of the readings table that were skipped.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Stats holds the statistics shown below the readings on a page.
+type Stats struct {
+	MessageCount    int            `json:"message_count"`
+	NodeCount       int            `json:"node_count"`
+	MessagesPerNode []NodeStats    `json:"messages_per_node"`
+	Gateways        []GatewayStats `json:"gateways"`
+}
+
+// NodeStats is the number of messages sent by a group of sensors.
+type NodeStats struct {
+	Messages int      `json:"messages"`
+	Sensors  []string `json:"sensors"`
+}
+
+// GatewayStats is the number of messages received by a gateway.
+type GatewayStats struct {
+	Name     string          `json:"name"`
+	Messages int             `json:"messages"`
+	Nodes    int             `json:"nodes"`
+	Sensors  []GatewaySensor `json:"sensors"`
+}
+
+// GatewaySensor is a sensor heard by a gateway and its distance in km, if known.
+type GatewaySensor struct {
+	SensorID string  `json:"sensor_id"`
+	Distance float32 `json:"distance"`
+}
+
+// Dataset is a named group of sensors, like the sensors of a city.
+type Dataset struct {
+	Name    string `json:"name"`
+	Sensors []int  `json:"sensors"`
+}
+
+// TimestampLayout is the time format used by meetjestad.net.
+const TimestampLayout = "2006-01-02 15:04:05"
+
 // Reading represents one unique data point.
 type Reading struct {
 	SensorID string    `json:"sensor_id"`
@@ -33,6 +81,61 @@ type Reading struct {
 	Position Position  `json:"coordinates"`
 	Fcnt     int       `json:"fcnt"`
 	Gateways []Gateway `json:"gateways"`
+	// Extra holds values kept along with the measurements by name,
+	// like the dew point computed by the meteo package.
+	Extra map[string]float64 `json:"extra,omitempty"`
+}
+
+// Field names a measurement of a Reading, using its JSON name.
+type Field string
+
+// The measurements of a Reading.
+const (
+	FieldTemperature Field = "temperature"
+	FieldHumidity    Field = "humidity"
+	FieldLight       Field = "light"
+	FieldPM25        Field = "pm25"
+	FieldPM10        Field = "pm10"
+	FieldVoltage     Field = "voltage"
+)
+
+// Fields lists the measurements of a Reading.
+var Fields = []Field{FieldTemperature, FieldHumidity, FieldLight, FieldPM25, FieldPM10, FieldVoltage}
+
+// OptionalField reports whether meetjestad.net leaves the measurement
+// out for sensors that don't have it, in which case it is zero.
+func OptionalField(f Field) bool {
+	return f == FieldLight || f == FieldPM25 || f == FieldPM10
+}
+
+// ParseField returns the Field with the given name.
+func ParseField(s string) (Field, error) {
+	for _, f := range Fields {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown field '%s'", s)
+}
+
+// Value returns the value of a measurement.
+// It returns false if the field is unknown.
+func (r Reading) Value(f Field) (float64, bool) {
+	switch f {
+	case FieldTemperature:
+		return float64(r.Temp), true
+	case FieldHumidity:
+		return float64(r.Humidity), true
+	case FieldLight:
+		return float64(r.Light), true
+	case FieldPM25:
+		return float64(r.PM25), true
+	case FieldPM10:
+		return float64(r.PM10), true
+	case FieldVoltage:
+		return float64(r.Voltage), true
+	}
+	return 0, false
 }
 
 // String returns a string representation of a Reading.
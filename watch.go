@@ -0,0 +1,189 @@
+package scrapejestad
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fetcher fetches the page matching a query. It is implemented by Client.
+type Fetcher interface {
+	Fetch(ctx context.Context, q Query) (*Result, error)
+}
+
+// Watcher polls a query and reports readings it has not seen before.
+type Watcher struct {
+	fetcher      Fetcher
+	query        Query
+	interval     time.Duration
+	maxBackoff   time.Duration
+	clock        Clock
+	onError      func(error)
+	skipExisting bool
+
+	mu   sync.Mutex
+	last map[string]cursor
+}
+
+// cursor is the newest reading seen for a sensor.
+type cursor struct {
+	date time.Time
+	fcnt int
+}
+
+// WatcherOption configures a Watcher.
+type WatcherOption func(*Watcher)
+
+// WatchInterval sets how often the query is polled. The default is
+// one minute, which is how often meetjestad.net refreshes the page.
+// Intervals that aren't positive are ignored.
+func WatchInterval(d time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		if d > 0 {
+			w.interval = d
+		}
+	}
+}
+
+// WatchMaxBackoff sets the longest delay between polls after errors.
+func WatchMaxBackoff(d time.Duration) WatcherOption {
+	return func(w *Watcher) {
+		w.maxBackoff = d
+	}
+}
+
+// WatchClock sets the clock used to wait between polls.
+func WatchClock(c Clock) WatcherOption {
+	return func(w *Watcher) {
+		w.clock = c
+	}
+}
+
+// WatchErrorHandler sets a function that is called with errors from polling.
+func WatchErrorHandler(fn func(error)) WatcherOption {
+	return func(w *Watcher) {
+		w.onError = fn
+	}
+}
+
+// WatchSkipExisting makes the watcher drop the readings found by the
+// first poll and only report readings arriving after that.
+func WatchSkipExisting() WatcherOption {
+	return func(w *Watcher) {
+		w.skipExisting = true
+	}
+}
+
+// NewWatcher returns a watcher polling q using f.
+func NewWatcher(f Fetcher, q Query, opts ...WatcherOption) *Watcher {
+	w := &Watcher{
+		fetcher:    f,
+		query:      q,
+		interval:   time.Minute,
+		maxBackoff: 10 * time.Minute,
+		clock:      SystemClock,
+		onError:    func(error) {},
+		last:       make(map[string]cursor),
+	}
+	for _, o := range opts {
+		o(w)
+	}
+	return w
+}
+
+// Watch polls until ctx is cancelled and sends new readings on the
+// returned channel, which is closed when the watcher stops.
+func (w *Watcher) Watch(ctx context.Context) <-chan Reading {
+	ch := make(chan Reading)
+	go func() {
+		defer close(ch)
+		w.Run(ctx, func(r Reading) {
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return ch
+}
+
+// Run polls until ctx is cancelled and calls fn for every new reading,
+// oldest first. It always returns the error of ctx.
+func (w *Watcher) Run(ctx context.Context, fn func(Reading)) error {
+	failures := 0
+	first := true
+	for {
+		delay := w.interval
+		err := w.poll(ctx, fn, first && w.skipExisting)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil:
+			failures++
+			delay = w.backoff(failures)
+			w.onError(err)
+		default:
+			failures = 0
+			first = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.clock.After(delay):
+		}
+	}
+}
+
+// backoff doubles the interval for every consecutive failure.
+func (w *Watcher) backoff(failures int) time.Duration {
+	d := w.interval
+	for i := 0; i < failures && d < w.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > w.maxBackoff {
+		d = w.maxBackoff
+	}
+	return d
+}
+
+func (w *Watcher) poll(ctx context.Context, fn func(Reading), skip bool) error {
+	res, err := w.fetcher.Fetch(ctx, w.query)
+	if err != nil {
+		return err
+	}
+	for _, r := range w.filter(res.Readings) {
+		if !skip {
+			fn(r)
+		}
+	}
+	return nil
+}
+
+// filter returns the readings that are newer than the last reading
+// seen for their sensor, oldest first, and records them as seen.
+func (w *Watcher) filter(readings []Reading) []Reading {
+	sorted := make([]Reading, len(readings))
+	copy(sorted, readings)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Date.Equal(sorted[j].Date) {
+			return sorted[i].Fcnt < sorted[j].Fcnt
+		}
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var res []Reading
+	for _, r := range sorted {
+		c, ok := w.last[r.SensorID]
+		if ok && !r.Date.After(c.date) && !(r.Date.Equal(c.date) && r.Fcnt > c.fcnt) {
+			continue
+		}
+		w.last[r.SensorID] = cursor{date: r.Date, fcnt: r.Fcnt}
+		res = append(res, r)
+	}
+	return res
+}
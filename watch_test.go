@@ -0,0 +1,185 @@
+package scrapejestad
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock reports every wait on sleeps and only wakes up when tick is called.
+type fakeClock struct {
+	sleeps chan time.Duration
+	wake   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{sleeps: make(chan time.Duration, 10), wake: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Date(2019, 12, 5, 21, 0, 0, 0, time.UTC)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.sleeps <- d
+	return c.wake
+}
+
+// tick waits for the watcher to sleep, wakes it up and returns the requested delay.
+func (c *fakeClock) tick(t *testing.T) time.Duration {
+	select {
+	case d := <-c.sleeps:
+		c.wake <- c.Now()
+		return d
+	case <-time.After(time.Second):
+		t.Fatalf("watcher did not sleep")
+		return 0
+	}
+}
+
+// scriptedFetcher returns the next result or error on every call.
+type scriptedFetcher struct {
+	mu    sync.Mutex
+	steps []interface{}
+}
+
+func (f *scriptedFetcher) Fetch(ctx context.Context, q Query) (*Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.steps) == 0 {
+		return &Result{}, nil
+	}
+	step := f.steps[0]
+	f.steps = f.steps[1:]
+	switch s := step.(type) {
+	case error:
+		return nil, s
+	default:
+		return &Result{Page: Page{Readings: s.([]Reading)}}, nil
+	}
+}
+
+func reading(id string, d string, fcnt int) Reading {
+	return Reading{SensorID: id, Date: mkdate(d), Time: mktime(d), Fcnt: fcnt}
+}
+
+func receive(t *testing.T, ch <-chan Reading) Reading {
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(time.Second):
+		t.Fatalf("no reading received")
+		return Reading{}
+	}
+}
+
+func Test_watcherEmitsNewReadings(t *testing.T) {
+	f := &scriptedFetcher{steps: []interface{}{
+		[]Reading{
+			reading("242", "2019-12-05 21:19:33", 28357),
+			reading("242", "2019-12-05 21:02:39", 28356),
+		},
+		[]Reading{
+			reading("243", "2019-12-05 21:20:00", 10),
+			reading("242", "2019-12-05 21:19:33", 28357),
+			reading("242", "2019-12-05 21:02:39", 28356),
+		},
+		errors.New("upstream down"),
+		errors.New("upstream down"),
+		[]Reading{
+			reading("242", "2019-12-05 21:36:00", 28358),
+			reading("243", "2019-12-05 21:20:00", 10),
+		},
+	}}
+	clock := newFakeClock()
+	var errs []error
+	w := NewWatcher(f, Query{}, WatchClock(clock), WatchErrorHandler(func(err error) {
+		errs = append(errs, err)
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := w.Watch(ctx)
+
+	if r := receive(t, ch); r.Fcnt != 28356 {
+		t.Errorf("expected oldest reading first, got fcnt %d", r.Fcnt)
+	}
+	if r := receive(t, ch); r.Fcnt != 28357 {
+		t.Errorf("expected fcnt 28357, got %d", r.Fcnt)
+	}
+	if d := clock.tick(t); d != time.Minute {
+		t.Errorf("expected to wait 1m, waited %v", d)
+	}
+
+	if r := receive(t, ch); r.SensorID != "243" {
+		t.Errorf("expected only the reading of sensor 243, got %s", r.SensorID)
+	}
+	clock.tick(t)
+
+	if d := clock.tick(t); d != 2*time.Minute {
+		t.Errorf("expected to back off to 2m, waited %v", d)
+	}
+	if d := clock.tick(t); d != 4*time.Minute {
+		t.Errorf("expected to back off to 4m, waited %v", d)
+	}
+
+	if r := receive(t, ch); r.Fcnt != 28358 {
+		t.Errorf("expected fcnt 28358, got %d", r.Fcnt)
+	}
+	if d := clock.tick(t); d != time.Minute {
+		t.Errorf("expected backoff to reset, waited %v", d)
+	}
+
+	cancel()
+	for range ch {
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors, got %d", len(errs))
+	}
+}
+
+func Test_watcherSkipExisting(t *testing.T) {
+	f := &scriptedFetcher{steps: []interface{}{
+		[]Reading{reading("242", "2019-12-05 21:19:33", 28357)},
+		[]Reading{
+			reading("242", "2019-12-05 21:36:00", 28358),
+			reading("242", "2019-12-05 21:19:33", 28357),
+		},
+	}}
+	clock := newFakeClock()
+	var got []Reading
+	w := NewWatcher(f, Query{}, WatchClock(clock), WatchSkipExisting())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.Run(ctx, func(r Reading) { got = append(got, r) })
+	}()
+	clock.tick(t)
+	clock.tick(t)
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if len(got) != 1 || got[0].Fcnt != 28358 {
+		t.Errorf("expected only fcnt 28358, got %v", got)
+	}
+}
+
+func Test_watcherBackoffIsCapped(t *testing.T) {
+	w := NewWatcher(nil, Query{}, WatchInterval(time.Minute), WatchMaxBackoff(5*time.Minute))
+	if d := w.backoff(10); d != 5*time.Minute {
+		t.Errorf("expected 5m, got %v", d)
+	}
+}
+
+func Test_watcherInvalidInterval(t *testing.T) {
+	for _, d := range []time.Duration{0, -time.Minute} {
+		w := NewWatcher(nil, Query{}, WatchInterval(d))
+		if w.interval != time.Minute || w.backoff(1) != 2*time.Minute {
+			t.Errorf("expected an interval of %v to be ignored, got %v", d, w.interval)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Package webhook delivers alert events and readings as JSON
+// to HTTP endpoints such as chat and incident tools.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/alerts"
+)
+
+// Headers set on every delivery.
+const (
+	SignatureHeader = "X-Scrapejestad-Signature"
+	EventHeader     = "X-Scrapejestad-Event"
+)
+
+// The kinds of payloads.
+const (
+	KindAlert    = "alert"
+	KindReadings = "readings"
+)
+
+// Payload is the data sent to a webhook, and what body templates are executed with.
+type Payload struct {
+	Kind     string                 `json:"kind"`
+	SentAt   time.Time              `json:"sent_at"`
+	Alert    *alerts.Event          `json:"alert,omitempty"`
+	Readings []scrapejestad.Reading `json:"readings,omitempty"`
+}
+
+// Notifier POSTs payloads to a set of URLs.
+// It implements alerts.Notifier.
+type Notifier struct {
+	urls        []string
+	secret      []byte
+	tmpl        *template.Template
+	contentType string
+	http        *http.Client
+	retries     int
+	backoff     time.Duration
+	deadLetter  string
+
+	mu sync.Mutex
+}
+
+// Option configures a Notifier.
+type Option func(*Notifier) error
+
+// WithSecret signs every body with HMAC-SHA256 using secret.
+// The signature is sent as "sha256=<hex>" in the X-Scrapejestad-Signature header.
+func WithSecret(secret string) Option {
+	return func(n *Notifier) error {
+		n.secret = []byte(secret)
+		return nil
+	}
+}
+
+// WithTemplate renders bodies with a text/template instead of plain JSON.
+// The template is executed with a Payload and has a "json" function
+// that encodes its argument.
+func WithTemplate(text, contentType string) Option {
+	return func(n *Notifier) error {
+		t, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(text)
+		if err != nil {
+			return fmt.Errorf("error parsing webhook template: %v", err)
+		}
+		n.tmpl = t
+		if contentType != "" {
+			n.contentType = contentType
+		}
+		return nil
+	}
+}
+
+// WithRetries sets how many times a failing delivery is retried,
+// waiting backoff before the first retry and twice as long for every next one.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(n *Notifier) error {
+		n.retries = retries
+		n.backoff = backoff
+		return nil
+	}
+}
+
+// WithDeadLetter appends deliveries that keep failing to the file at path.
+func WithDeadLetter(path string) Option {
+	return func(n *Notifier) error {
+		n.deadLetter = path
+		return nil
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for deliveries.
+func WithHTTPClient(h *http.Client) Option {
+	return func(n *Notifier) error {
+		n.http = h
+		return nil
+	}
+}
+
+// New returns a notifier delivering to urls.
+func New(urls []string, opts ...Option) (*Notifier, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no webhook URLs")
+	}
+	n := &Notifier{
+		urls:        urls,
+		contentType: "application/json",
+		http:        &http.Client{Timeout: 10 * time.Second},
+		retries:     3,
+		backoff:     time.Second,
+	}
+	for _, o := range opts {
+		if err := o(n); err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+// Notify delivers an alert event.
+func (n *Notifier) Notify(ctx context.Context, e alerts.Event) error {
+	return n.Send(ctx, Payload{Kind: KindAlert, SentAt: time.Now(), Alert: &e})
+}
+
+// NotifyReadings delivers new readings.
+func (n *Notifier) NotifyReadings(ctx context.Context, readings []scrapejestad.Reading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	return n.Send(ctx, Payload{Kind: KindReadings, SentAt: time.Now(), Readings: readings})
+}
+
+// Send renders the payload and delivers it to every URL.
+func (n *Notifier) Send(ctx context.Context, p Payload) error {
+	body, err := n.render(p)
+	if err != nil {
+		return err
+	}
+	var errs []string
+	for _, u := range n.urls {
+		if err := n.deliver(ctx, u, p.Kind, body); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("error delivering webhook: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (n *Notifier) render(p Payload) ([]byte, error) {
+	if n.tmpl == nil {
+		return json.Marshal(p)
+	}
+	var b bytes.Buffer
+	if err := n.tmpl.Execute(&b, p); err != nil {
+		return nil, fmt.Errorf("error rendering webhook template: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// deliver POSTs body to u, retrying on network errors, 429 and 5xx.
+func (n *Notifier) deliver(ctx context.Context, u, kind string, body []byte) error {
+	var err error
+	wait := n.backoff
+	attempts := 0
+	for attempts <= n.retries {
+		if attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		attempts++
+
+		var retry bool
+		retry, err = n.post(ctx, u, kind, body)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			break
+		}
+	}
+	if dlErr := n.writeDeadLetter(u, kind, body, attempts, err); dlErr != nil {
+		return fmt.Errorf("%v (dead letter: %v)", err, dlErr)
+	}
+	return err
+}
+
+func (n *Notifier) post(ctx context.Context, u, kind string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", n.contentType)
+	req.Header.Set(EventHeader, kind)
+	if n.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	res, err := n.http.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("error posting to '%s': %v", u, err)
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("error posting to '%s': unexpected status %d", u, res.StatusCode)
+}
+
+// deadLetter is a failed delivery as written to the dead letter file.
+type deadLetter struct {
+	URL      string          `json:"url"`
+	Kind     string          `json:"kind"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Time     time.Time       `json:"time"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	RawBody  string          `json:"raw_body,omitempty"`
+}
+
+func (n *Notifier) writeDeadLetter(u, kind string, body []byte, attempts int, cause error) error {
+	if n.deadLetter == "" {
+		return nil
+	}
+	d := deadLetter{URL: u, Kind: kind, Attempts: attempts, Error: cause.Error(), Time: time.Now()}
+	if json.Valid(body) {
+		d.Body = body
+	} else {
+		d.RawBody = string(body)
+	}
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.deadLetter, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Sign returns the signature header value for body.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body.
+// Receivers use it to check the X-Scrapejestad-Signature header.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiskeben/scrapejestad"
+	"github.com/fiskeben/scrapejestad/alerts"
+)
+
+// receiver records the requests it gets and fails the first failures of them.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header)
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(rc.status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func Test_notifyAlertSigned(t *testing.T) {
+	rc := &receiver{failures: 2, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	n, err := New([]string{srv.URL}, WithSecret("s3cret"), WithRetries(3, time.Millisecond))
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+	ev := alerts.Event{Rule: "frost", SensorID: "242", State: alerts.Firing, Value: -1}
+	if err := n.Notify(context.Background(), ev); err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+
+	if len(rc.bodies) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(rc.bodies))
+	}
+	body := rc.bodies[2]
+	h := rc.headers[2]
+	if !Verify([]byte("s3cret"), body, h.Get(SignatureHeader)) {
+		t.Errorf("invalid signature '%s'", h.Get(SignatureHeader))
+	}
+	if h.Get(EventHeader) != KindAlert {
+		t.Errorf("expected event header '%s', got '%s'", KindAlert, h.Get(EventHeader))
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("error decoding body: %v", err)
+	}
+	if p.Alert == nil || p.Alert.Rule != "frost" {
+		t.Errorf("expected frost alert, got %+v", p.Alert)
+	}
+}
+
+func Test_notifyReadingsTemplate(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	tmpl := `{"text": "{{len .Readings}} new readings, first from {{(index .Readings 0).SensorID}}", "ids": {{json .Kind}}}`
+	n, err := New([]string{srv.URL}, WithTemplate(tmpl, ""))
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+	readings := []scrapejestad.Reading{{SensorID: "242"}, {SensorID: "243"}}
+	if err := n.NotifyReadings(context.Background(), readings); err != nil {
+		t.Fatalf("error notifying: %v", err)
+	}
+
+	want := `{"text": "2 new readings, first from 242", "ids": "readings"}`
+	if len(rc.bodies) != 1 || string(rc.bodies[0]) != want {
+		t.Errorf("expected body %s, got %q", want, rc.bodies)
+	}
+	if rc.headers[0].Get(SignatureHeader) != "" {
+		t.Errorf("expected no signature without secret")
+	}
+}
+
+func Test_deadLetter(t *testing.T) {
+	rc := &receiver{failures: 10, status: http.StatusBadRequest}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	n, err := New([]string{srv.URL}, WithRetries(3, time.Millisecond), WithDeadLetter(path))
+	if err != nil {
+		t.Fatalf("error creating notifier: %v", err)
+	}
+	if err := n.Notify(context.Background(), alerts.Event{Rule: "offline"}); err == nil {
+		t.Fatalf("expected error")
+	}
+	rc.status = http.StatusInternalServerError
+	if err := n.Notify(context.Background(), alerts.Event{Rule: "battery"}); err == nil {
+		t.Fatalf("expected error")
+	}
+
+	// 400 is not retried, 500 is.
+	if len(rc.bodies) != 5 {
+		t.Errorf("expected 5 attempts, got %d", len(rc.bodies))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening dead letter file: %v", err)
+	}
+	defer f.Close()
+	var letters []deadLetter
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var d deadLetter
+		if err := json.Unmarshal(s.Bytes(), &d); err != nil {
+			t.Fatalf("error decoding dead letter: %v", err)
+		}
+		letters = append(letters, d)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(letters))
+	}
+	if letters[0].Attempts != 1 || letters[1].Attempts != 4 {
+		t.Errorf("expected 1 and 4 attempts, got %d and %d", letters[0].Attempts, letters[1].Attempts)
+	}
+	var p Payload
+	if err := json.Unmarshal(letters[1].Body, &p); err != nil || p.Alert == nil || p.Alert.Rule != "battery" {
+		t.Errorf("expected battery alert in dead letter, got %s", letters[1].Body)
+	}
+}